@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// API key is formatted as prefix, followed by hex of its ID and its secret
+const (
+	apiKeyPrefix     = "nvrkey_"
+	apiKeyIDSize     = 8
+	apiKeySecretSize = 32
+)
+
+// Minimum time between saving the last used time of API key,
+// so the database is not written on every request.
+const apiKeyUsageInterval = time.Minute
+
+// apiKeyRecord is API key that saved in database. Only SHA-256 hash of the key's
+// secret is saved, since the secret is random and long enough to not need salt.
+type apiKeyRecord struct {
+	Username string    `json:"username"`
+	Name     string    `json:"name"`
+	Hash     string    `json:"hash"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// createAPIKey saves a new API key for user, then returns
+// the key's data along with the key itself.
+func (h *WebHandler) createAPIKey(username string, request APIKeyRequest) (APIKey, error) {
+	// Generate ID and secret of key
+	btID := make([]byte, apiKeyIDSize)
+	btSecret := make([]byte, apiKeySecretSize)
+	if _, err := rand.Read(btID); err != nil {
+		return APIKey{}, err
+	}
+	if _, err := rand.Read(btSecret); err != nil {
+		return APIKey{}, err
+	}
+
+	keyID := hex.EncodeToString(btID)
+	secret := hex.EncodeToString(btSecret)
+	record := apiKeyRecord{
+		Username: username,
+		Name:     request.Name,
+		Hash:     hashAPIKeySecret(secret),
+		Scopes:   request.Scopes,
+		Created:  time.Now(),
+		Expires:  request.Expires,
+	}
+
+	// Save to database
+	err := h.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("api-key"))
+		if err != nil {
+			return err
+		}
+
+		return putAPIKeyRecord(bucket, keyID, record)
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	apiKey := record.toAPIKey(keyID)
+	apiKey.Key = apiKeyPrefix + keyID + secret
+	return apiKey, nil
+}
+
+// getAPIKeys returns API keys of user.
+func (h *WebHandler) getAPIKeys(username string) []APIKey {
+	apiKeys := []APIKey{}
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("api-key"))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, val []byte) error {
+			var record apiKeyRecord
+			if json.Unmarshal(val, &record) == nil && record.Username == username {
+				apiKeys = append(apiKeys, record.toAPIKey(string(key)))
+			}
+			return nil
+		})
+	})
+
+	return apiKeys
+}
+
+// deleteAPIKeys removes API keys of user. If no key ID specified, all keys of user are removed.
+func (h *WebHandler) deleteAPIKeys(username string, keyIDs ...string) error {
+	return h.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("api-key"))
+		if bucket == nil {
+			return nil
+		}
+
+		// Find the keys that owned by user
+		userKeys := []string{}
+		bucket.ForEach(func(key, val []byte) error {
+			var record apiKeyRecord
+			if json.Unmarshal(val, &record) == nil && record.Username == username {
+				userKeys = append(userKeys, string(key))
+			}
+			return nil
+		})
+
+		if len(keyIDs) == 0 {
+			keyIDs = userKeys
+		}
+
+		for _, keyID := range keyIDs {
+			found := false
+			for _, userKey := range userKeys {
+				found = found || userKey == keyID
+			}
+
+			if !found {
+				return fmt.Errorf("api key %s of user %s is not exist", keyID, username)
+			}
+
+			if err := bucket.Delete([]byte(keyID)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// getAPIKeyAccount returns account of user that owns the API key. The account is
+// limited by the key's scopes, and the key's last used time is updated.
+func (h *WebHandler) getAPIKeyAccount(key string) (account, error) {
+	keyID, secret, err := splitAPIKey(key)
+	if err != nil {
+		return account{}, err
+	}
+
+	var record apiKeyRecord
+	err = h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("api-key"))
+		if bucket == nil {
+			return fmt.Errorf("api key is not valid")
+		}
+
+		data := bucket.Get([]byte(keyID))
+		if data == nil || json.Unmarshal(data, &record) != nil {
+			return fmt.Errorf("api key is not valid")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return account{}, err
+	}
+
+	hash := hashAPIKeySecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(record.Hash)) != 1 {
+		return account{}, fmt.Errorf("api key is not valid")
+	}
+
+	now := time.Now()
+	if !record.Expires.IsZero() && !now.Before(record.Expires) {
+		return account{}, fmt.Errorf("api key has been expired")
+	}
+
+	acc, err := h.getAccount(record.Username)
+	if err != nil {
+		return account{}, err
+	}
+
+	acc.KeyID = keyID
+	acc.limitScopes(record.Scopes)
+
+	if now.Sub(record.LastUsed) >= apiKeyUsageInterval {
+		h.saveAPIKeyUsage(keyID, now)
+	}
+
+	return acc, nil
+}
+
+// saveAPIKeyUsage saves the last used time of API key.
+func (h *WebHandler) saveAPIKeyUsage(keyID string, usedTime time.Time) {
+	err := h.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("api-key"))
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get([]byte(keyID))
+		if data == nil {
+			return nil
+		}
+
+		var record apiKeyRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+
+		record.LastUsed = usedTime
+		return putAPIKeyRecord(bucket, keyID, record)
+	})
+	if err != nil {
+		logrus.Warnf("failed to save usage of api key %s: %v\n", keyID, err)
+	}
+}
+
+func putAPIKeyRecord(bucket *bolt.Bucket, keyID string, record apiKeyRecord) error {
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(keyID), data)
+}
+
+func (record apiKeyRecord) toAPIKey(keyID string) APIKey {
+	return APIKey{
+		ID:       keyID,
+		Name:     record.Name,
+		Scopes:   record.Scopes,
+		Created:  record.Created,
+		Expires:  record.Expires,
+		LastUsed: record.LastUsed,
+	}
+}
+
+// isAPIKey checks if the bearer token is API key instead of access token.
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// splitAPIKey returns ID and secret of API key.
+func splitAPIKey(key string) (string, string, error) {
+	key = strings.TrimPrefix(key, apiKeyPrefix)
+	if len(key) != 2*(apiKeyIDSize+apiKeySecretSize) {
+		return "", "", fmt.Errorf("api key is not valid")
+	}
+
+	return key[:2*apiKeyIDSize], key[2*apiKeyIDSize:], nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
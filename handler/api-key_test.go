@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAPIKeyCameraAccess(t *testing.T) {
+	h, cleanup := newTestAuthHandler(t)
+	defer cleanup()
+
+	// Read-only key of admin still lists all cameras
+	adminKey, err := h.createAPIKey("admin", APIKeyRequest{Name: "dashboard", Scopes: []string{ScopeReadCameras}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cameras := listTestCameras(t, h, adminKey.Key); !reflect.DeepEqual(cameras, []string{"1", "2"}) {
+		t.Errorf("admin key: got cameras %v, want [1 2]", cameras)
+	}
+
+	// Key of viewer is limited to the viewer's cameras
+	viewerKey, err := h.createAPIKey("viewer", APIKeyRequest{Name: "dashboard", Scopes: []string{ScopeReadCameras}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cameras := listTestCameras(t, h, viewerKey.Key); !reflect.DeepEqual(cameras, []string{"1"}) {
+		t.Errorf("viewer key: got cameras %v, want [1]", cameras)
+	}
+
+	// The admin key is still limited to the routes of its scopes
+	r := httptest.NewRequest("POST", "/api/camera", nil)
+	r.Header.Set("Authorization", "Bearer "+adminKey.Key)
+	w := httptest.NewRecorder()
+	h.Authorize(RoleAdmin, ScopeManageCameras, h.APISaveCamera)(w, r, nil)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("admin key with read-cameras scope saves camera with status %d, want 403", w.Code)
+	}
+}
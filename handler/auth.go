@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role of user. Each role is allowed to do everything that the lower role can.
+// Viewer may only watch the live stream and recordings of its cameras, operator
+// may also control its cameras, while admin may manage cameras, users and setting.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// Scope of API key and access token, which limits the routes that may be accessed
+// using them. Request that uses session cookie is not limited by scope.
+const (
+	ScopeReadCameras   = "read-cameras"
+	ScopeManageCameras = "manage-cameras"
+	ScopeManageUsers   = "manage-users"
+	ScopePlayback      = "playback"
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// scopeRoles is the highest role that may be used with each scope. Request
+// that limited by scopes acts as the highest role of its scopes at most, even
+// when the user's own role is higher.
+var scopeRoles = map[string]string{
+	ScopeReadCameras:   RoleViewer,
+	ScopePlayback:      RoleViewer,
+	ScopeManageCameras: RoleAdmin,
+	ScopeManageUsers:   RoleAdmin,
+}
+
+// userRecord is the data of user that saved in database. User that saved
+// before roles exist only has its bcrypt hashed password, so it's read as admin.
+type userRecord struct {
+	Password string   `json:"password"`
+	Role     string   `json:"role"`
+	Cameras  []string `json:"cameras"`
+}
+
+// account is user that owns the login session of a request. Admin may access
+// all cameras, even when its role is limited by the scope of access token or API
+// key. When request is authenticated by API key, KeyID is set as well.
+type account struct {
+	Username   string
+	Role       string
+	Cameras    []string
+	AllCameras bool
+	KeyID      string
+	Scopes     []string
+	Scoped     bool
+}
+
+// accountKey is the key of account in request's context.
+type accountKey struct{}
+
+// Authorize wraps the handler, so it's only accessible by logged in user that has at
+// least the specified role. If the request uses API key or access token, it must also
+// have the specified scope. The user's account is put into the request's context.
+func (h *WebHandler) Authorize(role string, scope string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		acc, err := h.getSessionAccount(r)
+		checkError(err)
+
+		if !acc.hasRole(role) {
+			http.Error(w, fmt.Sprintf("user %s is not allowed to access this", acc.Username), http.StatusForbidden)
+			return
+		}
+
+		if acc.Scoped && !acc.hasScope(scope) {
+			credential := "access token"
+			if acc.KeyID != "" {
+				credential = "api key " + acc.KeyID
+			}
+
+			http.Error(w, fmt.Sprintf("%s doesn't have scope %s", credential, scope), http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), accountKey{}, acc)
+		next(w, r.WithContext(ctx), ps)
+	}
+}
+
+// AuthorizeCamera is like Authorize, but the user must also be allowed to access
+// the camera whose ID is in the route's parameter, either in :camID or :id.
+func (h *WebHandler) AuthorizeCamera(role string, scope string, next httprouter.Handle) httprouter.Handle {
+	return h.Authorize(role, scope, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		camID := ps.ByName("camID")
+		if camID == "" {
+			camID = ps.ByName("id")
+		}
+
+		acc := requestAccount(r)
+		if !acc.canAccessCamera(camID) {
+			http.Error(w, fmt.Sprintf("user %s is not allowed to access camera %s", acc.Username, camID), http.StatusForbidden)
+			return
+		}
+
+		next(w, r, ps)
+	})
+}
+
+// requestAccount returns account that put into request by Authorize.
+func requestAccount(r *http.Request) account {
+	acc, _ := r.Context().Value(accountKey{}).(account)
+	return acc
+}
+
+// checkPassword makes sure the password is correct for the user. While there are no
+// user registered yet, the default admin account is accepted, which is reported by
+// the returned flag.
+func (h *WebHandler) checkPassword(username string, password string) (bool, error) {
+	// Check if user's database is empty
+	dbIsEmpty := false
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("user"))
+		dbIsEmpty = bucket == nil || bucket.Stats().KeyN == 0
+		return nil
+	})
+
+	// If database still empty, and user uses default account, let him in
+	if dbIsEmpty && username == "admin" && password == "admin" {
+		return true, nil
+	}
+
+	// Get account data from database
+	var record userRecord
+	err := h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("user"))
+		if bucket == nil {
+			return fmt.Errorf("user is not exist")
+		}
+
+		data := bucket.Get([]byte(username))
+		if data == nil {
+			return fmt.Errorf("user is not exist")
+		}
+
+		record = parseUserRecord(data)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Compare password with database
+	err = bcrypt.CompareHashAndPassword([]byte(record.Password), []byte(password))
+	if err != nil {
+		return false, fmt.Errorf("username and password don't match")
+	}
+
+	return false, nil
+}
+
+// getSessionAccount returns account of user that owns the request's session.
+// Request that has bearer token or API key in its Authorization header uses the
+// token's account instead, so it doesn't need session cookie.
+func (h *WebHandler) getSessionAccount(r *http.Request) (account, error) {
+	if token := bearerToken(r); token != "" {
+		if isAPIKey(token) {
+			return h.getAPIKeyAccount(token)
+		}
+		return h.getTokenAccount(token)
+	}
+
+	username, err := h.getSessionUser(r)
+	if err != nil {
+		return account{}, err
+	}
+
+	return h.getAccount(username)
+}
+
+// getAccount returns account of the user from database. While there are no user
+// registered yet, the default admin account is used.
+func (h *WebHandler) getAccount(username string) (account, error) {
+	acc := account{Username: username}
+	err := h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("user"))
+		if bucket == nil || bucket.Stats().KeyN == 0 {
+			if username != "admin" {
+				return fmt.Errorf("user is not exist")
+			}
+
+			acc.Role = RoleAdmin
+			acc.AllCameras = true
+			return nil
+		}
+
+		data := bucket.Get([]byte(username))
+		if data == nil {
+			return fmt.Errorf("user is not exist")
+		}
+
+		record := parseUserRecord(data)
+		acc.Role = record.Role
+		acc.Cameras = record.Cameras
+		acc.AllCameras = record.Role == RoleAdmin
+		return nil
+	})
+
+	return acc, err
+}
+
+func (acc account) hasRole(role string) bool {
+	return roleLevels[acc.Role] >= roleLevels[role]
+}
+
+// limitScopes limits the account to the scopes of API key or access token. Its role
+// is lowered to the highest role of the scopes, which limits the routes it may access.
+// The cameras it may access are kept, so admin may still access all cameras.
+func (acc *account) limitScopes(scopes []string) {
+	maxRole := ""
+	for _, scope := range scopes {
+		if role := scopeRoles[scope]; roleLevels[role] > roleLevels[maxRole] {
+			maxRole = role
+		}
+	}
+
+	if roleLevels[maxRole] < roleLevels[acc.Role] {
+		acc.Role = maxRole
+	}
+
+	acc.Scopes = scopes
+	acc.Scoped = true
+}
+
+func (acc account) hasScope(scope string) bool {
+	for _, s := range acc.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessCamera checks if camera is in the user's allowed cameras.
+// Admin is always allowed to access all cameras.
+func (acc account) canAccessCamera(camID string) bool {
+	if acc.AllCameras {
+		return true
+	}
+
+	for _, allowedID := range acc.Cameras {
+		if allowedID == camID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseUserRecord parses user's data from database. The old user data which
+// only contains bcrypt hash is not a valid JSON, so it's read as admin.
+func parseUserRecord(data []byte) userRecord {
+	var record userRecord
+	err := json.Unmarshal(data, &record)
+	if err != nil || record.Password == "" {
+		return userRecord{
+			Password: string(data),
+			Role:     RoleAdmin,
+		}
+	}
+
+	return record
+}
+
+// isValidScope checks if scope is one of the known scopes.
+func isValidScope(scope string) bool {
+	_, exist := scopeRoles[scope]
+	return exist
+}
+
+// isValidRole checks if role is one of the known roles.
+func isValidRole(role string) bool {
+	_, exist := roleLevels[role]
+	return exist
+}
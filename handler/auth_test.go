@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestAuthHandler prepares handler with cameras 1 and 2, an admin and
+// a viewer that only allowed to access camera 1.
+func newTestAuthHandler(t *testing.T) (*WebHandler, func()) {
+	dir, err := ioutil.TempDir("", "cygnus-nvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(fp.Join(dir, "cygnus.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		cameraBucket, err := tx.CreateBucketIfNotExists([]byte("camera"))
+		if err != nil {
+			return err
+		}
+
+		for _, camID := range []string{"1", "2"} {
+			bucket, err := cameraBucket.CreateBucketIfNotExists([]byte(camID))
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte("name"), []byte("Camera "+camID))
+			if err != nil {
+				return err
+			}
+		}
+
+		userBucket, err := tx.CreateBucketIfNotExists([]byte("user"))
+		if err != nil {
+			return err
+		}
+
+		err = putUserRecord(userBucket, "admin", userRecord{Password: "hash", Role: RoleAdmin})
+		if err != nil {
+			return err
+		}
+
+		return putUserRecord(userBucket, "viewer", userRecord{Password: "hash", Role: RoleViewer, Cameras: []string{"1"}})
+	})
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return &WebHandler{DB: db}, cleanup
+}
+
+// listTestCameras requests GET /api/camera using the bearer credential, then
+// returns the listed camera IDs.
+func listTestCameras(t *testing.T, h *WebHandler, credential string) []string {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/api/camera", nil)
+	r.Header.Set("Authorization", "Bearer "+credential)
+	w := httptest.NewRecorder()
+	h.Authorize(RoleViewer, ScopeReadCameras, h.APIGetCameraList)(w, r, nil)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var cameras map[string]CameraSummary
+	err := json.Unmarshal(w.Body.Bytes(), &cameras)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	camIDs := []string{}
+	for camID := range cameras {
+		camIDs = append(camIDs, camID)
+	}
+	sort.Strings(camIDs)
+
+	return camIDs
+}
+
+func TestTokenCameraAccess(t *testing.T) {
+	h, cleanup := newTestAuthHandler(t)
+	defer cleanup()
+
+	tests := []struct {
+		username string
+		scopes   string
+		cameras  []string
+	}{
+		{"admin", "read-cameras", []string{"1", "2"}},
+		{"admin", "read-cameras playback", []string{"1", "2"}},
+		{"admin", "read-cameras playback manage-cameras manage-users", []string{"1", "2"}},
+		{"viewer", "read-cameras", []string{"1"}},
+	}
+
+	now := time.Now()
+	for _, tt := range tests {
+		token, err := createToken(tokenClaims{
+			Subject:  tt.username,
+			Scope:    tt.scopes,
+			IssuedAt: now.Unix(),
+			Expires:  now.Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Scopes only limit the routes, not the cameras of user
+		cameras := listTestCameras(t, h, token)
+		if !reflect.DeepEqual(cameras, tt.cameras) {
+			t.Errorf("token of %s with scopes %q: got cameras %v, want %v", tt.username, tt.scopes, cameras, tt.cameras)
+		}
+	}
+}
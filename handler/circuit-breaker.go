@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Count of consecutive failures before the circuit of camera is opened
+	breakerFailureThreshold = 3
+
+	// Initial and maximum wait time between probes while the circuit is open
+	breakerMinBackoff = 5 * time.Second
+	breakerMaxBackoff = 5 * time.Minute
+)
+
+// circuitBreakers keeps track of the circuit breaker of each camera.
+type circuitBreakers struct {
+	sync.Mutex
+	items map[string]*circuitBreaker
+}
+
+// circuitBreaker stops requests to camera after it failed repeatedly. While the
+// circuit is open, requests fail immediately and a background probe retries the
+// camera with exponential backoff. Once the probe succeed, the circuit is closed.
+type circuitBreaker struct {
+	sync.Mutex
+	failures  int
+	open      bool
+	backoff   time.Duration
+	nextProbe time.Time
+	stop      chan struct{}
+}
+
+// circuitOpenError is returned when camera is not requested because its circuit is open.
+type circuitOpenError struct {
+	camID      string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("camera %s is unavailable, retry in %s", e.camID, e.retryAfter.Round(time.Second))
+}
+
+// errNoHLSStream is returned when HLS is requested from camera that serves JPEG frames.
+var errNoHLSStream = fmt.Errorf("camera doesn't serve HLS stream")
+
+// getCircuitBreaker returns the circuit breaker of camera. It returns nil if the
+// circuit breakers are not prepared yet, in which case camera is always requested.
+func (h *WebHandler) getCircuitBreaker(camID string) *circuitBreaker {
+	if h.breakers == nil {
+		return nil
+	}
+
+	h.breakers.Lock()
+	defer h.breakers.Unlock()
+
+	breaker, exist := h.breakers.items[camID]
+	if !exist {
+		breaker = &circuitBreaker{stop: make(chan struct{})}
+		h.breakers.items[camID] = breaker
+	}
+
+	return breaker
+}
+
+// resetCircuitBreaker removes the circuit breaker of camera and stops its probe,
+// e.g. because camera data is changed so it should be requested again.
+func (h *WebHandler) resetCircuitBreaker(camID string) {
+	if h.breakers == nil {
+		return
+	}
+
+	h.breakers.Lock()
+	defer h.breakers.Unlock()
+
+	if breaker, exist := h.breakers.items[camID]; exist {
+		close(breaker.stop)
+		delete(h.breakers.items, camID)
+	}
+}
+
+// openCameraSource returns the opened source of camera, unless the camera's
+// circuit is open. Failure while opening the source is counted by the breaker.
+func (h *WebHandler) openCameraSource(cam Camera) (CameraSource, error) {
+	breaker := h.getCircuitBreaker(cam.ID)
+	if err := breaker.allow(cam.ID); err != nil {
+		return nil, err
+	}
+
+	source, err := h.getCameraSource(cam)
+	h.recordCameraResult(cam, breaker, err)
+	return source, err
+}
+
+// useCameraSource calls fn with the opened source of camera, unless the camera's
+// circuit is open. If fn failed, the source is closed so it will be reopened later.
+// The call waits for a free request slot of camera, unless the context is canceled.
+// Failure caused by the canceled context is not counted, since it's not camera's fault.
+func (h *WebHandler) useCameraSource(ctx context.Context, cam Camera, fn func(source CameraSource) error) error {
+	breaker := h.getCircuitBreaker(cam.ID)
+	if err := breaker.allow(cam.ID); err != nil {
+		return err
+	}
+
+	release, err := h.acquireCameraSlot(ctx, cam.ID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	source, err := h.getCameraSource(cam)
+	if err == nil {
+		err = fn(source)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if isCameraFailure(err) {
+			h.releaseCameraSource(cam.ID, source)
+		}
+	}
+
+	h.recordCameraResult(cam, breaker, err)
+	return err
+}
+
+// recordCameraResult counts the failure of request to camera. When the camera
+// failed too many times, its circuit is opened and the background probe is started.
+func (h *WebHandler) recordCameraResult(cam Camera, breaker *circuitBreaker, err error) {
+	if breaker == nil {
+		return
+	}
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	if !isCameraFailure(err) {
+		if err == nil {
+			breaker.failures = 0
+		}
+		return
+	}
+
+	breaker.failures++
+	if breaker.open || breaker.failures < breakerFailureThreshold {
+		return
+	}
+
+	breaker.open = true
+	breaker.backoff = breakerMinBackoff
+	breaker.nextProbe = time.Now().Add(breaker.backoff)
+	logrus.Warnf("circuit of camera %s is opened after %d failures: %v\n", cam.ID, breaker.failures, err)
+
+	go h.probeCircuit(cam, breaker)
+}
+
+// probeCircuit retries the camera with exponential backoff until it's reachable
+// again, then closes its circuit. It stops when the breaker is reset.
+func (h *WebHandler) probeCircuit(cam Camera, breaker *circuitBreaker) {
+	for {
+		breaker.Lock()
+		wait := time.Until(breaker.nextProbe)
+		breaker.Unlock()
+
+		select {
+		case <-breaker.stop:
+			return
+		case <-time.After(wait):
+		}
+
+		source, err := h.getCameraSource(cam)
+		if err == nil {
+			err = pingSource(context.Background(), source)
+			if err != nil {
+				h.releaseCameraSource(cam.ID, source)
+			}
+		}
+
+		breaker.Lock()
+		if err == nil {
+			breaker.open = false
+			breaker.failures = 0
+			breaker.Unlock()
+			logrus.Infof("circuit of camera %s is closed\n", cam.ID)
+			return
+		}
+
+		breaker.backoff *= 2
+		if breaker.backoff > breakerMaxBackoff {
+			breaker.backoff = breakerMaxBackoff
+		}
+		breaker.nextProbe = time.Now().Add(breaker.backoff)
+		breaker.Unlock()
+	}
+}
+
+// allow returns error when the circuit is open.
+func (b *circuitBreaker) allow(camID string) error {
+	if b == nil {
+		return nil
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.open {
+		return nil
+	}
+
+	retryAfter := time.Until(b.nextProbe)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+
+	return &circuitOpenError{camID: camID, retryAfter: retryAfter}
+}
+
+// pingSource checks if the opened source still works, by fetching
+// its playlist, or its latest frame for frame source.
+func pingSource(ctx context.Context, source CameraSource) error {
+	if _, isFrameSource := source.(frameSource); isFrameSource {
+		_, err := source.Snapshot()
+		return err
+	}
+
+	_, err := source.Playlist(ctx)
+	return err
+}
+
+// isCameraFailure checks if error means camera is unreachable or unusable,
+// instead of request that not supported or not found in the camera.
+func isCameraFailure(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *circuitOpenError:
+		return false
+	case *upstreamError:
+		return e.statusCode != http.StatusNotFound
+	}
+
+	return err != errSnapshotNotSupported && err != errNoHLSStream
+}
+
+// writeUpstreamError responds with HTTP status that suitable for error from camera
+// source. It returns false if the error is not caused by camera, so it's not written.
+func writeUpstreamError(w http.ResponseWriter, err error) bool {
+	status := upstreamStatus(err)
+	if status == 0 {
+		return false
+	}
+
+	if e, isCircuitOpen := err.(*circuitOpenError); isCircuitOpen {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+	}
+
+	http.Error(w, err.Error(), status)
+	return true
+}
@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	fp "path/filepath"
+	"strings"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Status of export job
+const (
+	exportQueued  = "queued"
+	exportRunning = "running"
+	exportDone    = "done"
+	exportFailed  = "failed"
+)
+
+const (
+	// Duration before a finished job and its file are deleted
+	exportExpiration = 24 * time.Hour
+
+	// Interval for deleting the expired jobs
+	exportJanitorInterval = time.Hour
+)
+
+// exporter processes the export jobs one by one.
+type exporter struct {
+	queue chan string
+}
+
+// StartExporter starts the worker for export jobs. Jobs that unfinished
+// when the server stopped are restarted from the beginning. It also starts
+// deleting the finished jobs and their files once they are expired.
+func (h *WebHandler) StartExporter() {
+	h.exporter = &exporter{
+		queue: make(chan string, 100),
+	}
+
+	// Find unfinished jobs
+	unfinishedJobs := []ExportJob{}
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("export"))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, val []byte) error {
+			var job ExportJob
+			if err := json.Unmarshal(val, &job); err != nil {
+				return nil
+			}
+
+			if job.Status == exportQueued || job.Status == exportRunning {
+				unfinishedJobs = append(unfinishedJobs, job)
+			}
+
+			return nil
+		})
+	})
+
+	// Start worker
+	go func() {
+		for jobID := range h.exporter.queue {
+			h.runExportJob(jobID)
+		}
+	}()
+
+	// Requeue the unfinished jobs
+	for _, job := range unfinishedJobs {
+		job.Status = exportQueued
+		job.Progress = 0
+		h.enqueueExportJob(job)
+		logrus.Infoln("resume export job", job.ID)
+	}
+
+	// Start janitor
+	go func() {
+		for {
+			h.deleteExpiredExports()
+			time.Sleep(exportJanitorInterval)
+		}
+	}()
+}
+
+func (h *WebHandler) enqueueExportJob(job ExportJob) error {
+	err := h.saveExportJob(job)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		h.exporter.queue <- job.ID
+	}()
+
+	return nil
+}
+
+func (h *WebHandler) runExportJob(jobID string) {
+	job, err := h.getExportJob(jobID)
+	if err != nil {
+		logrus.Warnln("failed to run export job:", err)
+		return
+	}
+
+	job.Status = exportRunning
+	h.saveExportJob(job)
+
+	err = h.exportRecording(&job)
+	job.ExpiresAt = time.Now().Add(exportExpiration)
+	if err != nil {
+		job.Status = exportFailed
+		job.Error = err.Error()
+		logrus.Warnf("export job %s failed: %v\n", job.ID, err)
+	} else {
+		job.Status = exportDone
+		job.Progress = 1
+		job.URL = path.Join("/", "api", "camera", job.CameraID, "export", job.ID, "download")
+		logrus.Infof("export job %s finished\n", job.ID)
+	}
+
+	h.saveExportJob(job)
+}
+
+// exportRecording stitches the recorded segments that covers the time
+// range of the job, then trims it to the nearest keyframes.
+func (h *WebHandler) exportRecording(job *ExportJob) error {
+	segments, err := h.getIndexedSegments(job.CameraID, job.Start, job.End)
+	if err != nil {
+		return err
+	}
+
+	if len(segments) == 0 {
+		return fmt.Errorf("there are no recording in the specified time range")
+	}
+
+	// Prepare destination file
+	dstPath := h.exportPath(job.ID)
+	err = os.MkdirAll(fp.Dir(dstPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := dstPath + ".tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer dst.Close()
+
+	// Write each segment. Only the first and the last segment need to be trimmed.
+	writer := remux.NewFMP4Writer(dst)
+	for i, segment := range segments {
+		var start, end time.Duration
+		if i == 0 {
+			start = job.Start.Sub(segment.Time)
+		}
+
+		if i == len(segments)-1 {
+			end = job.End.Sub(segment.Time)
+		}
+
+		err = h.copySegmentRange(writer, segment, start, end)
+		if err != nil {
+			return err
+		}
+
+		job.Progress = float64(i+1) / float64(len(segments))
+		h.saveExportJob(*job)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	err = dst.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, dstPath)
+}
+
+func (h *WebHandler) copySegmentRange(writer *remux.FMP4Writer, segment recordedSegment, start, end time.Duration) error {
+	src, err := os.Open(segment.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return remux.CopyRange(writer, src, start, end)
+}
+
+// deleteExpiredExports deletes the finished jobs that already expired
+// along with their files. Files that don't belong to any job are deleted
+// as well, e.g. file of job that deleted while the server stopped.
+func (h *WebHandler) deleteExpiredExports() {
+	now := time.Now()
+	activeJobs := make(map[string]struct{})
+	expiredJobs := []string{}
+
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("export"))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, val []byte) error {
+			var job ExportJob
+			if err := json.Unmarshal(val, &job); err != nil {
+				return nil
+			}
+
+			// Job that finished by the previous version has no expiry time
+			expiresAt := job.ExpiresAt
+			if expiresAt.IsZero() {
+				expiresAt = job.CreatedAt.Add(exportExpiration)
+			}
+
+			finished := job.Status == exportDone || job.Status == exportFailed
+			if finished && now.After(expiresAt) {
+				expiredJobs = append(expiredJobs, job.ID)
+			} else {
+				activeJobs[job.ID] = struct{}{}
+			}
+
+			return nil
+		})
+	})
+
+	// Delete the expired jobs
+	for _, jobID := range expiredJobs {
+		err := os.Remove(h.exportPath(jobID))
+		if err != nil && !os.IsNotExist(err) {
+			logrus.Warnf("failed to delete file of export job %s: %v\n", jobID, err)
+			continue
+		}
+
+		h.DB.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte("export"))
+			if bucket == nil {
+				return nil
+			}
+			return bucket.Delete([]byte(jobID))
+		})
+
+		logrus.Infoln("delete expired export job", jobID)
+	}
+
+	// Delete files that don't belong to any job
+	items, err := ioutil.ReadDir(h.exportDir())
+	if err != nil {
+		return
+	}
+
+	for _, item := range items {
+		jobID := strings.TrimSuffix(item.Name(), ".tmp")
+		jobID = strings.TrimSuffix(jobID, ".mp4")
+		if _, active := activeJobs[jobID]; !active {
+			os.Remove(fp.Join(h.exportDir(), item.Name()))
+		}
+	}
+}
+
+func (h *WebHandler) exportPath(jobID string) string {
+	return fp.Join(h.exportDir(), jobID+".mp4")
+}
+
+func (h *WebHandler) exportDir() string {
+	return fp.Join(h.StorageDir, ".export")
+}
+
+func (h *WebHandler) getExportJob(jobID string) (ExportJob, error) {
+	var job ExportJob
+	err := h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("export"))
+		if bucket == nil {
+			return fmt.Errorf("export job %s doesn't exist", jobID)
+		}
+
+		val := bucket.Get([]byte(jobID))
+		if val == nil {
+			return fmt.Errorf("export job %s doesn't exist", jobID)
+		}
+
+		return json.Unmarshal(val, &job)
+	})
+
+	return job, err
+}
+
+func (h *WebHandler) saveExportJob(job ExportJob) error {
+	return h.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("export"))
+		if err != nil {
+			return err
+		}
+
+		val, err := json.Marshal(&job)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(job.ID), val)
+	})
+}
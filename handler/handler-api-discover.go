@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	nurl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/onvif"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Duration for waiting the responses of WS-Discovery probe
+const discoveryTimeout = 3 * time.Second
+
+// APIDiscoverCameras is handler for POST /api/camera/discover
+func (h *WebHandler) APIDiscoverCameras(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request. Credential is optional, but most
+	// camera requires it for getting the stream URI.
+	var request DiscoverRequest
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&request)
+		checkError(err)
+	}
+
+	// Find ONVIF devices in local network
+	devices, err := onvif.Discover(discoveryTimeout)
+	checkError(err)
+
+	// Query each device concurrently
+	var wg sync.WaitGroup
+	candidates := make([]DiscoveredCamera, len(devices))
+	for i, device := range devices {
+		wg.Add(1)
+		go func(i int, device onvif.Device) {
+			defer wg.Done()
+			candidates[i] = queryONVIFDevice(device, request.Username, request.Password)
+		}(i, device)
+	}
+	wg.Wait()
+
+	// Encode to JSON
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&candidates)
+	checkError(err)
+}
+
+// queryONVIFDevice fetches information and stream URI of discovered device, then
+// returns it as camera that can be saved. If it failed, the error is included in
+// the candidate, so user can retry with the correct credential.
+func queryONVIFDevice(device onvif.Device, username, password string) DiscoveredCamera {
+	deviceURL := device.XAddrs[0]
+	candidate := DiscoveredCamera{
+		Camera: Camera{
+			Type:     "rtsp",
+			Username: username,
+			Password: password,
+		},
+		Address: deviceURL,
+	}
+	candidate.OnvifURL = deviceURL
+
+	// By default, use the host as camera's name
+	if parsedURL, err := nurl.Parse(deviceURL); err == nil {
+		candidate.Name = parsedURL.Hostname()
+	}
+
+	client := onvif.NewClient(deviceURL, username, password)
+	info, err := client.GetDeviceInformation()
+	if err != nil {
+		candidate.Error = err.Error()
+		return candidate
+	}
+
+	candidate.Manufacturer = info.Manufacturer
+	candidate.Model = info.Model
+	if name := strings.TrimSpace(info.Manufacturer + " " + info.Model); name != "" {
+		candidate.Name = name
+	}
+
+	streamURI, err := client.GetStreamURI()
+	if err != nil {
+		candidate.Error = err.Error()
+		return candidate
+	}
+
+	// Make sure the stream can be handled by RTSP source
+	candidate.URL = streamURI
+	if _, err = newCameraSource(candidate.Camera); err != nil {
+		candidate.Error = fmt.Sprintf("stream uri %s is not supported: %v", streamURI, err)
+	}
+
+	return candidate
+}
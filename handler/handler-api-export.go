@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIExportCamera is handler for POST /api/camera/:id/export
+func (h *WebHandler) APIExportCamera(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	camID := ps.ByName("id")
+	cam, err := h.getCamera(camID)
+	checkError(err)
+
+	err = checkIndexedCamera(cam)
+	checkError(err)
+
+	// Decode request
+	var request ExportRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	checkError(err)
+
+	if !request.Start.Before(request.End) {
+		panic(fmt.Errorf("start time must be before end time"))
+	}
+
+	// Create and queue the job
+	jobID, err := uuid.NewV4()
+	checkError(err)
+
+	job := ExportJob{
+		ID:        jobID.String(),
+		CameraID:  camID,
+		Start:     request.Start,
+		End:       request.End,
+		Status:    exportQueued,
+		CreatedAt: time.Now(),
+	}
+
+	err = h.enqueueExportJob(job)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&job)
+	checkError(err)
+}
+
+// APIGetExportJob is handler for GET /api/camera/:id/export/:job
+func (h *WebHandler) APIGetExportJob(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	job := h.getCameraExportJob(ps.ByName("id"), ps.ByName("job"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	err := json.NewEncoder(w).Encode(&job)
+	checkError(err)
+}
+
+// APIDownloadExport is handler for GET /api/camera/:id/export/:job/download
+func (h *WebHandler) APIDownloadExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	job := h.getCameraExportJob(ps.ByName("id"), ps.ByName("job"))
+	if job.Status != exportDone {
+		panic(fmt.Errorf("export job %s is not finished yet", job.ID))
+	}
+
+	// Open the exported file
+	src, err := os.Open(h.exportPath(job.ID))
+	checkError(err)
+	defer src.Close()
+
+	info, err := src.Stat()
+	checkError(err)
+
+	// Serve the file as attachment
+	fileName := fmt.Sprintf("camera-%s-%s.mp4", job.CameraID, job.Start.Format("20060102-150405"))
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	http.ServeContent(w, r, fileName, info.ModTime(), src)
+}
+
+func (h *WebHandler) getCameraExportJob(camID string, jobID string) ExportJob {
+	job, err := h.getExportJob(jobID)
+	checkError(err)
+
+	if job.CameraID != camID {
+		panic(fmt.Errorf("export job %s doesn't exist", jobID))
+	}
+
+	return job
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIGetAPIKeys is handler for GET /api/user/:username/keys
+func (h *WebHandler) APIGetAPIKeys(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	username := ps.ByName("username")
+	if !checkUserAccess(w, r, username) {
+		return
+	}
+
+	apiKeys := h.getAPIKeys(username)
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&apiKeys)
+	checkError(err)
+}
+
+// APICreateAPIKey is handler for POST /api/user/:username/keys
+func (h *WebHandler) APICreateAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	username := ps.ByName("username")
+	if !checkUserAccess(w, r, username) {
+		return
+	}
+
+	// Decode request
+	var request APIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	checkError(err)
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		panic(fmt.Errorf("name of api key must not empty"))
+	}
+
+	if len(request.Scopes) == 0 {
+		panic(fmt.Errorf("api key must have at least one scope"))
+	}
+
+	// Request that limited by scopes, i.e. the one that uses API key or
+	// access token, may not create key with scope that it doesn't have.
+	acc := requestAccount(r)
+	for _, scope := range request.Scopes {
+		if !isValidScope(scope) {
+			panic(fmt.Errorf("scope %s is not valid", scope))
+		}
+
+		if acc.Scoped && !acc.hasScope(scope) {
+			http.Error(w, fmt.Sprintf("scope %s exceeds scopes of the current credential", scope), http.StatusForbidden)
+			return
+		}
+	}
+
+	if !request.Expires.IsZero() && !request.Expires.After(time.Now()) {
+		panic(fmt.Errorf("expiry time of api key must be in the future"))
+	}
+
+	// Make sure user exists
+	_, err = h.getAccount(username)
+	checkError(err)
+
+	// Create key
+	apiKey, err := h.createAPIKey(username, request)
+	checkError(err)
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&apiKey)
+	checkError(err)
+}
+
+// APIDeleteAPIKey is handler for DELETE /api/user/:username/keys?id=:keyID
+func (h *WebHandler) APIDeleteAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	username := ps.ByName("username")
+	if !checkUserAccess(w, r, username) {
+		return
+	}
+
+	keyID := r.URL.Query().Get("id")
+	if keyID == "" {
+		panic(fmt.Errorf("id of api key must not empty"))
+	}
+
+	err := h.deleteAPIKeys(username, keyID)
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
+
+// checkUserAccess makes sure the request's user is the specified user, or an
+// admin that may manage all users. If not, forbidden status is written.
+func checkUserAccess(w http.ResponseWriter, r *http.Request, username string) bool {
+	acc := requestAccount(r)
+	if acc.Username == username || acc.hasRole(RoleAdmin) {
+		return true
+	}
+
+	http.Error(w, fmt.Sprintf("user %s is not allowed to manage user %s", acc.Username, username), http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIControlPTZ is handler for POST /api/camera/:id/ptz
+func (h *WebHandler) APIControlPTZ(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	var request PTZRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	checkError(err)
+
+	err = request.validate()
+	checkError(err)
+
+	// Control the camera
+	cam, err := h.getCamera(ps.ByName("id"))
+	checkError(err)
+
+	preset, err := h.controlPTZ(cam, request)
+	checkError(err)
+
+	// For set-preset, return the saved preset
+	if preset != nil {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(preset)
+		checkError(err)
+		return
+	}
+
+	fmt.Fprint(w, 1)
+}
+
+// APIGetPTZPresets is handler for GET /api/camera/:id/ptz/presets
+func (h *WebHandler) APIGetPTZPresets(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cam, err := h.getCamera(ps.ByName("id"))
+	checkError(err)
+
+	// Presets is cached, unless refresh is requested
+	refresh := r.URL.Query().Get("refresh") != ""
+	presets, err := h.getPTZPresets(cam, refresh)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&presets)
+	checkError(err)
+}
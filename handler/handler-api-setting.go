@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 	bolt "go.etcd.io/bbolt"
@@ -12,73 +13,187 @@ import (
 
 // APIGetSetting is handler for GET /api/setting
 func (h *WebHandler) APIGetSetting(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Make sure session still valid
-	err := h.validateSession(r)
-	checkError(err)
-
 	// Get list of usernames and setting
 	users := h.getUsers()
+	setting := Setting{
+		MinFreeSpace: h.getMinFreeSpace(),
+		Retention:    make(map[string]RetentionSetting),
+	}
+
+	for _, camID := range h.getCameraIDs() {
+		setting.Retention[camID] = h.getRetentionSetting(camID)
+	}
 
 	// Decode to JSON
 	data := map[string]interface{}{
-		"users": users,
+		"users":         users,
+		"minFreeSpace":  setting.MinFreeSpace,
+		"retention":     setting.Retention,
+		"deletions":     h.getDeletedRecordings(),
+		"loginFailures": h.getLoginFailures(),
 	}
 
 	// Decode to JSON
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(&data)
+	err := json.NewEncoder(w).Encode(&data)
 	checkError(err)
 }
 
-// APIGetUsers is handler for GET /api/user
-func (h *WebHandler) APIGetUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Make sure session still valid
-	err := h.validateSession(r)
+// APISaveSetting is handler for POST /api/setting
+func (h *WebHandler) APISaveSetting(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	var setting Setting
+	err := json.NewDecoder(r.Body).Decode(&setting)
+	checkError(err)
+
+	if setting.MinFreeSpace < 0 {
+		panic(fmt.Errorf("minimum free space must not negative"))
+	}
+
+	for camID, retention := range setting.Retention {
+		if retention.MaxAge < 0 || retention.MaxSize < 0 {
+			panic(fmt.Errorf("retention setting for camera %s must not negative", camID))
+		}
+	}
+
+	// Save setting to database
+	err = h.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("setting"))
+		if err != nil {
+			return err
+		}
+
+		minFreeSpace := strconv.FormatInt(setting.MinFreeSpace, 10)
+		err = bucket.Put([]byte("min-free-space"), []byte(minFreeSpace))
+		if err != nil {
+			return err
+		}
+
+		// Retention setting is saved in each camera's bucket
+		cameraBucket := tx.Bucket([]byte("camera"))
+		if cameraBucket == nil {
+			return nil
+		}
+
+		for camID, retention := range setting.Retention {
+			camBucket := cameraBucket.Bucket([]byte(camID))
+			if camBucket == nil {
+				return fmt.Errorf("camera %s doesn't exist", camID)
+			}
+
+			camBucket.Put([]byte("max-age"), []byte(strconv.Itoa(retention.MaxAge)))
+			camBucket.Put([]byte("max-size"), []byte(strconv.FormatInt(retention.MaxSize, 10)))
+		}
+
+		return nil
+	})
 	checkError(err)
 
+	fmt.Fprint(w, 1)
+}
+
+// APIGetUsers is handler for GET /api/user
+func (h *WebHandler) APIGetUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Get list of usernames from database
 	users := h.getUsers()
 
 	// Decode to JSON
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(&users)
+	err := json.NewEncoder(w).Encode(&users)
 	checkError(err)
 }
 
 // APIInsertUser is handler for POST /api/user
 func (h *WebHandler) APIInsertUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Make sure session still valid
-	err := h.validateSession(r)
-	checkError(err)
-
 	// Decode request
 	var user User
-	err = json.NewDecoder(r.Body).Decode(&user)
+	err := json.NewDecoder(r.Body).Decode(&user)
 	checkError(err)
 
-	// Make sure that user not exists
-	err = h.DB.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("user"))
-		if bucket == nil {
-			return nil
-		}
+	if user.Username == "" || user.Password == "" {
+		panic(fmt.Errorf("username and password must not empty"))
+	}
+
+	if user.Role == "" {
+		user.Role = RoleViewer
+	}
+
+	if !isValidRole(user.Role) {
+		panic(fmt.Errorf("role %s is not valid", user.Role))
+	}
 
+	// Hash password with bcrypt
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
+	checkError(err)
+
+	// Save user to database, as long as the user not exists yet
+	err = h.DB.Update(func(tx *bolt.Tx) error {
+		bucket, _ := tx.CreateBucketIfNotExists([]byte("user"))
 		if val := bucket.Get([]byte(user.Username)); val != nil {
 			return fmt.Errorf("user %s already exists", user.Username)
 		}
 
-		return nil
+		err := putUserRecord(bucket, user.Username, userRecord{
+			Password: string(hashedPassword),
+			Role:     user.Role,
+			Cameras:  user.Cameras,
+		})
+		if err != nil {
+			return err
+		}
+
+		return checkAdminRemains(bucket)
 	})
 	checkError(err)
 
-	// Hash password with bcrypt
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
+	fmt.Fprint(w, 1)
+}
+
+// APIUpdateUser is handler for PUT /api/user/:username
+// which changes role, allowed cameras and, if specified, password of user.
+func (h *WebHandler) APIUpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	var user User
+	err := json.NewDecoder(r.Body).Decode(&user)
 	checkError(err)
 
-	// Save user to database
+	username := ps.ByName("username")
+	if !isValidRole(user.Role) {
+		panic(fmt.Errorf("role %s is not valid", user.Role))
+	}
+
+	// Hash the new password with bcrypt
+	var hashedPassword []byte
+	if user.Password != "" {
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(user.Password), 10)
+		checkError(err)
+	}
+
+	// Save the change to database
 	err = h.DB.Update(func(tx *bolt.Tx) error {
-		bucket, _ := tx.CreateBucketIfNotExists([]byte("user"))
-		return bucket.Put([]byte(user.Username), hashedPassword)
+		bucket := tx.Bucket([]byte("user"))
+		if bucket == nil {
+			return fmt.Errorf("user %s is not exist", username)
+		}
+
+		data := bucket.Get([]byte(username))
+		if data == nil {
+			return fmt.Errorf("user %s is not exist", username)
+		}
+
+		record := parseUserRecord(data)
+		record.Role = user.Role
+		record.Cameras = user.Cameras
+		if hashedPassword != nil {
+			record.Password = string(hashedPassword)
+		}
+
+		err := putUserRecord(bucket, username, record)
+		if err != nil {
+			return err
+		}
+
+		return checkAdminRemains(bucket)
 	})
 	checkError(err)
 
@@ -87,40 +202,43 @@ func (h *WebHandler) APIInsertUser(w http.ResponseWriter, r *http.Request, ps ht
 
 // APIDeleteUser is handler for DELETE /api/user/:username
 func (h *WebHandler) APIDeleteUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Make sure session still valid
-	err := h.validateSession(r)
-	checkError(err)
-
 	// Get username
 	username := ps.ByName("username")
 
 	// Delete from database
-	h.DB.Update(func(tx *bolt.Tx) error {
+	err := h.DB.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("user"))
 		if bucket == nil {
 			return nil
 		}
 
-		bucket.Delete([]byte(username))
-		return nil
+		err := bucket.Delete([]byte(username))
+		if err != nil {
+			return err
+		}
+
+		return checkAdminRemains(bucket)
 	})
+	checkError(err)
 
-	// Delete user's sessions
-	userSessions := []string{}
-	if val, found := h.UserCache.Get(username); found {
-		userSessions = val.([]string)
-		for _, session := range userSessions {
-			h.SessionCache.Delete(session)
-		}
+	// Delete user's sessions and API keys
+	h.deleteUserSessions(username)
+	err = h.deleteAPIKeys(username)
+	checkError(err)
 
-		h.UserCache.Delete(username)
-	}
+	fmt.Fprint(w, 1)
+}
 
+// APIUnlockUser is handler for POST /api/user/:username/unlock?ip=:ip
+// which allows user that locked out by failed login to log in again.
+// The IP address is optional, and unlocked as well when specified.
+func (h *WebHandler) APIUnlockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.unlockUser(ps.ByName("username"), r.URL.Query().Get("ip"))
 	fmt.Fprint(w, 1)
 }
 
-func (h *WebHandler) getUsers() []string {
-	users := []string{}
+func (h *WebHandler) getUsers() []User {
+	users := []User{}
 	h.DB.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("user"))
 		if bucket == nil {
@@ -128,7 +246,12 @@ func (h *WebHandler) getUsers() []string {
 		}
 
 		bucket.ForEach(func(key, val []byte) error {
-			users = append(users, string(key))
+			record := parseUserRecord(val)
+			users = append(users, User{
+				Username: string(key),
+				Role:     record.Role,
+				Cameras:  record.Cameras,
+			})
 			return nil
 		})
 
@@ -137,3 +260,33 @@ func (h *WebHandler) getUsers() []string {
 
 	return users
 }
+
+// putUserRecord saves data of user into the user bucket.
+func putUserRecord(bucket *bolt.Bucket, username string, record userRecord) error {
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(username), data)
+}
+
+// checkAdminRemains makes sure there is still an admin among the registered
+// users, so nobody is locked out from managing the NVR. If there are no user
+// left, the default admin account can be used again, so it's allowed.
+func checkAdminRemains(bucket *bolt.Bucket) error {
+	userCount := 0
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if parseUserRecord(v).Role == RoleAdmin {
+			return nil
+		}
+		userCount++
+	}
+
+	if userCount > 0 {
+		return fmt.Errorf("there must be at least one admin")
+	}
+
+	return nil
+}
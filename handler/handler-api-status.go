@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIGetCameraStatus is handler for GET /api/camera/status
+func (h *WebHandler) APIGetCameraStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get status of each camera that user allowed to see
+	acc := requestAccount(r)
+	now := time.Now()
+	statuses := make(map[string]CameraStatus)
+	for _, camID := range h.getCameraIDs() {
+		if acc.canAccessCamera(camID) {
+			statuses[camID] = h.getCameraStatus(camID, now)
+		}
+	}
+
+	// Encode to JSON
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&statuses)
+	checkError(err)
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Causes of gap in recording timeline
+const (
+	gapCameraOffline   = "camera-offline"
+	gapRecorderStopped = "recorder-stopped"
+	gapSchedule        = "schedule"
+	gapUnknown         = "unknown"
+)
+
+// APIGetTimeline is handler for GET /api/camera/:id/timeline
+func (h *WebHandler) APIGetTimeline(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	camID := ps.ByName("id")
+	cam, err := h.getCamera(camID)
+	checkError(err)
+
+	err = checkIndexedCamera(cam)
+	checkError(err)
+
+	// Parse date, by default use today
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if strDate := r.URL.Query().Get("date"); strDate != "" {
+		dayStart, err = time.ParseInLocation("2006-01-02", strDate, time.Local)
+		if err != nil {
+			panic(fmt.Errorf("date %s is not valid", strDate))
+		}
+	}
+
+	// Create timeline
+	timeline, err := h.getTimeline(camID, dayStart, now)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	err = json.NewEncoder(w).Encode(&timeline)
+	checkError(err)
+}
+
+// getTimeline creates recording timeline for the day that started in dayStart.
+// Any time after now is not counted, since it's not happened yet.
+func (h *WebHandler) getTimeline(camID string, dayStart time.Time, now time.Time) (Timeline, error) {
+	timeline := Timeline{
+		Date:      dayStart.Format("2006-01-02"),
+		Intervals: []TimelineInterval{},
+		Gaps:      []TimelineInterval{},
+	}
+
+	start := dayStart
+	end := dayStart.AddDate(0, 0, 1)
+	if end.After(now) {
+		end = now
+	}
+
+	if !start.Before(end) {
+		return timeline, nil
+	}
+
+	// Merge continuous segments into interval
+	segments, err := h.getIndexedSegments(camID, start, end)
+	if err != nil {
+		return timeline, err
+	}
+
+	for _, segment := range segments {
+		segmentStart := segment.Time
+		segmentEnd := segment.Time.Add(segment.Duration)
+		if segmentStart.Before(start) {
+			segmentStart = start
+		}
+		if segmentEnd.After(end) {
+			segmentEnd = end
+		}
+
+		nIntervals := len(timeline.Intervals)
+		if nIntervals > 0 {
+			last := &timeline.Intervals[nIntervals-1]
+			if segmentStart.Sub(last.End) <= segmentGapTolerance {
+				if segmentEnd.After(last.End) {
+					last.End = segmentEnd
+				}
+				continue
+			}
+		}
+
+		timeline.Intervals = append(timeline.Intervals, TimelineInterval{
+			Start: segmentStart,
+			End:   segmentEnd,
+		})
+	}
+
+	// Find gaps between intervals
+	events := h.getRecorderEvents(camID, start, end)
+	gapStart := start
+	var recordedDuration time.Duration
+	for _, interval := range timeline.Intervals {
+		if interval.Start.Sub(gapStart) > segmentGapTolerance {
+			timeline.Gaps = append(timeline.Gaps, TimelineInterval{
+				Start: gapStart,
+				End:   interval.Start,
+				Cause: gapCause(events, gapStart, interval.Start),
+			})
+		}
+
+		recordedDuration += interval.End.Sub(interval.Start)
+		gapStart = interval.End
+	}
+
+	if end.Sub(gapStart) > segmentGapTolerance {
+		timeline.Gaps = append(timeline.Gaps, TimelineInterval{
+			Start: gapStart,
+			End:   end,
+			Cause: gapCause(events, gapStart, end),
+		})
+	}
+
+	timeline.Coverage = recordedDuration.Seconds() / end.Sub(start).Seconds()
+	return timeline, nil
+}
+
+// gapCause finds the cause of gap from recorder events. Events are sorted
+// by time, and the first one may be the last event before the timeline.
+func gapCause(events []recorderEvent, gapStart, gapEnd time.Time) string {
+	cameraOffline := false
+	recorderStopped := false
+	schedulePaused := false
+
+	for _, event := range events {
+		if event.Time.After(gapEnd) {
+			break
+		}
+
+		// For events before the gap, only the latest
+		// one matters since it's the state when gap started.
+		if !event.Time.After(gapStart) {
+			cameraOffline = event.Event == eventCameraOffline
+			recorderStopped = event.Event == eventRecorderStopped
+			schedulePaused = event.Event == eventSchedulePaused
+			continue
+		}
+
+		switch event.Event {
+		case eventCameraOffline:
+			cameraOffline = true
+		case eventRecorderStopped, eventRecorderStarted:
+			// Recorder started without stopped first means it was crashed
+			recorderStopped = true
+		case eventSchedulePaused:
+			schedulePaused = true
+		}
+	}
+
+	// Schedule is checked first since it's intended by user
+	switch {
+	case schedulePaused:
+		return gapSchedule
+	case cameraOffline:
+		return gapCameraOffline
+	case recorderStopped:
+		return gapRecorderStopped
+	default:
+		return gapUnknown
+	}
+}
@@ -3,19 +3,22 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	nurl "net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 	bolt "go.etcd.io/bbolt"
-	"golang.org/x/crypto/bcrypt"
 )
 
-var rxSavedVideo = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(\d{2}:\d{2}:\d{2})\.mp4$`)
+var (
+	rxSavedVideo = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(\d{2}:\d{2}:\d{2})\.(mp4|avi)$`)
+	rxCameraID   = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
 
 // APILogin is handler for POST /api/login
 func (h *WebHandler) APILogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -26,23 +29,10 @@ func (h *WebHandler) APILogin(w http.ResponseWriter, r *http.Request, ps httprou
 
 	// Prepare function to generate session
 	genSession := func(expTime time.Duration) {
-		// Create session ID
-		sessionID, err := uuid.NewV4()
+		// Save session to database and cache
+		strSessionID, err := h.createSession(request.Username, expTime, r)
 		checkError(err)
 
-		// Save session ID to cache
-		strSessionID := sessionID.String()
-		h.SessionCache.Set(strSessionID, request.Username, expTime)
-
-		// Save user's session IDs to cache as well
-		// useful for mass logout
-		sessionIDs := []string{strSessionID}
-		if val, found := h.UserCache.Get(request.Username); found {
-			sessionIDs = val.([]string)
-			sessionIDs = append(sessionIDs, strSessionID)
-		}
-		h.UserCache.Set(request.Username, sessionIDs, -1)
-
 		// Return session ID to user in cookies
 		http.SetCookie(w, &http.Cookie{
 			Name:    "session-id",
@@ -56,41 +46,17 @@ func (h *WebHandler) APILogin(w http.ResponseWriter, r *http.Request, ps httprou
 		fmt.Fprint(w, strSessionID)
 	}
 
-	// Check if user's database is empty
-	dbIsEmpty := false
-	h.DB.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("user"))
-		dbIsEmpty = bucket == nil || bucket.Stats().KeyN == 0
-		return nil
-	})
-
-	// If database still empty, and user uses default account, let him in
-	if dbIsEmpty && request.Username == "admin" && request.Password == "admin" {
-		genSession(time.Hour)
+	// Make sure username and password match
+	isDefaultAccount, err := h.checkLogin(r, request.Username, request.Password)
+	if writeLoginLockedError(w, err) {
 		return
 	}
-
-	// Get account data from database
-	var hashedPassword []byte
-	err = h.DB.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("user"))
-		if bucket == nil {
-			return fmt.Errorf("user is not exist")
-		}
-
-		hashedPassword = bucket.Get([]byte(request.Username))
-		if hashedPassword == nil {
-			return fmt.Errorf("user is not exist")
-		}
-
-		return nil
-	})
 	checkError(err)
 
-	// Compare password with database
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(request.Password))
-	if err != nil {
-		panic(fmt.Errorf("username and password don't match"))
+	// Default account is only used for creating the first user, so its session is short
+	if isDefaultAccount {
+		genSession(time.Hour)
+		return
 	}
 
 	// Calculate expiration time
@@ -117,18 +83,74 @@ func (h *WebHandler) APILogout(w http.ResponseWriter, r *http.Request, ps httpro
 		}
 	}
 
-	h.SessionCache.Delete(sessionID.Value)
+	h.deleteSession(sessionID.Value)
 	fmt.Fprint(w, 1)
 }
 
-// APIGetCameraList is handler for GET /api/camera
-func (h *WebHandler) APIGetCameraList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Make sure session still valid
-	err := h.validateSession(r)
+// APICreateToken is handler for POST /api/token
+func (h *WebHandler) APICreateToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	var request TokenRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	checkError(err)
+
+	// Make sure username and password match
+	_, err = h.checkLogin(r, request.Username, request.Password)
+	if writeLoginLockedError(w, err) {
+		return
+	}
+	checkError(err)
+
+	acc, err := h.getAccount(request.Username)
+	checkError(err)
+
+	// Make sure the scopes are valid. By default, token has all scopes.
+	if len(request.Scopes) == 0 {
+		request.Scopes = []string{ScopeReadCameras, ScopePlayback, ScopeManageCameras, ScopeManageUsers}
+	}
+
+	for _, scope := range request.Scopes {
+		if !isValidScope(scope) {
+			panic(fmt.Errorf("scope %s is not valid", scope))
+		}
+	}
+
+	// Calculate expiration time
+	expTime := defaultTokenExpiration
+	if request.Expires > 0 {
+		expTime = time.Duration(request.Expires) * time.Hour
+	}
+
+	if expTime > maxTokenExpiration {
+		panic(fmt.Errorf("token must expire within %d hours", int(maxTokenExpiration.Hours())))
+	}
+
+	// Create token
+	now := time.Now()
+	result := AccessToken{
+		Scopes:  request.Scopes,
+		Expires: now.Add(expTime),
+	}
+
+	result.Token, err = createToken(tokenClaims{
+		Subject:  acc.Username,
+		Scope:    strings.Join(request.Scopes, " "),
+		IssuedAt: now.Unix(),
+		Expires:  result.Expires.Unix(),
+	})
 	checkError(err)
 
-	// Read list of camera from database
-	cameras := make(map[string]string)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&result)
+	checkError(err)
+}
+
+// APIGetCameraList is handler for GET /api/camera
+func (h *WebHandler) APIGetCameraList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Read list of camera that user allowed to see from database
+	acc := requestAccount(r)
+	cameras := make(map[string]CameraSummary)
 	h.DB.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("camera"))
 		if bucket == nil {
@@ -137,10 +159,18 @@ func (h *WebHandler) APIGetCameraList(w http.ResponseWriter, r *http.Request, ps
 
 		c := bucket.Cursor()
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if v == nil {
+			if v == nil && acc.canAccessCamera(string(k)) {
 				camBucket := bucket.Bucket(k)
 				camName := camBucket.Get([]byte("name"))
-				cameras[string(k)] = string(camName)
+				camType := camBucket.Get([]byte("type"))
+				if len(camType) == 0 {
+					camType = []byte(defaultCameraType)
+				}
+
+				cameras[string(k)] = CameraSummary{
+					Name: string(camName),
+					Type: string(camType),
+				}
 			}
 		}
 
@@ -149,26 +179,42 @@ func (h *WebHandler) APIGetCameraList(w http.ResponseWriter, r *http.Request, ps
 
 	// Encode to JSON
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(&cameras)
+	err := json.NewEncoder(w).Encode(&cameras)
 	checkError(err)
 }
 
 // APISaveCamera is handler for POST /api/camera
 func (h *WebHandler) APISaveCamera(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Make sure session still valid
-	err := h.validateSession(r)
-	checkError(err)
-
 	// Decode request
 	var camera Camera
-	err = json.NewDecoder(r.Body).Decode(&camera)
+	err := json.NewDecoder(r.Body).Decode(&camera)
 	checkError(err)
 
-	// Make sure URL valid
+	// Camera ID is used as name of its storage directory,
+	// so it must not contain path separator or dots
+	if camera.ID != "" && !rxCameraID.MatchString(camera.ID) {
+		panic(fmt.Errorf("camera id %s is not valid", camera.ID))
+	}
+
+	// Make sure camera type is supported and its data is valid for the driver
+	if camera.Type == "" {
+		camera.Type = defaultCameraType
+	}
+
 	camera.URL = strings.TrimSuffix(camera.URL, "/")
-	tmp, err := nurl.ParseRequestURI(camera.URL)
-	if err != nil || tmp.Scheme == "" || tmp.Hostname() == "" {
-		panic(fmt.Errorf("url is not valid"))
+	_, err = newCameraSource(camera)
+	checkError(err)
+
+	if camera.ConnectTimeout < 0 || camera.ReadTimeout < 0 {
+		panic(fmt.Errorf("timeout must not be negative"))
+	}
+
+	if camera.OnvifURL != "" {
+		onvifURL, err := nurl.ParseRequestURI(camera.OnvifURL)
+		if err != nil || onvifURL.Hostname() == "" ||
+			(onvifURL.Scheme != "http" && onvifURL.Scheme != "https") {
+			panic(fmt.Errorf("onvif url is not valid"))
+		}
 	}
 
 	// Save camera to database
@@ -184,16 +230,27 @@ func (h *WebHandler) APISaveCamera(w http.ResponseWriter, r *http.Request, ps ht
 
 		// Save the new camera
 		newCameraBucket, _ := cameraBucket.CreateBucketIfNotExists([]byte(camera.ID))
-		newCameraBucket.Put([]byte("url"), []byte(tmp.String()))
+		newCameraBucket.Put([]byte("type"), []byte(camera.Type))
+		newCameraBucket.Put([]byte("url"), []byte(camera.URL))
 		newCameraBucket.Put([]byte("name"), []byte(camera.Name))
 		newCameraBucket.Put([]byte("username"), []byte(camera.Username))
 		newCameraBucket.Put([]byte("password"), []byte(camera.Password))
+		newCameraBucket.Put([]byte("onvif-url"), []byte(camera.OnvifURL))
+		newCameraBucket.Put([]byte("connect-timeout"), []byte(strconv.Itoa(camera.ConnectTimeout)))
+		newCameraBucket.Put([]byte("read-timeout"), []byte(strconv.Itoa(camera.ReadTimeout)))
 
 		return nil
 	})
 
-	// Remove camera session cache
-	h.CameraCache.Delete(camera.ID)
+	// Close camera source, its live feed and its PTZ client,
+	// then reset its circuit so the new camera data is tried
+	h.closeCameraSource(camera.ID)
+	h.stopLiveFeed(camera.ID)
+	h.PTZCache.Delete(camera.ID)
+	h.resetCircuitBreaker(camera.ID)
+
+	// Restart recorder, so it uses the new camera data
+	h.restartCameraRecorder(camera.ID)
 
 	w.Header().Set("Content-Type", "text/plain")
 	fmt.Fprint(w, camera.ID)
@@ -201,15 +258,16 @@ func (h *WebHandler) APISaveCamera(w http.ResponseWriter, r *http.Request, ps ht
 
 // APIDeleteCamera is handler for DELETE /api/camera/:id
 func (h *WebHandler) APIDeleteCamera(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Make sure session still valid
-	err := h.validateSession(r)
-	checkError(err)
-
 	// Decode request
 	camID := ps.ByName("id")
 
-	// Delete camera's session cache
-	h.CameraCache.Delete(camID)
+	// Stop camera's recorder and live feed, then close its source and PTZ client
+	h.stopCameraRecorder(camID)
+	h.stopLiveFeed(camID)
+	h.closeCameraSource(camID)
+	h.PTZCache.Delete(camID)
+	h.resetCircuitBreaker(camID)
+	h.deleteCameraStatus(camID)
 
 	// Delete camera in database
 	h.DB.Update(func(tx *bolt.Tx) error {
@@ -225,3 +283,72 @@ func (h *WebHandler) APIDeleteCamera(w http.ResponseWriter, r *http.Request, ps
 
 	fmt.Fprint(w, 1)
 }
+
+// APIGetCameraSchedule is handler for GET /api/camera/:id/schedule
+func (h *WebHandler) APIGetCameraSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get schedule from database
+	schedule, err := h.getCameraSchedule(ps.ByName("id"))
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&schedule)
+	checkError(err)
+}
+
+// APISaveCameraSchedule is handler for POST /api/camera/:id/schedule
+func (h *WebHandler) APISaveCameraSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	schedule := Schedule{
+		PreEvent:  defaultPreEvent,
+		PostEvent: defaultPostEvent,
+	}
+	err := json.NewDecoder(r.Body).Decode(&schedule)
+	checkError(err)
+
+	if schedule.Ranges == nil {
+		schedule.Ranges = []ScheduleRange{}
+	}
+
+	err = schedule.validate()
+	checkError(err)
+
+	// Save schedule, then restart recorder so it follows the new schedule
+	camID := ps.ByName("id")
+	err = h.saveCameraSchedule(camID, &schedule)
+	checkError(err)
+
+	h.restartCameraRecorder(camID)
+
+	fmt.Fprint(w, 1)
+}
+
+// APIDeleteCameraSchedule is handler for DELETE /api/camera/:id/schedule
+func (h *WebHandler) APIDeleteCameraSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Remove schedule, so camera recorded all the time
+	camID := ps.ByName("id")
+	err := h.saveCameraSchedule(camID, nil)
+	checkError(err)
+
+	h.restartCameraRecorder(camID)
+
+	fmt.Fprint(w, 1)
+}
+
+// APITriggerEvent is handler for POST /api/camera/:id/event
+func (h *WebHandler) APITriggerEvent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request. Body is optional, so ignore when it's empty.
+	var request EventRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil && err != io.EOF {
+		panic(err)
+	}
+
+	if request.Source == "" {
+		request.Source = "api"
+	}
+
+	err = h.TriggerEvent(ps.ByName("id"), request.Source)
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
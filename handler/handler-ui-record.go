@@ -0,0 +1,391 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	fp "path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Duration before video that remuxed from segments is removed from cache
+const videoCacheExpiration = 10 * time.Minute
+
+// ServeRecordList is handler for GET /cam/:camID/records
+// which returns list of recorded video within the specified time range
+func (h *WebHandler) ServeRecordList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	camID := ps.ByName("camID")
+	_, err := h.getCamera(camID)
+	checkError(err)
+
+	// Parse time range
+	from, err := parseTimeParam(r.URL.Query().Get("from"), time.Time{})
+	checkError(err)
+
+	to, err := parseTimeParam(r.URL.Query().Get("to"), time.Now())
+	checkError(err)
+
+	// Get list of video that saved as file, i.e. recording of MJPEG
+	// camera and video that rolled by the previous version
+	files, err := h.listRecordings(camID)
+	checkError(err)
+
+	videos := []RecordedVideo{}
+	savedVideos := make(map[string]struct{})
+	for _, file := range files {
+		if !rxSavedVideo.MatchString(file.Name) {
+			continue
+		}
+
+		// Keep video that overlaps with the time range,
+		// including the one that started before it
+		if !file.Time.Before(to) || !file.ModTime.After(from) {
+			continue
+		}
+
+		videos = append(videos, RecordedVideo{
+			File: file.Name,
+			Time: file.Time,
+			Size: file.Size,
+			URL:  path.Join("/", "cam", camID, "records", file.Name),
+		})
+		savedVideos[file.Name] = struct{}{}
+	}
+
+	// Add video that remuxed from the recorded segments
+	segmentVideos, err := h.getSegmentVideos(camID, from, to)
+	checkError(err)
+
+	for _, video := range segmentVideos {
+		if _, saved := savedVideos[video.Name]; saved {
+			continue
+		}
+
+		videos = append(videos, RecordedVideo{
+			File: video.Name,
+			Time: video.Time,
+			Size: video.size(),
+			URL:  path.Join("/", "cam", camID, "records", video.Name),
+		})
+	}
+
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].Time.Before(videos[j].Time)
+	})
+
+	// Encode to JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	err = json.NewEncoder(w).Encode(&videos)
+	checkError(err)
+}
+
+// ServeRecordFile is handler for GET /cam/:camID/records/:file
+// which serve the recorded video, including support for range request
+func (h *WebHandler) ServeRecordFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	camID := ps.ByName("camID")
+	_, err := h.getCamera(camID)
+	checkError(err)
+
+	// Make sure file is a recorded video
+	fileName := ps.ByName("file")
+	if !rxSavedVideo.MatchString(fileName) {
+		panic(fmt.Errorf("%s is not a recorded video", fileName))
+	}
+
+	filePath, err := h.recordingPath(camID, fileName)
+	checkError(err)
+
+	// Open the file. If it's not exist, the video might be remuxed from segments.
+	// In that case the range requests of the ongoing playback keep using the same
+	// snapshot, which identified by ETag so client can pin it with If-Range.
+	src, err := os.Open(filePath)
+	if os.IsNotExist(err) && fp.Ext(fileName) == ".mp4" {
+		snapshotID := ""
+		if continuesPlayback(r) {
+			snapshotID = strings.Trim(r.Header.Get("If-Range"), `"`)
+			if snapshotID == "" {
+				snapshotID = h.latestVideoSnapshot(camID, fileName)
+			}
+		}
+
+		src, snapshotID, err = h.openSegmentVideo(camID, fileName, snapshotID)
+		if err == nil {
+			w.Header().Set("ETag", `"`+snapshotID+`"`)
+		}
+	}
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		panic(err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	checkError(err)
+
+	// Serve file. ServeContent already handles range request.
+	contentType := "video/mp4"
+	if fp.Ext(fileName) == ".avi" {
+		contentType = "video/x-msvideo"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, fileName, info.ModTime(), src)
+}
+
+// segmentVideo is a continuous run of recorded segments, which
+// remuxed into a single MP4 video when it's served.
+type segmentVideo struct {
+	Name     string
+	Time     time.Time
+	Segments []recordedSegment
+}
+
+func (v segmentVideo) size() int64 {
+	var size int64
+	for _, segment := range v.Segments {
+		size += segment.Size
+	}
+	return size
+}
+
+// getSegmentVideos groups the recorded segments into videos, then returns the
+// videos that overlap with the specified time range. Video is cut on every
+// multiple of video duration and on gap between segments, so a video always
+// consists of the same segments no matter which time range is requested.
+func (h *WebHandler) getSegmentVideos(camID string, from, to time.Time) ([]segmentVideo, error) {
+	// Since video never crosses its slot, get segments of the whole slots
+	slotStart := from.Truncate(h.VideoDuration)
+	slotEnd := to.Truncate(h.VideoDuration).Add(h.VideoDuration)
+	segments, err := h.getIndexedSegments(camID, slotStart, slotEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	videos := []segmentVideo{}
+	for i, segment := range segments {
+		// Segment before the slot is the end of the previous video
+		if segment.Time.Before(slotStart) {
+			continue
+		}
+
+		newVideo := len(videos) == 0
+		if !newVideo {
+			prev := segments[i-1]
+			gap := segment.Time.Sub(prev.Time.Add(prev.Duration))
+			newVideo = gap > segmentGapTolerance || gap < -segmentGapTolerance ||
+				!segment.Time.Truncate(h.VideoDuration).Equal(prev.Time.Truncate(h.VideoDuration))
+		}
+
+		if newVideo {
+			videos = append(videos, segmentVideo{
+				Name: segment.Time.Format(videoTimeFormat) + ".mp4",
+				Time: segment.Time,
+			})
+		}
+
+		video := &videos[len(videos)-1]
+		video.Segments = append(video.Segments, segment)
+	}
+
+	// Only keep videos that overlap with the time range
+	result := []segmentVideo{}
+	for _, video := range videos {
+		last := video.Segments[len(video.Segments)-1]
+		if last.Time.Add(last.Duration).After(from) && video.Time.Before(to) {
+			result = append(result, video)
+		}
+	}
+
+	return result, nil
+}
+
+// segmentVideoSnapshot points to the latest snapshot of a segment video in cache.
+type segmentVideoSnapshot struct {
+	ID string
+}
+
+// latestVideoSnapshot returns ID of the latest snapshot of a segment video,
+// or empty string if the video is not remuxed recently.
+func (h *WebHandler) latestVideoSnapshot(camID string, fileName string) string {
+	if latest, exist := h.VideoCache.Get(camID + "/" + fileName); exist {
+		return latest.(segmentVideoSnapshot).ID
+	}
+	return ""
+}
+
+// continuesPlayback checks if the request continues the playback of video that
+// already started, i.e. it requests range that not at the beginning of the video.
+func continuesPlayback(r *http.Request) bool {
+	rangeHeader := r.Header.Get("Range")
+	return rangeHeader != "" && !strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
+// openSegmentVideo opens MP4 video that remuxed from recorded segments, then returns
+// ID of the snapshot that remuxed. The latest video might be still recorded, so the
+// remuxed video is a snapshot of its segments, identified by its last segment and size.
+// Each snapshot is cached for a while, so it doesn't need to be remuxed again for every
+// range request while the video is seeked. If snapshotID is specified and it's still
+// cached, that snapshot is used even when there are new segments, so the playback never
+// mixes bytes of different snapshots. If there are no video with the specified name,
+// it returns error that satisfies os.IsNotExist.
+func (h *WebHandler) openSegmentVideo(camID string, fileName string, snapshotID string) (*os.File, string, error) {
+	latestKey := camID + "/" + fileName
+	if snapshotID != "" {
+		if cached, exist := h.VideoCache.Get(latestKey + "/" + snapshotID); exist {
+			src, err := os.Open(cached.(string))
+			return src, snapshotID, err
+		}
+	}
+
+	strTime := strings.TrimSuffix(fileName, fp.Ext(fileName))
+	videoTime, err := time.ParseInLocation(videoTimeFormat, strTime, time.Local)
+	if err != nil {
+		return nil, "", os.ErrNotExist
+	}
+
+	videos, err := h.getSegmentVideos(camID, videoTime, videoTime.Add(time.Second))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var video *segmentVideo
+	for i := range videos {
+		if videos[i].Name == fileName {
+			video = &videos[i]
+			break
+		}
+	}
+
+	if video == nil {
+		return nil, "", os.ErrNotExist
+	}
+
+	last := video.Segments[len(video.Segments)-1]
+	snapshotID = fmt.Sprintf("%s-%d", strings.TrimSuffix(last.Name, fp.Ext(last.Name)), video.size())
+	snapshotKey := latestKey + "/" + snapshotID
+	h.VideoCache.Set(latestKey, segmentVideoSnapshot{ID: snapshotID}, videoCacheExpiration)
+
+	if cached, exist := h.VideoCache.Get(snapshotKey); exist {
+		src, err := os.Open(cached.(string))
+		return src, snapshotID, err
+	}
+
+	videoPath, err := h.remuxSegmentVideo(*video)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// If the same snapshot is remuxed by another request, use that one instead
+	err = h.VideoCache.Add(snapshotKey, videoPath, videoCacheExpiration)
+	if err != nil {
+		os.Remove(videoPath)
+		cached, exist := h.VideoCache.Get(snapshotKey)
+		if !exist {
+			return nil, "", err
+		}
+		videoPath = cached.(string)
+	}
+
+	src, err := os.Open(videoPath)
+	return src, snapshotID, err
+}
+
+// remuxSegmentVideo remuxes segments of the video into
+// a temporary MP4 file, then returns path of the file.
+func (h *WebHandler) remuxSegmentVideo(video segmentVideo) (string, error) {
+	err := os.MkdirAll(h.videoCacheDir(), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := ioutil.TempFile(h.videoCacheDir(), "*.mp4")
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	writer := remux.NewFMP4Writer(dst)
+	for _, segment := range video.Segments {
+		err = h.copySegmentRange(writer, segment, 0, 0)
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", fmt.Errorf("failed to remux video %s: %v", video.Name, err)
+	}
+
+	return dst.Name(), nil
+}
+
+// prepareVideoCache makes sure the remuxed video is removed when it's expired
+// from cache. It also removes the videos that left by the previous run.
+func (h *WebHandler) prepareVideoCache() {
+	os.RemoveAll(h.videoCacheDir())
+	h.VideoCache.OnEvicted(func(key string, val interface{}) {
+		// Only snapshot has file, the pointer to the latest snapshot doesn't
+		if videoPath, isPath := val.(string); isPath {
+			os.Remove(videoPath)
+		}
+	})
+}
+
+func (h *WebHandler) videoCacheDir() string {
+	return fp.Join(h.StorageDir, ".video")
+}
+
+// recordingPath returns path of a file inside the camera's recording directory.
+// It makes sure the returned path never escapes the recording directory.
+func (h *WebHandler) recordingPath(camID string, fileName string) (string, error) {
+	cameraDir, err := h.cameraStorageDir(camID)
+	if err != nil {
+		return "", err
+	}
+
+	filePath := fp.Join(cameraDir, fileName)
+	if fp.Dir(filePath) != cameraDir {
+		return "", fmt.Errorf("path is not valid")
+	}
+
+	return filePath, nil
+}
+
+// parseTimeParam parses time in URL query, which could be either in RFC3339
+// or Unix timestamp. If the param is empty, fallback value will be returned.
+func parseTimeParam(param string, fallback time.Time) (time.Time, error) {
+	param = strings.TrimSpace(param)
+	if param == "" {
+		return fallback, nil
+	}
+
+	if unix, err := strconv.ParseInt(param, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time %s is not valid", param)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSegmentVideoSnapshot(t *testing.T) {
+	camera := &fakeCamera{}
+	server := httptest.NewServer(camera)
+	defer server.Close()
+
+	h, clock, cleanup := newTestRecorder(t, server.URL, Schedule{DefaultMode: scheduleContinuous},
+		time.Date(2026, 10, 19, 9, 0, 10, 0, time.Local))
+	defer cleanup()
+	h.VideoDuration = time.Hour
+
+	camera.Publish()
+	h.restartCameraRecorder("1")
+	waitFor(t, "segment 1 saved", func() bool { return countSavedSegments(h, "1") == 1 })
+
+	videos, err := h.getSegmentVideos("1", time.Unix(0, 0), clock.Now())
+	if err != nil || len(videos) != 1 {
+		t.Fatalf("got %d videos, want 1: %v", len(videos), err)
+	}
+	fileName := videos[0].Name
+
+	fileSize := func(src *os.File, err error) int64 {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+
+		info, err := src.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+
+	// Start the playback while the video is still recorded
+	src, firstID, err := h.openSegmentVideo("1", fileName, "")
+	firstSize := fileSize(src, err)
+
+	clock.Set(time.Date(2026, 10, 19, 9, 0, 11, 0, time.Local))
+	camera.Publish()
+	waitFor(t, "segment 2 saved", func() bool { return countSavedSegments(h, "1") == 2 })
+	h.stopCameraRecorder("1")
+
+	// Range request of the ongoing playback keeps using the same snapshot
+	src, snapshotID, err := h.openSegmentVideo("1", fileName, h.latestVideoSnapshot("1", fileName))
+	if size := fileSize(src, err); size != firstSize || snapshotID != firstID {
+		t.Errorf("ongoing playback got snapshot %s with %d bytes, want %s with %d bytes", snapshotID, size, firstID, firstSize)
+	}
+
+	// The new playback gets the new segment
+	src, snapshotID, err = h.openSegmentVideo("1", fileName, "")
+	if size := fileSize(src, err); size <= firstSize || snapshotID == firstID {
+		t.Errorf("new playback got snapshot %s with %d bytes, want larger than %d bytes", snapshotID, size, firstSize)
+	}
+
+	// The old snapshot is still served when it's pinned with If-Range
+	src, snapshotID, err = h.openSegmentVideo("1", fileName, firstID)
+	if size := fileSize(src, err); size != firstSize || snapshotID != firstID {
+		t.Errorf("pinned playback got snapshot %s with %d bytes, want %s with %d bytes", snapshotID, size, firstID, firstSize)
+	}
+}
+
+func TestContinuesPlayback(t *testing.T) {
+	tests := map[string]bool{
+		"":             false,
+		"bytes=0-":     false,
+		"bytes=0-1023": false,
+		"bytes=1024-":  true,
+	}
+
+	for rangeHeader, expected := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+
+		if got := continuesPlayback(r); got != expected {
+			t.Errorf("range %q: got %v, want %v", rangeHeader, got, expected)
+		}
+	}
+}
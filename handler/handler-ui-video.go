@@ -1,41 +1,152 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-var httpClient = http.Client{Timeout: time.Minute}
+// Boundary between frames in the served MJPEG stream
+const mjpegBoundary = "frame"
 
 // ServeLivePlaylist is handler for GET /cam/:camID/live/playlist
 // which serve HLS playlist for live stream
 func (h *WebHandler) ServeLivePlaylist(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Make sure login session still valid
-	err := h.validateSession(r)
-	checkError(err)
-
 	camID := ps.ByName("camID")
 	cam, err := h.getCamera(camID)
 	checkError(err)
 
-	err = h.proxyCameraLivePlaylist(cam, w)
+	err = h.writeLivePlaylist(cam, w, r)
+
+	// Nothing to respond if the viewer is already disconnected
+	if writeUpstreamError(w, err) || r.Context().Err() != nil {
+		return
+	}
 	checkError(err)
 }
 
 // ServeLiveSegment is handler for GET /cam/:camID/live/stream/:index
 // which serve the HLS segment for live stream
 func (h *WebHandler) ServeLiveSegment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Make sure login session still valid
-	err := h.validateSession(r)
+	camID := ps.ByName("camID")
+	cam, err := h.getCamera(camID)
+	checkError(err)
+
+	sequence, err := strconv.Atoi(ps.ByName("index"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = h.writeLiveSegment(cam, sequence, w, r)
+
+	// Nothing to respond if the viewer is already disconnected
+	if writeUpstreamError(w, err) || r.Context().Err() != nil {
+		return
+	}
+	checkError(err)
+}
+
+// ServeLiveMJPEG is handler for GET /cam/:camID/live/mjpeg
+// which serve the live stream of MJPEG camera as it is
+func (h *WebHandler) ServeLiveMJPEG(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	camID := ps.ByName("camID")
+	cam, err := h.getCamera(camID)
+	checkError(err)
+
+	source, err := h.openCameraSource(cam)
+	if writeUpstreamError(w, err) {
+		return
+	}
 	checkError(err)
 
+	frames, isFrameSource := source.(frameSource)
+	if !isFrameSource {
+		panic(fmt.Errorf("camera %s doesn't serve MJPEG stream", camID))
+	}
+
+	// Start with the latest frame, so viewer doesn't see blank image
+	lastFrame, err := source.Snapshot()
+	if err != nil {
+		h.releaseCameraSource(camID, source)
+		panic(err)
+	}
+
+	chFrame, unsubscribe := frames.subscribe()
+	defer unsubscribe()
+
+	// Set response header
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace;boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	// Serve frames until viewer disconnected or the stream ended
+	flusher, _ := w.(http.Flusher)
+	writeFrame := func(frame []byte) error {
+		_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n",
+			mjpegBoundary, len(frame))
+		if err != nil {
+			return err
+		}
+
+		if _, err = w.Write(frame); err != nil {
+			return err
+		}
+
+		if _, err = w.Write([]byte("\r\n")); err != nil {
+			return err
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+
+	if writeFrame(lastFrame) != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-chFrame:
+			if !ok {
+				h.releaseCameraSource(camID, source)
+				return
+			}
+
+			if writeFrame(frame) != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServeSnapshot is handler for GET /cam/:camID/snapshot
+// which serve the current image of camera as JPEG
+func (h *WebHandler) ServeSnapshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	camID := ps.ByName("camID")
 	cam, err := h.getCamera(camID)
 	checkError(err)
 
-	idx := ps.ByName("index")
-	err = h.proxyCameraLiveStream(cam, idx, w)
+	var image []byte
+	err = h.useCameraSource(r.Context(), cam, func(source CameraSource) error {
+		image, err = source.Snapshot()
+		return err
+	})
+
+	// Nothing to respond if the viewer is already disconnected
+	if writeUpstreamError(w, err) || r.Context().Err() != nil {
+		return
+	}
+	checkError(err)
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	_, err = w.Write(image)
 	checkError(err)
 }
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Maximum gap between two segments that still considered as continuous
+const segmentGapTolerance = 500 * time.Millisecond
+
+// recordedSegment is TS segment saved by recorder, along with its duration
+type recordedSegment struct {
+	recordingFile
+	Duration time.Duration
+}
+
+// ServeVODPlaylist is handler for GET /cam/:camID/vod/playlist
+// which serve HLS playlist for the recorded segments in the specified time range
+func (h *WebHandler) ServeVODPlaylist(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	camID := ps.ByName("camID")
+	cam, err := h.getCamera(camID)
+	checkError(err)
+
+	err = checkIndexedCamera(cam)
+	checkError(err)
+
+	// Parse time range. By default, show the last hour.
+	end, err := parseTimeParam(r.URL.Query().Get("end"), time.Now())
+	checkError(err)
+
+	start, err := parseTimeParam(r.URL.Query().Get("start"), end.Add(-time.Hour))
+	checkError(err)
+
+	if !start.Before(end) {
+		panic(fmt.Errorf("start time must be before end time"))
+	}
+
+	// Get segments within the time range
+	segments, err := h.getIndexedSegments(camID, start, end)
+	checkError(err)
+
+	if len(segments) == 0 {
+		panic(fmt.Errorf("there are no recording between %s and %s",
+			start.Format(time.RFC3339), end.Format(time.RFC3339)))
+	}
+
+	// Create playlist
+	targetDuration := 1.0
+	for _, segment := range segments {
+		targetDuration = math.Max(targetDuration, math.Ceil(segment.Duration.Seconds()))
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:3\n")
+	sb.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(targetDuration)))
+	sb.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+
+	for i, segment := range segments {
+		// Mark discontinuity when there are gap between segments
+		discontinuity := i == 0
+		if i > 0 {
+			prev := segments[i-1]
+			gap := segment.Time.Sub(prev.Time.Add(prev.Duration))
+			if gap > segmentGapTolerance || gap < -segmentGapTolerance {
+				sb.WriteString("#EXT-X-DISCONTINUITY\n")
+				discontinuity = true
+			}
+		}
+
+		// Put the wall clock time on each continuous part
+		if discontinuity {
+			sb.WriteString(fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n",
+				segment.Time.Format("2006-01-02T15:04:05.000Z07:00")))
+		}
+
+		sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n", segment.Duration.Seconds()))
+		sb.WriteString(path.Join("/", "cam", camID, "vod", "segment", segment.Name) + "\n")
+	}
+
+	sb.WriteString("#EXT-X-ENDLIST\n")
+
+	// Set response header
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	_, err = w.Write([]byte(sb.String()))
+	checkError(err)
+}
+
+// ServeVODSegment is handler for GET /cam/:camID/vod/segment/:file
+// which serve the recorded TS segment
+func (h *WebHandler) ServeVODSegment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	camID := ps.ByName("camID")
+	_, err := h.getCamera(camID)
+	checkError(err)
+
+	// Make sure file is a recorded segment
+	fileName := ps.ByName("file")
+	if !rxSavedSegment.MatchString(fileName) {
+		panic(fmt.Errorf("%s is not a recorded segment", fileName))
+	}
+
+	filePath, err := h.recordingPath(camID, fileName)
+	checkError(err)
+
+	// Open the file
+	src, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		panic(err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	checkError(err)
+
+	// Serve the segment. Since recorded segment never changed, it can be cached.
+	w.Header().Set("Content-Type", "video/MP2T")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	http.ServeContent(w, r, fileName, info.ModTime(), src)
+}
@@ -7,7 +7,9 @@ import (
 	"net/http"
 	"os"
 	fp "path/filepath"
+	"time"
 
+	"github.com/julienschmidt/httprouter"
 	cch "github.com/patrickmn/go-cache"
 	bolt "go.etcd.io/bbolt"
 )
@@ -16,15 +18,38 @@ var developmentMode = false
 
 // WebHandler is handler for serving the web interface.
 type WebHandler struct {
-	DB           *bolt.DB
-	UserCache    *cch.Cache
-	SessionCache *cch.Cache
-	CameraCache  *cch.Cache
+	DB            *bolt.DB
+	UserCache     *cch.Cache
+	SessionCache  *cch.Cache
+	CameraCache   *cch.Cache
+	PTZCache      *cch.Cache
+	VideoCache    *cch.Cache
+	StorageDir    string
+	VideoDuration time.Duration
+
+	liveHub     *liveHub
+	recorder    *recorder
+	retention   *retention
+	exporter    *exporter
+	health      *healthMonitor
+	breakers    *circuitBreakers
+	limiter     *cameraLimiter
+	sourceLocks *cameraSourceLocks
+	loginGuard  *loginGuard
 }
 
-// PrepareLoginCache prepares cache for future use
+// PrepareLoginCache prepares cache for future use. Since the cached session
+// is evicted when it's expired or deleted, it's removed from database as well.
+// It also prepares the counter of failed login.
 func (h *WebHandler) PrepareLoginCache() {
+	h.loginGuard = &loginGuard{
+		counters: make(map[string]*loginCounter),
+		clock:    time.Now,
+	}
+
 	h.SessionCache.OnEvicted(func(key string, val interface{}) {
+		h.deleteStoredSessions(key)
+
 		username := val.(string)
 		arr, found := h.UserCache.Get(username)
 		if !found {
@@ -44,21 +69,28 @@ func (h *WebHandler) PrepareLoginCache() {
 }
 
 func (h *WebHandler) validateSession(r *http.Request) error {
+	_, err := h.getSessionUser(r)
+	return err
+}
+
+// getSessionUser returns username that owns the request's session.
+func (h *WebHandler) getSessionUser(r *http.Request) (string, error) {
 	// Get session-id from cookie
 	sessionID, err := r.Cookie("session-id")
 	if err != nil {
 		if err == http.ErrNoCookie {
-			return fmt.Errorf("session is not exist")
+			return "", fmt.Errorf("session is not exist")
 		}
-		return err
+		return "", err
 	}
 
 	// Make sure session is not expired yet
-	if _, found := h.SessionCache.Get(sessionID.Value); !found {
-		return fmt.Errorf("session has been expired")
+	username, found := h.loadSession(sessionID.Value)
+	if !found {
+		return "", fmt.Errorf("session has been expired")
 	}
 
-	return nil
+	return username, nil
 }
 
 func serveFile(w http.ResponseWriter, filePath string, cache bool) error {
@@ -95,6 +127,22 @@ func serveFile(w http.ResponseWriter, filePath string, cache bool) error {
 	return err
 }
 
+// RouteByParam returns handler that passes request to the route registered for the
+// value of the specified parameter, or responds with 404 if there are none. It's used
+// for static path that shares its position with a parameter, e.g. /api/camera/discover
+// beside /api/camera/:id, since router doesn't allow both of them to be registered.
+func RouteByParam(param string, routes map[string]httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		route, exist := routes[ps.ByName(param)]
+		if !exist {
+			http.NotFound(w, r)
+			return
+		}
+
+		route(w, r, ps)
+	}
+}
+
 func redirectPage(w http.ResponseWriter, r *http.Request, url string) {
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 	w.Header().Set("Pragma", "no-cache")
@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// List of camera connection state
+const (
+	stateUnknown    = "unknown"
+	stateOnline     = "online"
+	stateOffline    = "offline"
+	stateAuthFailed = "auth-failed"
+)
+
+const (
+	// Interval between probing the cameras
+	healthProbeInterval = 30 * time.Second
+
+	// Period that used to calculate the uptime of camera
+	healthUptimeWindow = 24 * time.Hour
+
+	// Maximum age of state changes that kept in history
+	healthHistoryAge = 30 * 24 * time.Hour
+)
+
+// healthMonitor probes the connection of every camera in background.
+type healthMonitor struct {
+	sync.Mutex
+	stop chan struct{}
+
+	// clock returns the current time. It's used as time of probe,
+	// so it can be replaced when the state changes need to be simulated.
+	clock func() time.Time
+}
+
+// StartHealthMonitor starts probing the cameras periodically,
+// then saves their connection state into database.
+func (h *WebHandler) StartHealthMonitor() {
+	h.health = &healthMonitor{
+		stop:  make(chan struct{}),
+		clock: time.Now,
+	}
+
+	go func() {
+		ticker := time.NewTicker(healthProbeInterval)
+		defer ticker.Stop()
+
+		for {
+			h.probeCameras()
+
+			select {
+			case <-h.health.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// StopHealthMonitor stops probing the cameras.
+func (h *WebHandler) StopHealthMonitor() {
+	if h.health == nil {
+		return
+	}
+
+	close(h.health.stop)
+}
+
+// probeCameras probes all cameras concurrently, so a dead camera
+// which connection timed out doesn't delay the other cameras.
+func (h *WebHandler) probeCameras() {
+	var wg sync.WaitGroup
+	for _, camID := range h.getCameraIDs() {
+		wg.Add(1)
+		go func(camID string) {
+			defer wg.Done()
+
+			cam, err := h.getCamera(camID)
+			if err != nil {
+				return
+			}
+
+			h.probeCamera(cam)
+		}(camID)
+	}
+
+	wg.Wait()
+}
+
+// probeCamera checks if camera is reachable by fetching its playlist, or its
+// latest frame for frame camera. The cached source is reused, so the camera is
+// not reconnected for each probe. If probe failed, the source is closed, so it
+// will be reopened on the next probe. While the camera's circuit is open, its
+// last status is kept since the circuit breaker is already probing it.
+func (h *WebHandler) probeCamera(cam Camera) {
+	probeStart := h.health.clock()
+
+	ctx := context.Background()
+	err := h.useCameraSource(ctx, cam, func(source CameraSource) error {
+		return pingSource(ctx, source)
+	})
+	if _, isCircuitOpen := err.(*circuitOpenError); isCircuitOpen {
+		return
+	}
+
+	probeEnd := h.health.clock()
+	state := stateOnline
+	if err != nil {
+		state = stateOffline
+		if _, isAuthError := err.(*authError); isAuthError {
+			state = stateAuthFailed
+		}
+	}
+
+	err = h.saveCameraStatus(cam.ID, state, err, probeEnd, probeEnd.Sub(probeStart))
+	if err != nil {
+		logrus.Warnf("failed to save status of camera %s: %v\n", cam.ID, err)
+	}
+}
+
+// saveCameraStatus saves the result of probe as the current status of camera.
+// When the state is changed, it's also saved into the state history.
+func (h *WebHandler) saveCameraStatus(camID string, state string, probeErr error, probeTime time.Time, latency time.Duration) error {
+	h.health.Lock()
+	defer h.health.Unlock()
+
+	return h.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("camera-status"))
+		if err != nil {
+			return err
+		}
+
+		// Get the previous status
+		status := CameraStatus{State: stateUnknown}
+		if content := bucket.Get([]byte(camID)); content != nil {
+			json.Unmarshal(content, &status)
+		}
+		prevState := status.State
+
+		// Update the status
+		status.State = state
+		status.LastProbe = probeTime
+		status.Latency = float64(latency) / float64(time.Millisecond)
+		status.Error = ""
+		if probeErr != nil {
+			status.Error = probeErr.Error()
+		}
+
+		if state == stateOnline {
+			status.LastSeen = probeTime
+		}
+
+		content, err := json.Marshal(&status)
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Put([]byte(camID), content)
+		if err != nil {
+			return err
+		}
+
+		if state == prevState {
+			return nil
+		}
+
+		// Log the state change into history, and remove the old history
+		if state == stateOnline {
+			logrus.Infof("camera %s is online\n", camID)
+		} else {
+			logrus.Warnf("camera %s is %s: %v\n", camID, state, probeErr)
+		}
+
+		history := cameraSubBucket(tx, "camera-status-history", camID)
+		if history == nil {
+			return nil
+		}
+
+		err = history.Put(timeKey(probeTime), []byte(state))
+		if err != nil {
+			return err
+		}
+
+		// Collect the old keys first, since deleting while iterating skips entries
+		limit := timeKey(probeTime.Add(-healthHistoryAge))
+		oldKeys := [][]byte{}
+		c := history.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < string(limit); k, _ = c.Next() {
+			oldKeys = append(oldKeys, append([]byte{}, k...))
+		}
+
+		for _, key := range oldKeys {
+			if err = history.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// getCameraStatus returns the current status of camera, along with its state
+// changes and its uptime within the uptime window before now.
+func (h *WebHandler) getCameraStatus(camID string, now time.Time) CameraStatus {
+	status := CameraStatus{
+		State:   stateUnknown,
+		History: []StateChange{},
+	}
+
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("camera-status"))
+		if bucket == nil {
+			return nil
+		}
+
+		if content := bucket.Get([]byte(camID)); content != nil {
+			json.Unmarshal(content, &status)
+		}
+
+		history := cameraSubBucket(tx, "camera-status-history", camID)
+		if history == nil {
+			return nil
+		}
+
+		// Include the last change before window, since it's the state in the start of window
+		windowStart := now.Add(-healthUptimeWindow)
+		c := history.Cursor()
+		k, _ := c.Seek(timeKey(windowStart))
+
+		var prevK, prevV []byte
+		if k == nil {
+			prevK, prevV = c.Last()
+		} else {
+			prevK, prevV = c.Prev()
+		}
+
+		if prevK != nil {
+			status.History = append(status.History, StateChange{Time: keyTime(prevK), State: string(prevV)})
+		}
+
+		for k, v := c.Seek(timeKey(windowStart)); k != nil; k, v = c.Next() {
+			status.History = append(status.History, StateChange{Time: keyTime(k), State: string(v)})
+		}
+
+		return nil
+	})
+
+	status.Uptime = calculateUptime(status.History, now.Add(-healthUptimeWindow), now)
+	return status
+}
+
+// deleteCameraStatus removes status and state history of camera.
+func (h *WebHandler) deleteCameraStatus(camID string) {
+	h.DB.Update(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte("camera-status")); bucket != nil {
+			bucket.Delete([]byte(camID))
+		}
+
+		if bucket := tx.Bucket([]byte("camera-status-history")); bucket != nil {
+			bucket.DeleteBucket([]byte(camID))
+		}
+
+		return nil
+	})
+}
+
+// calculateUptime returns the fraction of time between start and end where the
+// camera is online. Period before the first state change is not counted.
+func calculateUptime(history []StateChange, start, end time.Time) float64 {
+	var online, observed time.Duration
+	for i, change := range history {
+		periodStart := change.Time
+		if periodStart.Before(start) {
+			periodStart = start
+		}
+
+		periodEnd := end
+		if i < len(history)-1 {
+			periodEnd = history[i+1].Time
+		}
+
+		if !periodEnd.After(periodStart) {
+			continue
+		}
+
+		duration := periodEnd.Sub(periodStart)
+		observed += duration
+		if change.State == stateOnline {
+			online += duration
+		}
+	}
+
+	if observed == 0 {
+		return 0
+	}
+
+	return float64(online) / float64(observed)
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCameraStatusHistoryTrim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cygnus-nvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(fp.Join(dir, "cygnus.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	h := &WebHandler{DB: db, health: &healthMonitor{}}
+
+	// Save state changes that older than the max age, then a recent one
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.Local)
+	states := []string{stateOnline, stateOffline}
+	for i := 0; i < 10; i++ {
+		err = h.saveCameraStatus("1", states[i%2], nil, start.Add(time.Duration(i)*time.Minute), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = h.saveCameraStatus("1", stateOnline, nil, start.Add(healthHistoryAge+time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All of the old history must be removed
+	count := 0
+	db.View(func(tx *bolt.Tx) error {
+		count = cameraSubBucket(tx, "camera-status-history", "1").Stats().KeyN
+		return nil
+	})
+
+	if count != 1 {
+		t.Errorf("got %d state changes in history, want 1", count)
+	}
+}
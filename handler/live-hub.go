@@ -0,0 +1,528 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Count of segments that cached for each camera
+	liveSegmentCount = 6
+
+	// Camera stop being polled when there are no consumer for this duration
+	liveFeedIdleTimeout = time.Minute
+
+	// Maximum time to wait for the first playlist of a new feed
+	liveFeedStartTimeout = 30 * time.Second
+)
+
+// liveHub keeps the live feed of each camera, so every viewer and the recorder
+// share a single connection to the camera.
+type liveHub struct {
+	sync.Mutex
+	feeds map[string]*liveFeed
+
+	// positions is the position of stopped feeds, so the feed that restarted
+	// continues the sequence that served to viewers instead of going backwards.
+	positions map[string]liveFeedPosition
+
+	// clock returns the current time, used to estimate
+	// the start time of each segment.
+	clock func() time.Time
+}
+
+// liveFeed polls live playlist of a camera and caches its latest segments.
+type liveFeed struct {
+	sync.RWMutex
+	cam            Camera
+	stop           chan struct{}
+	ready          chan struct{}
+	isReady        bool
+	lastAccess     time.Time
+	targetDuration float64
+	nextSequence   int
+	segments       []liveSegment
+	err            error
+
+	// Segments that listed in camera's playlist but not downloaded yet. They
+	// are listed to viewers too, which stream them directly from camera.
+	pending []liveSegment
+
+	// Count of discontinuities in the served stream, and whether
+	// the next segment is discontinued from the previous one.
+	discontinuities int
+	discontinuity   bool
+
+	// Media sequence and URI of the last segment that
+	// downloaded from camera, used to find the new segments.
+	lastSequence int
+	lastURI      string
+}
+
+// liveFeedPosition is the sequence of the next segment that will be served by feed
+// of a camera, along with the count of discontinuities that served before it.
+type liveFeedPosition struct {
+	sequence        int
+	discontinuities int
+}
+
+// liveSegment is segment of live stream that cached in memory. Segment is
+// discontinued when its timestamps don't continue the previous segment, e.g.
+// because camera restarted. DiscontinuitySequence is the count of discontinuities
+// up to this segment, which used for EXT-X-DISCONTINUITY-SEQUENCE.
+type liveSegment struct {
+	Sequence              int
+	URI                   string
+	StartTime             time.Time
+	Duration              time.Duration
+	Data                  []byte
+	Discontinuity         bool
+	DiscontinuitySequence int
+}
+
+// liveSnapshot is the cached segments of a live feed at a time, along
+// with the segments that still being downloaded, which have no data yet.
+type liveSnapshot struct {
+	TargetDuration float64
+	Segments       []liveSegment
+	Pending        []liveSegment
+}
+
+// StartLiveHub prepares the hub for live feed of cameras. Each camera
+// is only polled while there are viewer or recorder that consume it.
+func (h *WebHandler) StartLiveHub() {
+	h.liveHub = &liveHub{
+		feeds:     make(map[string]*liveFeed),
+		positions: make(map[string]liveFeedPosition),
+		clock:     time.Now,
+	}
+
+	h.breakers = &circuitBreakers{
+		items: make(map[string]*circuitBreaker),
+	}
+
+	h.limiter = &cameraLimiter{
+		slots: make(map[string]chan struct{}),
+	}
+
+	h.sourceLocks = &cameraSourceLocks{
+		items: make(map[string]*sync.Mutex),
+	}
+
+	h.prepareCameraCache()
+}
+
+// getLiveSnapshot returns the cached segments of camera. If camera is not polled
+// yet, it will be started and this method waits until the first playlist fetched,
+// or until the context is canceled, e.g. because the viewer is disconnected.
+// Only the cached segments has data, so consumer that needs the content of the
+// pending segments must wait for the next snapshot or stream them from camera.
+func (h *WebHandler) getLiveSnapshot(ctx context.Context, cam Camera) (liveSnapshot, error) {
+	// Get the feed, or start it if not exist yet
+	h.liveHub.Lock()
+	feed, exist := h.liveHub.feeds[cam.ID]
+	if !exist {
+		feed = &liveFeed{
+			cam:   cam,
+			stop:  make(chan struct{}),
+			ready: make(chan struct{}),
+		}
+
+		// Continue the position of the previous feed, and mark its
+		// first segment as discontinued from the previous feed.
+		if position, stopped := h.liveHub.positions[cam.ID]; stopped {
+			feed.nextSequence = position.sequence
+			feed.discontinuities = position.discontinuities
+			feed.discontinuity = true
+		}
+
+		h.liveHub.feeds[cam.ID] = feed
+		go h.pollLiveFeed(feed)
+	}
+
+	feed.Lock()
+	feed.lastAccess = h.liveHub.clock()
+	feed.Unlock()
+	h.liveHub.Unlock()
+
+	// Wait until the feed is ready
+	select {
+	case <-feed.ready:
+	case <-ctx.Done():
+		return liveSnapshot{}, ctx.Err()
+	case <-time.After(liveFeedStartTimeout):
+		return liveSnapshot{}, fmt.Errorf("failed to connect to camera %s: timeout", cam.ID)
+	}
+
+	feed.RLock()
+	defer feed.RUnlock()
+
+	snapshot := liveSnapshot{
+		TargetDuration: feed.targetDuration,
+		Segments:       append([]liveSegment{}, feed.segments...),
+		Pending:        append([]liveSegment{}, feed.pending...),
+	}
+
+	return snapshot, feed.err
+}
+
+// stopLiveFeed stops polling the camera, e.g. because camera data is changed.
+func (h *WebHandler) stopLiveFeed(camID string) {
+	if h.liveHub == nil {
+		return
+	}
+
+	h.liveHub.Lock()
+	feed, exist := h.liveHub.feeds[camID]
+	if exist {
+		h.liveHub.removeFeed(feed)
+	}
+	h.liveHub.Unlock()
+
+	if exist {
+		close(feed.stop)
+	}
+}
+
+// removeFeed removes the feed from hub, and saves its position for the next
+// feed of the camera. The caller must hold the hub's lock.
+func (hub *liveHub) removeFeed(feed *liveFeed) {
+	feed.RLock()
+	hub.positions[feed.cam.ID] = liveFeedPosition{
+		sequence:        feed.nextSequence,
+		discontinuities: feed.discontinuities,
+	}
+	feed.RUnlock()
+
+	delete(hub.feeds, feed.cam.ID)
+}
+
+func (h *WebHandler) pollLiveFeed(feed *liveFeed) {
+	cam := feed.cam
+	logrus.Infoln("start live feed of camera", cam.ID)
+
+	// Abort the running request to camera once the feed is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-feed.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		waitTime := 5 * time.Second
+
+		playlist, err := h.fetchCameraPlaylist(ctx, cam)
+		if err == nil {
+			if playlist.TargetDuration > 0 {
+				waitTime = time.Duration(playlist.TargetDuration * float64(time.Second) / 2)
+			}
+
+			err = h.updateLiveFeed(ctx, feed, playlist)
+		}
+
+		feed.Lock()
+		feed.err = err
+		feed.Unlock()
+
+		feed.setReady()
+
+		// Stop when there are no consumer for a while
+		h.liveHub.Lock()
+		feed.RLock()
+		idle := h.liveHub.clock().Sub(feed.lastAccess) > liveFeedIdleTimeout
+		feed.RUnlock()
+
+		if idle && h.liveHub.feeds[cam.ID] == feed {
+			h.liveHub.removeFeed(feed)
+		}
+		h.liveHub.Unlock()
+
+		if idle {
+			logrus.Infoln("stop idle live feed of camera", cam.ID)
+			return
+		}
+
+		select {
+		case <-feed.stop:
+			logrus.Infoln("stop live feed of camera", cam.ID)
+			return
+		case <-time.After(waitTime):
+		}
+	}
+}
+
+// setReady marks the feed as ready, so its snapshot can be used.
+func (feed *liveFeed) setReady() {
+	feed.Lock()
+	defer feed.Unlock()
+
+	if !feed.isReady {
+		close(feed.ready)
+		feed.isReady = true
+	}
+}
+
+// updateLiveFeed downloads the new segments in playlist into the feed's cache.
+func (h *WebHandler) updateLiveFeed(ctx context.Context, feed *liveFeed, playlist hlsPlaylist) error {
+	feed.RLock()
+	lastSequence, lastURI := feed.lastSequence, feed.lastURI
+	feed.RUnlock()
+
+	// Only the newest segments are kept in cache, so never download the older ones.
+	// Segment is new if it's after the last downloaded segment, which found by its
+	// media sequence. If the sequence doesn't match, e.g. because camera doesn't
+	// number its segments, the last segment is looked up by its URI.
+	first := len(playlist.Segments) - liveSegmentCount
+	if first < 0 {
+		first = 0
+	}
+
+	// When the media sequence goes backward or the last segment is not found,
+	// camera has been restarted or the feed falls behind. In that case the new
+	// segments don't continue the previous ones, so they are marked as discontinued.
+	discontinuity := false
+	if lastURI != "" {
+		lastIdx := -1
+		if playlist.MediaSequence+len(playlist.Segments)-1 >= lastSequence {
+			lastIdx = lastSequence - playlist.MediaSequence
+			if lastIdx < 0 || lastIdx >= len(playlist.Segments) || playlist.Segments[lastIdx].URI != lastURI {
+				lastIdx = -1
+				for i, segment := range playlist.Segments {
+					if segment.URI == lastURI {
+						lastIdx = i
+					}
+				}
+			}
+		}
+
+		if lastIdx < 0 || lastIdx < first-1 {
+			discontinuity = true
+		} else {
+			first = lastIdx + 1
+		}
+	}
+
+	// Estimate the start time of each segment. Since the last segment
+	// in playlist has just been finished, count backward from now.
+	segmentStart := h.liveHub.clock()
+	startTimes := make([]time.Time, len(playlist.Segments))
+	for i := len(playlist.Segments) - 1; i >= 0; i-- {
+		segmentStart = segmentStart.Add(-secondsToDuration(playlist.Segments[i].Duration))
+		startTimes[i] = segmentStart
+	}
+
+	// List the new segments as pending, so viewers don't have to wait until
+	// they are downloaded. If the download failed, they are listed again with
+	// the same sequence on the next poll.
+	feed.Lock()
+	feed.targetDuration = playlist.TargetDuration
+	feed.pending = nil
+	discontinuities := feed.discontinuities
+	for i := first; i < len(playlist.Segments); i++ {
+		segmentDiscontinuity := i == first && (discontinuity || feed.discontinuity)
+		if segmentDiscontinuity {
+			discontinuities++
+		}
+
+		feed.pending = append(feed.pending, liveSegment{
+			Sequence:              feed.nextSequence + i - first,
+			URI:                   playlist.Segments[i].URI,
+			StartTime:             startTimes[i],
+			Duration:              secondsToDuration(playlist.Segments[i].Duration),
+			Discontinuity:         segmentDiscontinuity,
+			DiscontinuitySequence: discontinuities,
+		})
+	}
+	feed.Unlock()
+	feed.setReady()
+
+	// Download the pending segments into cache
+	for i := first; i < len(playlist.Segments); i++ {
+		data, err := h.downloadCameraSegment(ctx, feed.cam, playlist.Segments[i].URI)
+		if err != nil {
+			feed.Lock()
+			feed.pending = nil
+			feed.Unlock()
+			return err
+		}
+
+		feed.Lock()
+		segment := feed.pending[0]
+		segment.Data = data
+		feed.pending = feed.pending[1:]
+		feed.segments = append(feed.segments, segment)
+		feed.nextSequence++
+		feed.discontinuities = segment.DiscontinuitySequence
+		feed.discontinuity = false
+		feed.lastSequence = playlist.MediaSequence + i
+		feed.lastURI = segment.URI
+
+		if n := len(feed.segments); n > liveSegmentCount {
+			feed.segments = append([]liveSegment{}, feed.segments[n-liveSegmentCount:]...)
+		}
+		feed.Unlock()
+	}
+
+	return nil
+}
+
+// fetchCameraPlaylist fetches live playlist from the camera's source. If it
+// failed, the source is closed so it will be reopened on the next request.
+func (h *WebHandler) fetchCameraPlaylist(ctx context.Context, cam Camera) (hlsPlaylist, error) {
+	var playlist hlsPlaylist
+	err := h.useCameraSource(ctx, cam, func(source CameraSource) error {
+		// Frame source never has playlist, but the error doesn't close
+		// it since it may still be used by the recorder or MJPEG viewer.
+		if _, isFrameSource := source.(frameSource); isFrameSource {
+			return errNoHLSStream
+		}
+
+		var err error
+		playlist, err = source.Playlist(ctx)
+		return err
+	})
+
+	return playlist, err
+}
+
+// downloadCameraSegment downloads the whole segment from the camera's source.
+func (h *WebHandler) downloadCameraSegment(ctx context.Context, cam Camera, uri string) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := h.useCameraSource(ctx, cam, func(source CameraSource) error {
+		return source.Segment(ctx, uri, &buffer)
+	})
+
+	return buffer.Bytes(), err
+}
+
+// streamCameraSegment writes the segment into w while it's received from the
+// camera's source. Failure to write, e.g. because the viewer is disconnected, is
+// returned as it is and not counted as camera's failure.
+func (h *WebHandler) streamCameraSegment(ctx context.Context, cam Camera, uri string, w io.Writer) error {
+	dst := &viewerWriter{w: w}
+	err := h.useCameraSource(ctx, cam, func(source CameraSource) error {
+		err := source.Segment(ctx, uri, dst)
+		if dst.err != nil {
+			return nil
+		}
+		return err
+	})
+
+	if dst.err != nil {
+		return dst.err
+	}
+
+	return err
+}
+
+// viewerWriter is writer for the response to viewer, which
+// keeps its error so it can be told apart from camera's error.
+type viewerWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (vw *viewerWriter) Write(p []byte) (int, error) {
+	n, err := vw.w.Write(p)
+	if err != nil {
+		vw.err = err
+	}
+	return n, err
+}
+
+// writeLivePlaylist writes HLS playlist for the cached segments of camera.
+func (h *WebHandler) writeLivePlaylist(cam Camera, w http.ResponseWriter, r *http.Request) error {
+	snapshot, err := h.getLiveSnapshot(r.Context(), cam)
+	if err != nil && len(snapshot.Segments) == 0 {
+		return err
+	}
+
+	// Create playlist of the newest segments, including the pending ones
+	segments := append(snapshot.Segments, snapshot.Pending...)
+	if n := len(segments); n > liveSegmentCount {
+		segments = segments[n-liveSegmentCount:]
+	}
+
+	targetDuration := math.Max(1, math.Ceil(snapshot.TargetDuration))
+	mediaSequence, discontinuitySequence := 0, 0
+	if len(segments) > 0 {
+		first := segments[0]
+		mediaSequence = first.Sequence
+		discontinuitySequence = first.DiscontinuitySequence
+		if first.Discontinuity {
+			discontinuitySequence--
+		}
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:3\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(targetDuration)))
+	sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", mediaSequence))
+	if discontinuitySequence > 0 {
+		sb.WriteString(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuitySequence))
+	}
+
+	for _, segment := range segments {
+		if segment.Discontinuity {
+			sb.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n", segment.Duration.Seconds()))
+		sb.WriteString(path.Join("/", "cam", cam.ID, "live", "stream", strconv.Itoa(segment.Sequence)) + "\n")
+	}
+
+	// Set response header
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	_, err = w.Write([]byte(sb.String()))
+	return err
+}
+
+// writeLiveSegment writes the segment with the specified sequence. Cached segment
+// is written from memory, while pending segment is streamed from the camera and the
+// request to camera is aborted once the viewer is disconnected.
+func (h *WebHandler) writeLiveSegment(cam Camera, sequence int, w http.ResponseWriter, r *http.Request) error {
+	snapshot, _ := h.getLiveSnapshot(r.Context(), cam)
+
+	setHeader := func() {
+		w.Header().Set("Content-Type", "video/MP2T")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	}
+
+	for _, segment := range snapshot.Segments {
+		if segment.Sequence == sequence {
+			setHeader()
+			_, err := w.Write(segment.Data)
+			return err
+		}
+	}
+
+	for _, segment := range snapshot.Pending {
+		if segment.Sequence == sequence {
+			setHeader()
+			return h.streamCameraSegment(r.Context(), cam, segment.URI, w)
+		}
+	}
+
+	http.NotFound(w, r)
+	return nil
+}
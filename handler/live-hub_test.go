@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cch "github.com/patrickmn/go-cache"
+	bolt "go.etcd.io/bbolt"
+)
+
+// benchCamera is HLS camera that counts the requests it received. Its segments
+// are only sent after delay, unless the request is canceled before that.
+type benchCamera struct {
+	delay       time.Duration
+	segment     []byte
+	requests    int64
+	inFlight    int64
+	maxInFlight int64
+	canceled    int64
+}
+
+func (c *benchCamera) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&c.requests, 1)
+	n := atomic.AddInt64(&c.inFlight, 1)
+	defer atomic.AddInt64(&c.inFlight, -1)
+
+	for {
+		max := atomic.LoadInt64(&c.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt64(&c.maxInFlight, max, n) {
+			break
+		}
+	}
+
+	if r.URL.Path == "/live.m3u8" {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:0\n")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "#EXTINF:2.0,\nsegment-%d.ts\n", i)
+		}
+		return
+	}
+
+	select {
+	case <-r.Context().Done():
+		atomic.AddInt64(&c.canceled, 1)
+	case <-time.After(c.delay):
+		w.Write(c.segment)
+	}
+}
+
+func newBenchHandler(b testing.TB, camera *benchCamera) (*WebHandler, Camera, func()) {
+	server := httptest.NewServer(camera)
+
+	dir, err := ioutil.TempDir("", "cygnus-nvr")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	db, err := bolt.Open(fp.Join(dir, "cygnus.db"), 0600, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	h := &WebHandler{
+		DB:          db,
+		CameraCache: cch.New(time.Hour, time.Minute),
+	}
+	h.StartLiveHub()
+
+	cam := Camera{ID: "1", Type: "hls", URL: server.URL + "/live.m3u8"}
+	cleanup := func() {
+		h.stopLiveFeed(cam.ID)
+		h.closeCameraSource(cam.ID)
+		server.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	return h, cam, cleanup
+}
+
+func TestLiveFeedDiscontinuity(t *testing.T) {
+	camera := &benchCamera{segment: []byte{0x47}}
+	h, cam, cleanup := newBenchHandler(t, camera)
+	defer cleanup()
+
+	baseURL := strings.TrimSuffix(cam.URL, "live.m3u8")
+	newPlaylist := func(mediaSequence int, names ...string) hlsPlaylist {
+		playlist := hlsPlaylist{TargetDuration: 2, MediaSequence: mediaSequence}
+		for _, name := range names {
+			playlist.Segments = append(playlist.Segments, hlsSegment{URI: baseURL + name, Duration: 2})
+		}
+		return playlist
+	}
+
+	// Register the feed without polling, so its playlists can be controlled
+	feed := &liveFeed{cam: cam, stop: make(chan struct{}), ready: make(chan struct{})}
+	feed.setReady()
+	h.liveHub.Lock()
+	h.liveHub.feeds[cam.ID] = feed
+	h.liveHub.Unlock()
+
+	writePlaylist := func() string {
+		w := httptest.NewRecorder()
+		err := h.writeLivePlaylist(cam, w, httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w.Body.String()
+	}
+
+	// Camera is restarted after the fourth segment, so its media sequence goes backward
+	playlists := []hlsPlaylist{
+		newPlaylist(10, "a.ts", "b.ts", "c.ts"),
+		newPlaylist(11, "b.ts", "c.ts", "d.ts"),
+		newPlaylist(0, "a.ts", "b.ts"),
+	}
+
+	for _, playlist := range playlists {
+		err := h.updateLiveFeed(context.Background(), feed, playlist)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	playlist := writePlaylist()
+	if n := strings.Count(playlist, "#EXT-X-DISCONTINUITY\n"); n != 1 {
+		t.Errorf("got %d discontinuities after camera restarted, want 1:\n%s", n, playlist)
+	}
+
+	if !strings.Contains(playlist, "#EXT-X-DISCONTINUITY\n#EXTINF:2.000,\n/cam/1/live/stream/4\n") {
+		t.Errorf("segment after camera restarted is not discontinued:\n%s", playlist)
+	}
+
+	// The restarted feed continues the sequence of the previous one
+	h.stopLiveFeed(cam.ID)
+	playlist = writePlaylist()
+
+	for _, line := range []string{
+		"#EXT-X-MEDIA-SEQUENCE:6\n",
+		"#EXT-X-DISCONTINUITY-SEQUENCE:1\n",
+		"#EXT-X-DISCONTINUITY\n#EXTINF:2.000,\n/cam/1/live/stream/6\n",
+	} {
+		if !strings.Contains(playlist, line) {
+			t.Errorf("playlist of restarted feed doesn't contain %q:\n%s", line, playlist)
+		}
+	}
+}
+
+func TestLivePendingSegment(t *testing.T) {
+	camera := &benchCamera{delay: time.Second, segment: []byte{0x47}}
+	h, cam, cleanup := newBenchHandler(t, camera)
+	defer cleanup()
+
+	snapshot, err := h.getLiveSnapshot(context.Background(), cam)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshot.Segments) != 0 || len(snapshot.Pending) != 3 {
+		t.Fatalf("got %d cached and %d pending segments, want 0 and 3", len(snapshot.Segments), len(snapshot.Pending))
+	}
+
+	// Pending segment is streamed from camera, and its request is
+	// canceled as soon as the viewer is disconnected.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	err = h.writeLiveSegment(cam, snapshot.Pending[2].Sequence, httptest.NewRecorder(), r)
+	if err == nil {
+		t.Fatal("segment is served after viewer disconnected")
+	}
+
+	if elapsed := time.Since(start); elapsed > camera.delay/2 {
+		t.Errorf("viewer returned after %v, want before camera responded", elapsed)
+	}
+
+	deadline := time.Now().Add(camera.delay)
+	for atomic.LoadInt64(&camera.canceled) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if atomic.LoadInt64(&camera.canceled) == 0 {
+		t.Error("request to camera is not canceled")
+	}
+
+	// Without disconnecting, the viewer receives the whole segment
+	w := httptest.NewRecorder()
+	err = h.writeLiveSegment(cam, snapshot.Pending[2].Sequence, w, httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(w.Body.Bytes(), camera.segment) {
+		t.Errorf("got segment %x, want %x", w.Body.Bytes(), camera.segment)
+	}
+}
+
+// BenchmarkLiveViewers simulates many viewers that watch the same camera at once. Each
+// viewer fetches the live playlist then its latest segment. Once the segments are cached
+// they are served from memory, so the requests to camera don't grow along with the count
+// of viewers.
+func BenchmarkLiveViewers(b *testing.B) {
+	for _, viewers := range []int{1, 16, 256} {
+		b.Run(fmt.Sprintf("viewers=%d", viewers), func(b *testing.B) {
+			camera := &benchCamera{segment: bytes.Repeat([]byte{0x47}, 256*1024)}
+			h, cam, cleanup := newBenchHandler(b, camera)
+			defer cleanup()
+
+			// Start the live feed and wait until its segments are cached before measuring
+			for {
+				snapshot, err := h.getLiveSnapshot(context.Background(), cam)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if len(snapshot.Segments) > 0 && len(snapshot.Pending) == 0 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			watch := func() error {
+				playlist := httptest.NewRecorder()
+				err := h.writeLivePlaylist(cam, playlist, httptest.NewRequest("GET", "/", nil))
+				if err != nil {
+					return err
+				}
+
+				lines := strings.Split(strings.TrimSpace(playlist.Body.String()), "\n")
+				var sequence int
+				_, err = fmt.Sscanf(lines[len(lines)-1], "/cam/1/live/stream/%d", &sequence)
+				if err != nil {
+					return fmt.Errorf("playlist is not valid: %v", err)
+				}
+
+				segment := httptest.NewRecorder()
+				err = h.writeLiveSegment(cam, sequence, segment, httptest.NewRequest("GET", "/", nil))
+				if err == nil && segment.Body.Len() != len(camera.segment) {
+					err = fmt.Errorf("got segment with %d bytes", segment.Body.Len())
+				}
+				return err
+			}
+
+			startRequests := atomic.LoadInt64(&camera.requests)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				errs := make(chan error, viewers)
+				for v := 0; v < viewers; v++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if err := watch(); err != nil {
+							errs <- err
+						}
+					}()
+				}
+
+				wg.Wait()
+				close(errs)
+				if err := <-errs; err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.StopTimer()
+			requests := atomic.LoadInt64(&camera.requests) - startRequests
+			b.ReportMetric(float64(requests)/float64(b.N), "upstream-req/op")
+		})
+	}
+}
+
+// BenchmarkDisconnectedViewers simulates many viewers that request the pending segment
+// of slow camera, which streamed directly from camera, then disconnect shortly after.
+// The viewers return as soon as they are disconnected and the upstream requests are
+// canceled along with them. At most maxCameraRequests requests are sent at once, although
+// camera may see a few more since it only notices the canceled requests after their
+// connection is closed.
+func BenchmarkDisconnectedViewers(b *testing.B) {
+	const disconnectAfter = 10 * time.Millisecond
+
+	for _, viewers := range []int{16, 256} {
+		b.Run(fmt.Sprintf("viewers=%d", viewers), func(b *testing.B) {
+			camera := &benchCamera{delay: 5 * time.Second, segment: []byte{0x47}}
+			h, cam, cleanup := newBenchHandler(b, camera)
+			defer cleanup()
+
+			// The feed is ready once the playlist is fetched, while
+			// its segments are still being downloaded by the hub.
+			snapshot, err := h.getLiveSnapshot(context.Background(), cam)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			if len(snapshot.Pending) == 0 {
+				b.Fatal("there are no pending segment")
+			}
+
+			sequence := snapshot.Pending[len(snapshot.Pending)-1].Sequence
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for v := 0; v < viewers; v++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						ctx, cancel := context.WithTimeout(context.Background(), disconnectAfter)
+						defer cancel()
+
+						r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+						h.writeLiveSegment(cam, sequence, httptest.NewRecorder(), r)
+					}()
+				}
+				wg.Wait()
+			}
+
+			b.StopTimer()
+
+			// Wait until the canceled requests are noticed by camera
+			deadline := time.Now().Add(time.Second)
+			for atomic.LoadInt64(&camera.inFlight) > 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			b.ReportMetric(float64(atomic.LoadInt64(&camera.maxInFlight)), "max-upstream")
+			b.ReportMetric(float64(atomic.LoadInt64(&camera.canceled))/float64(b.N), "canceled-upstream/op")
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Count of failed login before username or IP address is locked out.
+	// IP address has higher limit since many users may share it behind NAT.
+	loginUserFailureLimit = 5
+	loginIPFailureLimit   = 20
+
+	// After each failed login, the next login must wait for a delay that
+	// doubled on every failure, until it's locked out for a while.
+	loginMinDelay        = time.Second
+	loginMaxDelay        = time.Minute
+	loginLockoutDuration = 15 * time.Minute
+
+	// Failure count is reset when there are no failed login for this duration
+	loginFailureWindow = time.Hour
+
+	// Age and count of failed login that kept for auditing
+	loginFailureAge     = 30 * 24 * time.Hour
+	maxLoginFailureLogs = 100
+)
+
+// loginGuard counts the failed login of each username and IP address, to
+// slow down and lock out the brute-force attack on password.
+type loginGuard struct {
+	sync.Mutex
+	counters map[string]*loginCounter
+
+	// clock returns the current time
+	clock func() time.Time
+}
+
+// loginCounter is the failed login of a username or IP address.
+// Pending is count of login that still checking its password.
+type loginCounter struct {
+	limit       int
+	failures    int
+	pending     int
+	lastFailure time.Time
+	retryTime   time.Time
+}
+
+// loginLockedError is returned when login is attempted before its delay is over.
+type loginLockedError struct {
+	retryAfter time.Duration
+}
+
+func (e *loginLockedError) Error() string {
+	return fmt.Sprintf("too many failed login, retry in %s", e.retryAfter.Round(time.Second))
+}
+
+// checkLogin is like checkPassword, but it rejects login from username or IP address
+// that has failed too many times. The failed login is counted and saved for auditing.
+func (h *WebHandler) checkLogin(r *http.Request, username string, password string) (bool, error) {
+	ip := requestIP(r)
+	userKey, ipKey := "user:"+username, "ip:"+ip
+
+	// The attempt is reserved before checking password, so parallel
+	// login can't slip through while the previous ones still checked.
+	err := h.loginGuard.reserve(map[string]int{
+		userKey: loginUserFailureLimit,
+		ipKey:   loginIPFailureLimit,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	isDefaultAccount, err := h.checkPassword(username, password)
+	if err != nil {
+		h.loginGuard.recordFailure(userKey)
+		h.loginGuard.recordFailure(ipKey)
+		h.saveLoginFailure(LoginFailure{
+			Time:      time.Now(),
+			Username:  username,
+			IP:        ip,
+			UserAgent: r.UserAgent(),
+			Reason:    err.Error(),
+		})
+		return false, err
+	}
+
+	// IP address is not reset, so attacker can't reset it by logging in to its own account
+	h.loginGuard.reset(userKey)
+	h.loginGuard.release(ipKey)
+	return isDefaultAccount, nil
+}
+
+// unlockUser removes the failed login of username, so it can log in immediately.
+// If IP address is specified, its failed login is removed as well.
+func (h *WebHandler) unlockUser(username string, ip string) {
+	h.loginGuard.reset("user:" + username)
+	if ip != "" {
+		h.loginGuard.reset("ip:" + ip)
+	}
+}
+
+// reserve returns error when any of the keys must wait before its next login.
+// Otherwise, the login is counted as pending in each key, until its password
+// checked and either recordFailure, release or reset is called. The keys is
+// mapped to the count of failed login before it's locked out.
+func (g *loginGuard) reserve(keys map[string]int) error {
+	if g == nil {
+		return nil
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	// Remove the stale counters, so they don't pile up
+	now := g.clock()
+	for k, counter := range g.counters {
+		if counter.pending == 0 && now.Sub(counter.lastFailure) > loginFailureWindow && now.After(counter.retryTime) {
+			delete(g.counters, k)
+		}
+	}
+
+	var retryAfter time.Duration
+	for key, limit := range keys {
+		counter, exist := g.counters[key]
+		if !exist {
+			continue
+		}
+
+		// Once the key has failed, its next login must wait for the pending
+		// one to finish, so it can't skip the delay. Before that, the pending
+		// login is limited so it can't exceed the count before lockout.
+		wait := counter.retryTime.Sub(now)
+		busy := counter.pending > 0 && (counter.failures > 0 || counter.failures+counter.pending >= limit)
+		if busy && wait < loginMinDelay {
+			wait = loginMinDelay
+		}
+
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		return &loginLockedError{retryAfter: retryAfter}
+	}
+
+	for key, limit := range keys {
+		counter, exist := g.counters[key]
+		if !exist {
+			counter = &loginCounter{}
+			g.counters[key] = counter
+		}
+
+		counter.limit = limit
+		counter.pending++
+	}
+
+	return nil
+}
+
+// release marks the pending login of key as finished without failure.
+func (g *loginGuard) release(key string) {
+	if g == nil {
+		return
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	if counter, exist := g.counters[key]; exist && counter.pending > 0 {
+		counter.pending--
+	}
+}
+
+// recordFailure counts the failed pending login of key, then sets the time before
+// its next login is allowed. When it reaches the limit, the key is locked out.
+func (g *loginGuard) recordFailure(key string) {
+	if g == nil {
+		return
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	// Counter is removed when the key is reset while the login is pending
+	counter, exist := g.counters[key]
+	if !exist {
+		return
+	}
+
+	now := g.clock()
+	if counter.pending > 0 {
+		counter.pending--
+	}
+
+	counter.failures++
+	counter.lastFailure = now
+
+	if counter.failures >= counter.limit {
+		counter.failures = 0
+		counter.retryTime = now.Add(loginLockoutDuration)
+		logrus.Warnf("%s is locked out for %s after %d failed login\n", key, loginLockoutDuration, counter.limit)
+		return
+	}
+
+	delay := loginMinDelay * time.Duration(1<<uint(counter.failures-1))
+	if delay > loginMaxDelay {
+		delay = loginMaxDelay
+	}
+	counter.retryTime = now.Add(delay)
+}
+
+// reset removes the failed login of key.
+func (g *loginGuard) reset(key string) {
+	if g == nil {
+		return
+	}
+
+	g.Lock()
+	delete(g.counters, key)
+	g.Unlock()
+}
+
+// saveLoginFailure saves the failed login into database for auditing,
+// then removes the failed login that older than the max age.
+func (h *WebHandler) saveLoginFailure(failure LoginFailure) {
+	data, err := json.Marshal(&failure)
+	if err != nil {
+		return
+	}
+
+	err = h.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("login-failure"))
+		if err != nil {
+			return err
+		}
+
+		// Failures may happen at the same time, so the key is
+		// suffixed by sequence number to keep each of them.
+		sequence, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, sequence)
+		err = bucket.Put(append(timeKey(failure.Time), key...), data)
+		if err != nil {
+			return err
+		}
+
+		// Collect the old keys first, since deleting while iterating skips entries
+		limit := failure.Time.Add(-loginFailureAge)
+		oldKeys := [][]byte{}
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && keyTime(k).Before(limit); k, _ = c.Next() {
+			oldKeys = append(oldKeys, append([]byte{}, k...))
+		}
+
+		for _, key := range oldKeys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logrus.Warnf("failed to save failed login of %s: %v\n", failure.Username, err)
+	}
+}
+
+// getLoginFailures returns the latest failed login, newest first.
+func (h *WebHandler) getLoginFailures() []LoginFailure {
+	failures := []LoginFailure{}
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("login-failure"))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil && len(failures) < maxLoginFailureLogs; k, v = c.Prev() {
+			var failure LoginFailure
+			if json.Unmarshal(v, &failure) == nil {
+				failures = append(failures, failure)
+			}
+		}
+
+		return nil
+	})
+
+	return failures
+}
+
+// writeLoginLockedError responds with too many requests status if the login
+// is rejected because of too many failures. Otherwise, it returns false.
+func writeLoginLockedError(w http.ResponseWriter, err error) bool {
+	e, isLocked := err.(*loginLockedError)
+	if !isLocked {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+	return true
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestSaveLoginFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cygnus-nvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(fp.Join(dir, "cygnus.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	h := &WebHandler{DB: db}
+
+	// Save failures that older than the max age
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.Local)
+	for i := 0; i < 10; i++ {
+		h.saveLoginFailure(LoginFailure{
+			Time:     start.Add(time.Duration(i) * time.Minute),
+			Username: fmt.Sprintf("old-%d", i),
+		})
+	}
+
+	// Failures at the same time must be kept, while all of the old ones are removed
+	now := start.Add(loginFailureAge + time.Hour)
+	h.saveLoginFailure(LoginFailure{Time: now, Username: "alice", IP: "10.0.0.1"})
+	h.saveLoginFailure(LoginFailure{Time: now, Username: "bob", IP: "10.0.0.2"})
+
+	failures := h.getLoginFailures()
+	if len(failures) != 2 {
+		t.Fatalf("got %d failures, want 2: %+v", len(failures), failures)
+	}
+
+	if failures[0].Username != "bob" || failures[1].Username != "alice" {
+		t.Errorf("got failures of %s and %s, want bob and alice", failures[0].Username, failures[1].Username)
+	}
+}
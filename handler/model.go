@@ -1,18 +1,85 @@
 package handler
 
-// User is person that given access to NVR
+import "time"
+
+// User is person that given access to NVR. Cameras is list of camera ID
+// that allowed to be accessed by user, which is ignored for admin.
 type User struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string   `json:"username"`
+	Password string   `json:"password,omitempty"`
+	Role     string   `json:"role"`
+	Cameras  []string `json:"cameras"`
 }
 
 // Camera is camera that saved in NVR
 type Camera struct {
 	ID       string `json:"id"`
+	Type     string `json:"type"`
 	URL      string `json:"url"`
 	Name     string `json:"name"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	OnvifURL string `json:"onvifUrl"`
+
+	// ConnectTimeout and ReadTimeout are timeouts in seconds for connecting
+	// to camera and for waiting its response. Zero means default timeout.
+	ConnectTimeout int `json:"connectTimeout"`
+	ReadTimeout    int `json:"readTimeout"`
+}
+
+// CameraSummary is camera data that shown in list of camera
+type CameraSummary struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// DiscoverRequest is request for discovering ONVIF cameras in local network.
+// The credential is used for querying the stream URI of each camera.
+type DiscoverRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// DiscoveredCamera is camera that found in local network. Its camera data
+// can be saved as it is, unless there is an error while querying it.
+type DiscoveredCamera struct {
+	Camera
+	Address      string `json:"address"`
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+	Error        string `json:"error,omitempty"`
+}
+
+// PTZRequest is request for controlling PTZ of camera. Pan, tilt and zoom is
+// the velocity for move and zoom action, the position for absolute action and
+// the translation for relative action. Axis that not specified is not moved.
+type PTZRequest struct {
+	Action  string   `json:"action"`
+	Pan     *float64 `json:"pan"`
+	Tilt    *float64 `json:"tilt"`
+	Zoom    *float64 `json:"zoom"`
+	Timeout float64  `json:"timeout"`
+	Preset  string   `json:"preset"`
+	Name    string   `json:"name"`
+}
+
+// CameraStatus is connection health of camera that checked by health monitor.
+// Latency is the duration of the last probe in milliseconds, while uptime is the
+// fraction of time where camera is online, following the state history.
+type CameraStatus struct {
+	State     string        `json:"state"`
+	Error     string        `json:"error,omitempty"`
+	LastSeen  time.Time     `json:"lastSeen"`
+	LastProbe time.Time     `json:"lastProbe"`
+	Latency   float64       `json:"latency"`
+	Uptime    float64       `json:"uptime"`
+	History   []StateChange `json:"history,omitempty"`
+}
+
+// StateChange is the time when connection state of camera is changed
+type StateChange struct {
+	Time  time.Time `json:"time"`
+	State string    `json:"state"`
 }
 
 // LoginRequest is login request
@@ -21,3 +88,139 @@ type LoginRequest struct {
 	Password string `json:"password"`
 	Remember int    `json:"remember"`
 }
+
+// TokenRequest is request for access token. Scopes are the same as the ones used
+// by API key, default to all scopes. Expires is in hours.
+type TokenRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Scopes   []string `json:"scopes"`
+	Expires  int      `json:"expires"`
+}
+
+// APIKeyRequest is request for creating API key. Zero expiry time means the key never expires.
+type APIKeyRequest struct {
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Expires time.Time `json:"expires"`
+}
+
+// APIKey is long-lived key for accessing NVR from script, which sent as bearer
+// token in Authorization header. The key itself is only shown once it's created.
+type APIKey struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"lastUsed"`
+	Key      string    `json:"key,omitempty"`
+}
+
+// AccessToken is signed token that sent as bearer token in Authorization header
+type AccessToken struct {
+	Token   string    `json:"token"`
+	Scopes  []string  `json:"scopes"`
+	Expires time.Time `json:"expires"`
+}
+
+// RetentionSetting is the rule for deleting old recordings of a camera.
+// Zero value means there are no limit.
+type RetentionSetting struct {
+	MaxAge  int   `json:"maxAge"`
+	MaxSize int64 `json:"maxSize"`
+}
+
+// Setting is the NVR setting that can be changed by user
+type Setting struct {
+	MinFreeSpace int64                       `json:"minFreeSpace"`
+	Retention    map[string]RetentionSetting `json:"retention"`
+}
+
+// LoginFailure is failed login that saved for auditing
+type LoginFailure struct {
+	Time      time.Time `json:"time"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Reason    string    `json:"reason"`
+}
+
+// DeletedRecording is recording that deleted by retention engine
+type DeletedRecording struct {
+	Time     time.Time `json:"time"`
+	CameraID string    `json:"cameraId"`
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	Reason   string    `json:"reason"`
+}
+
+// RecordedVideo is video file that saved by recorder
+type RecordedVideo struct {
+	File string    `json:"file"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+	URL  string    `json:"url"`
+}
+
+// ExportRequest is request for exporting recording between two timestamps
+type ExportRequest struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ExportJob is background job for exporting recording into a single MP4 file
+type ExportJob struct {
+	ID        string    `json:"id"`
+	CameraID  string    `json:"cameraId"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Status    string    `json:"status"`
+	Progress  float64   `json:"progress"`
+	Error     string    `json:"error,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// TimelineInterval is a period in recording timeline. For gap, cause
+// contains the reason why there are no recording in that period.
+type TimelineInterval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Cause string    `json:"cause,omitempty"`
+}
+
+// Timeline is the recording coverage of a camera in a day
+type Timeline struct {
+	Date      string             `json:"date"`
+	Coverage  float64            `json:"coverage"`
+	Intervals []TimelineInterval `json:"intervals"`
+	Gaps      []TimelineInterval `json:"gaps"`
+}
+
+// ScheduleRange is time range in a weekday where the camera is recorded
+// with the specified mode. Weekday starts from 0 for Sunday, while start
+// and end is formatted as HH:MM.
+type ScheduleRange struct {
+	Weekday int    `json:"weekday"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Mode    string `json:"mode"`
+}
+
+// Schedule is weekly recording schedule of a camera. Outside of the
+// specified ranges, camera is recorded using the default mode. In event
+// mode, camera is recorded from PreEvent seconds before an event until
+// PostEvent seconds after it.
+type Schedule struct {
+	DefaultMode string          `json:"defaultMode"`
+	Ranges      []ScheduleRange `json:"ranges"`
+	PreEvent    int             `json:"preEvent"`
+	PostEvent   int             `json:"postEvent"`
+}
+
+// EventRequest is request for triggering event in a camera
+type EventRequest struct {
+	Source string `json:"source"`
+}
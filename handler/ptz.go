@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"fmt"
+	nurl "net/url"
+	"sync"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/onvif"
+)
+
+// List of action for controlling PTZ
+const (
+	ptzMove      = "move"
+	ptzStop      = "stop"
+	ptzAbsolute  = "absolute"
+	ptzRelative  = "relative"
+	ptzZoom      = "zoom"
+	ptzGoto      = "goto"
+	ptzSetPreset = "set-preset"
+)
+
+// Duration before the cached PTZ client and presets of camera is refreshed
+const ptzCacheExpiration = time.Hour
+
+// ptzCamera is the cached ONVIF client of camera, along with its presets.
+// Presets is nil when it's not fetched yet or when it's changed.
+type ptzCamera struct {
+	sync.Mutex
+	client  *onvif.Client
+	presets []onvif.Preset
+}
+
+// getPTZCamera returns the cached ONVIF client for camera. If the camera doesn't
+// have ONVIF URL, the default device service in the camera's host is used.
+func (h *WebHandler) getPTZCamera(cam Camera) (*ptzCamera, error) {
+	if cached, exist := h.PTZCache.Get(cam.ID); exist {
+		return cached.(*ptzCamera), nil
+	}
+
+	deviceURL := cam.OnvifURL
+	if deviceURL == "" {
+		camURL, err := nurl.Parse(cam.URL)
+		if err != nil || camURL.Hostname() == "" {
+			return nil, fmt.Errorf("camera %s doesn't have onvif url", cam.ID)
+		}
+
+		deviceURL = "http://" + camURL.Hostname() + "/onvif/device_service"
+	}
+
+	ptz := &ptzCamera{client: onvif.NewClient(deviceURL, cam.Username, cam.Password)}
+	h.PTZCache.Set(cam.ID, ptz, ptzCacheExpiration)
+	return ptz, nil
+}
+
+// controlPTZ runs the PTZ action in request. For set-preset action,
+// it returns the saved preset. If it failed, the cached client is
+// removed, so the camera's capabilities are fetched again later.
+func (h *WebHandler) controlPTZ(cam Camera, request PTZRequest) (*onvif.Preset, error) {
+	ptz, err := h.getPTZCamera(cam)
+	if err != nil {
+		return nil, err
+	}
+
+	ptz.Lock()
+	defer ptz.Unlock()
+
+	var preset *onvif.Preset
+	switch request.Action {
+	case ptzMove:
+		err = ptz.client.ContinuousMove(request.vector(true), secondsToDuration(request.Timeout))
+	case ptzZoom:
+		err = ptz.client.ContinuousMove(request.vector(false), secondsToDuration(request.Timeout))
+	case ptzStop:
+		err = ptz.client.Stop()
+	case ptzAbsolute:
+		err = ptz.client.AbsoluteMove(request.vector(true))
+	case ptzRelative:
+		err = ptz.client.RelativeMove(request.vector(true))
+	case ptzGoto:
+		err = ptz.client.GotoPreset(request.Preset)
+	case ptzSetPreset:
+		var token string
+		token, err = ptz.client.SetPreset(request.Name, request.Preset)
+		preset = &onvif.Preset{Token: token, Name: request.Name}
+		ptz.presets = nil
+	}
+
+	if err != nil {
+		h.PTZCache.Delete(cam.ID)
+		return nil, err
+	}
+
+	return preset, nil
+}
+
+// getPTZPresets returns presets of camera. The presets is cached,
+// unless refresh is true or they are changed by set-preset action.
+func (h *WebHandler) getPTZPresets(cam Camera, refresh bool) ([]onvif.Preset, error) {
+	ptz, err := h.getPTZCamera(cam)
+	if err != nil {
+		return nil, err
+	}
+
+	ptz.Lock()
+	defer ptz.Unlock()
+
+	if ptz.presets != nil && !refresh {
+		return ptz.presets, nil
+	}
+
+	presets, err := ptz.client.GetPresets()
+	if err != nil {
+		h.PTZCache.Delete(cam.ID)
+		return nil, err
+	}
+
+	ptz.presets = presets
+	return presets, nil
+}
+
+// validate checks if the action is supported and has the required parameters.
+func (r PTZRequest) validate() error {
+	switch r.Action {
+	case ptzStop:
+	case ptzMove, ptzAbsolute, ptzRelative:
+		if r.Pan == nil && r.Tilt == nil && r.Zoom == nil {
+			return fmt.Errorf("pan, tilt or zoom must be specified")
+		}
+	case ptzZoom:
+		if r.Zoom == nil {
+			return fmt.Errorf("zoom must be specified")
+		}
+	case ptzGoto:
+		if r.Preset == "" {
+			return fmt.Errorf("preset must be specified")
+		}
+	case ptzSetPreset:
+		if r.Name == "" {
+			return fmt.Errorf("preset name must be specified")
+		}
+	default:
+		return fmt.Errorf("ptz action %s is not supported", r.Action)
+	}
+
+	if r.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+
+	return nil
+}
+
+// vector returns the pan, tilt and zoom in request as ONVIF vector. If
+// withPanTilt is false, only the zoom is used.
+func (r PTZRequest) vector(withPanTilt bool) onvif.Vector {
+	vector := onvif.Vector{Zoom: r.Zoom}
+	if withPanTilt && (r.Pan != nil || r.Tilt != nil) {
+		vector.PanTilt = &onvif.PanTilt{}
+		if r.Pan != nil {
+			vector.PanTilt.X = *r.Pan
+		}
+		if r.Tilt != nil {
+			vector.PanTilt.Y = *r.Tilt
+		}
+	}
+
+	return vector
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Default duration of recording around an event, in seconds
+const (
+	defaultPreEvent  = 10
+	defaultPostEvent = 30
+	maxPreEvent      = 300
+)
+
+// Duration for keeping the recording window of event after it ended
+const eventWindowAge = time.Hour
+
+// eventWindow is time range around events, which recorded in event mode.
+type eventWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// bufferedSegment is segment that kept in memory by recorder in event mode,
+// so it can be saved later when an event happened shortly after it.
+type bufferedSegment struct {
+	StartTime time.Time
+	Duration  time.Duration
+	Data      []byte
+}
+
+// trimSegmentBuffer removes the buffered segments that ended before limit.
+func trimSegmentBuffer(buffer []bufferedSegment, limit time.Time) []bufferedSegment {
+	for len(buffer) > 0 && buffer[0].StartTime.Add(buffer[0].Duration).Before(limit) {
+		buffer = buffer[1:]
+	}
+
+	return buffer
+}
+
+func (s Schedule) preEventDuration() time.Duration {
+	return time.Duration(s.PreEvent) * time.Second
+}
+
+func (s Schedule) postEventDuration() time.Duration {
+	return time.Duration(s.PostEvent) * time.Second
+}
+
+// nearEvent checks if segment overlaps with the pre-event or post-event
+// duration of any event in worker.
+func (w *recordWorker) nearEvent(startTime time.Time, duration time.Duration) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	for _, window := range w.eventWindows {
+		if startTime.Before(window.End) && startTime.Add(duration).After(window.Start) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addEvent saves the recording window around event that happened at the specified
+// time. Window that overlaps the previous one is merged into it, and windows that
+// ended long ago are removed, so the windows don't grow along with the events.
+func (w *recordWorker) addEvent(eventTime time.Time) {
+	w.Lock()
+	defer w.Unlock()
+
+	// Keep the old windows for a while, since segment may be
+	// saved late, e.g. after camera is reconnected.
+	limit := eventTime.Add(-eventWindowAge)
+	for len(w.eventWindows) > 0 && w.eventWindows[0].End.Before(limit) {
+		w.eventWindows = w.eventWindows[1:]
+	}
+
+	window := eventWindow{
+		Start: eventTime.Add(-w.schedule.preEventDuration()),
+		End:   eventTime.Add(w.schedule.postEventDuration()),
+	}
+
+	if n := len(w.eventWindows); n > 0 && !window.Start.After(w.eventWindows[n-1].End) {
+		last := &w.eventWindows[n-1]
+		if window.Start.Before(last.Start) {
+			last.Start = window.Start
+		}
+		if window.End.After(last.End) {
+			last.End = window.End
+		}
+		return
+	}
+
+	w.eventWindows = append(w.eventWindows, window)
+}
+
+// TriggerEvent marks that an event happened in the camera, e.g. motion
+// detected or triggered by external system. When the camera's schedule
+// is in event mode, the recorder will save the segments around it.
+func (h *WebHandler) TriggerEvent(camID string, source string) error {
+	if h.recorder == nil {
+		return fmt.Errorf("recorder is not started")
+	}
+
+	h.recorder.Lock()
+	worker, exist := h.recorder.workers[camID]
+	h.recorder.Unlock()
+
+	if !exist {
+		return fmt.Errorf("camera %s is not recorded", camID)
+	}
+
+	worker.addEvent(h.recorder.clock())
+
+	logrus.Infof("event from %s triggered in camera %s\n", source, camID)
+	return nil
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNearOverlappingEvents(t *testing.T) {
+	clock := &fakeClock{}
+	worker := &recordWorker{schedule: Schedule{DefaultMode: scheduleEvent, PreEvent: 10, PostEvent: 30}}
+	h := &WebHandler{recorder: &recorder{
+		workers:     map[string]*recordWorker{"1": worker},
+		cameraLocks: make(map[string]*sync.Mutex),
+		clock:       clock.Now,
+	}}
+
+	at := func(minute, second int) time.Time {
+		return time.Date(2026, 10, 19, 9, minute, second, 0, time.Local)
+	}
+
+	// The second event overlaps the post-event of the first one, while the third one
+	// is far after them. Windows are 08:59:50-09:00:55 and 09:04:50-09:05:30.
+	for _, eventTime := range []time.Time{at(0, 0), at(0, 25), at(5, 0)} {
+		clock.Set(eventTime)
+		if err := h.TriggerEvent("1", "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		start time.Time
+		near  bool
+	}{
+		{at(-1, 45), false},
+		{at(-1, 49), true},
+		{at(0, 5), true},
+		{at(0, 50), true},
+		{at(0, 55), false},
+		{at(3, 0), false},
+		{at(4, 49), true},
+		{at(5, 29), true},
+		{at(5, 30), false},
+	}
+
+	for _, tt := range tests {
+		if near := worker.nearEvent(tt.start, 2*time.Second); near != tt.near {
+			t.Errorf("segment at %s: got near event %t, want %t", tt.start.Format("15:04:05"), near, tt.near)
+		}
+	}
+
+	if n := len(worker.eventWindows); n != 2 {
+		t.Errorf("got %d event windows, want 2", n)
+	}
+}
@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Interval for checking the schedule while recording frames
+	frameScheduleInterval = 5 * time.Second
+
+	// Maximum size of frames that kept in buffer while in event mode. Camera
+	// with high frame rate may reach it before the pre-event duration, in that
+	// case the oldest frames are dropped.
+	maxFrameBufferSize = 32 * 1024 * 1024
+)
+
+// frameVideo is Motion-JPEG AVI file that currently written by recorder.
+type frameVideo struct {
+	file      *os.File
+	writer    *remux.AVIWriter
+	path      string
+	startTime time.Time
+}
+
+// recordFrames records camera that provides its stream as JPEG frames, e.g. MJPEG
+// camera. The frames are saved as Motion-JPEG AVI file, which rolled each time
+// its duration reached the video duration. Unlike HLS camera, the frames are not
+// indexed, so they are only available in the list of recorded videos, and not in
+// timeline, VOD playlist and export. See checkIndexedCamera.
+func (h *WebHandler) recordFrames(cam Camera, dstDir string, schedule Schedule, worker *recordWorker) {
+	buffer := []bufferedSegment{}
+	bufferSize := 0
+	online := true
+	paused := false
+
+	var video *frameVideo
+
+	closeVideo := func() {
+		if video == nil {
+			return
+		}
+
+		err := closeFrameVideo(video)
+		if err != nil {
+			logrus.Warnf("failed to create video for camera %s: %v\n", cam.ID, err)
+		}
+		video = nil
+	}
+	defer closeVideo()
+
+	// writeFrame writes frame into the current video, or into the new one when
+	// there are no video yet or the current video is already long enough.
+	writeFrame := func(frame []byte, frameTime time.Time) error {
+		if video != nil && frameTime.Sub(video.startTime) >= h.VideoDuration {
+			closeVideo()
+		}
+
+		if video == nil {
+			newVideo, err := createFrameVideo(dstDir, frameTime)
+			if err != nil {
+				return err
+			}
+			video = newVideo
+		}
+
+		err := video.writer.WriteFrame(frame, frameTime.Sub(video.startTime))
+		if err != nil {
+			return fmt.Errorf("failed to write frame: %v", err)
+		}
+
+		return nil
+	}
+
+	// setOnline logs only when camera status changed, to prevent flooding the log
+	setOnline := func(err error) {
+		if err != nil && online {
+			logrus.Warnf("recorder for camera %s stalled: %v\n", cam.ID, err)
+			h.logRecorderEvent(cam.ID, eventCameraOffline)
+		} else if err == nil && !online {
+			logrus.Infof("recorder for camera %s resumed\n", cam.ID)
+			h.logRecorderEvent(cam.ID, eventCameraOnline)
+		}
+		online = err == nil
+	}
+
+	for {
+		select {
+		case <-worker.stop:
+			return
+		default:
+		}
+
+		// Follow the schedule, the same way as HLS camera
+		mode := schedule.modeAt(h.recorder.clock())
+		scheduled := mode == scheduleContinuous
+		if scheduled && paused {
+			logrus.Infof("recorder for camera %s resumed by schedule\n", cam.ID)
+			h.logRecorderEvent(cam.ID, eventScheduleResumed)
+		} else if !scheduled && !paused {
+			logrus.Infof("recorder for camera %s paused by schedule\n", cam.ID)
+			h.logRecorderEvent(cam.ID, eventSchedulePaused)
+			closeVideo()
+		}
+		paused = !scheduled
+
+		if mode == scheduleOff {
+			buffer = nil
+			bufferSize = 0
+			select {
+			case <-worker.stop:
+				return
+			case <-time.After(frameScheduleInterval):
+				continue
+			}
+		}
+
+		err := func() error {
+			// Make sure storage directory exists
+			err := os.MkdirAll(dstDir, os.ModePerm)
+			if err != nil {
+				return fmt.Errorf("failed to create storage dir: %v", err)
+			}
+
+			// Subscribe to the camera's frames
+			source, err := h.openCameraSource(cam)
+			if err != nil {
+				return err
+			}
+
+			frames, isFrameSource := source.(frameSource)
+			if !isFrameSource {
+				return fmt.Errorf("camera %s doesn't serve JPEG frames", cam.ID)
+			}
+
+			chFrame, unsubscribe := frames.subscribe()
+			defer unsubscribe()
+
+			ticker := time.NewTicker(frameScheduleInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-worker.stop:
+					return nil
+
+				// Return to the main loop when schedule changed
+				case <-ticker.C:
+					if schedule.modeAt(h.recorder.clock()) != mode {
+						return nil
+					}
+
+				case frame, ok := <-chFrame:
+					if !ok {
+						h.releaseCameraSource(cam.ID, source)
+						return fmt.Errorf("stream of camera %s is ended", cam.ID)
+					}
+					setOnline(nil)
+
+					// In event mode, frame that not around any event is kept in
+					// buffer, in case there is an event that happened shortly after.
+					frameTime := h.recorder.clock()
+					if mode == scheduleEvent && !worker.nearEvent(frameTime, 0) {
+						closeVideo()
+						buffer = append(buffer, bufferedSegment{StartTime: frameTime, Data: frame})
+						bufferSize += len(frame)
+
+						// Drop frames outside pre-event duration, or the oldest
+						// ones when the buffer is too large
+						limit := frameTime.Add(-schedule.preEventDuration())
+						for len(buffer) > 0 && (buffer[0].StartTime.Before(limit) || bufferSize > maxFrameBufferSize) {
+							bufferSize -= len(buffer[0].Data)
+							buffer = buffer[1:]
+						}
+						continue
+					}
+
+					// Flush the buffered frames that captured before the event
+					for _, buffered := range buffer {
+						if worker.nearEvent(buffered.StartTime, 0) {
+							if err = writeFrame(buffered.Data, buffered.StartTime); err != nil {
+								return err
+							}
+						}
+					}
+					buffer = buffer[:0]
+					bufferSize = 0
+
+					if err = writeFrame(frame, frameTime); err != nil {
+						return err
+					}
+				}
+			}
+		}()
+
+		if err == nil {
+			continue
+		}
+
+		setOnline(err)
+		closeVideo()
+
+		// Wait before reconnecting to camera
+		select {
+		case <-worker.stop:
+			return
+		case <-time.After(frameScheduleInterval):
+		}
+	}
+}
+
+// checkIndexedCamera makes sure the recording of camera is indexed, which is
+// required by timeline, VOD playlist and export. It returns error for frame
+// camera, whose recording is only available in the list of recorded videos.
+func checkIndexedCamera(cam Camera) error {
+	if isFrameCamera(cam) {
+		return fmt.Errorf("camera %s is recorded as JPEG frames, so its recording "+
+			"is only available in the list of recorded videos", cam.ID)
+	}
+
+	return nil
+}
+
+// createFrameVideo creates temporary AVI file in destination directory. Once
+// closed, it will be renamed following the time of its first frame.
+func createFrameVideo(dstDir string, startTime time.Time) (*frameVideo, error) {
+	fileName := startTime.Format(videoTimeFormat) + ".avi"
+	dstPath := fp.Join(dstDir, fileName)
+
+	file, err := os.Create(dstPath + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create video file: %v", err)
+	}
+
+	video := &frameVideo{
+		file:      file,
+		writer:    remux.NewAVIWriter(file),
+		path:      dstPath,
+		startTime: startTime,
+	}
+
+	return video, nil
+}
+
+// closeFrameVideo finalizes the AVI file, then renames it to the actual name.
+func closeFrameVideo(video *frameVideo) error {
+	tmpPath := video.path + ".tmp"
+	err := video.writer.Close()
+	video.file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, video.path)
+}
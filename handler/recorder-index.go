@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	fp "path/filepath"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Events that logged by recorder, used to find cause of gaps in recording
+const (
+	eventRecorderStarted = "recorder-started"
+	eventRecorderStopped = "recorder-stopped"
+	eventCameraOffline   = "camera-offline"
+	eventCameraOnline    = "camera-online"
+	eventSchedulePaused  = "schedule-paused"
+	eventScheduleResumed = "schedule-resumed"
+)
+
+// Maximum duration of a segment, used when looking for
+// segments that overlap with a time range.
+const maxSegmentDuration = time.Minute
+
+// segmentIndex is entry in segment index, i.e. metadata of a saved segment.
+type segmentIndex struct {
+	File     string        `json:"file"`
+	Duration time.Duration `json:"duration"`
+	Size     int64         `json:"size"`
+}
+
+// recorderEvent is a change in recorder state
+type recorderEvent struct {
+	Time  time.Time
+	Event string
+}
+
+// timeKey converts time into key for bbolt, which sorted by time.
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
+
+// cameraSubBucket returns sub bucket for the camera inside the specified
+// bucket. If tx is writable, the buckets will be created if not exist yet.
+func cameraSubBucket(tx *bolt.Tx, bucketName string, camID string) *bolt.Bucket {
+	if tx.Writable() {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		if err != nil {
+			return nil
+		}
+
+		camBucket, err := bucket.CreateBucketIfNotExists([]byte(camID))
+		if err != nil {
+			return nil
+		}
+
+		return camBucket
+	}
+
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return nil
+	}
+
+	return bucket.Bucket([]byte(camID))
+}
+
+func (h *WebHandler) indexSegment(camID string, startTime time.Time, index segmentIndex) error {
+	return h.DB.Update(func(tx *bolt.Tx) error {
+		bucket := cameraSubBucket(tx, "segment", camID)
+		if bucket == nil {
+			return nil
+		}
+
+		val, err := json.Marshal(&index)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(timeKey(startTime), val)
+	})
+}
+
+// deleteSegmentIndex removes the segment file from index. Segment is indexed by its
+// start time in milliseconds, which parsed from its file name. However, the older
+// index uses start time in nanosecond, so look for the file within that millisecond.
+func (h *WebHandler) deleteSegmentIndex(camID string, startTime time.Time, fileName string) error {
+	return h.DB.Update(func(tx *bolt.Tx) error {
+		bucket := cameraSubBucket(tx, "segment", camID)
+		if bucket == nil {
+			return nil
+		}
+
+		// Collect the keys first, since deleting while iterating skips entries
+		keys := [][]byte{}
+		end := startTime.Add(time.Millisecond)
+		c := bucket.Cursor()
+		for k, v := c.Seek(timeKey(startTime)); k != nil && keyTime(k).Before(end); k, v = c.Next() {
+			var index segmentIndex
+			if json.Unmarshal(v, &index) == nil && index.File == fileName {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// getIndexedSegments returns segments in index that overlap with the specified time range.
+func (h *WebHandler) getIndexedSegments(camID string, start, end time.Time) ([]recordedSegment, error) {
+	cameraDir, err := h.cameraStorageDir(camID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index uses Unix time as key, so never look before it
+	seekTime := start.Add(-maxSegmentDuration)
+	if seekTime.Before(time.Unix(0, 0)) {
+		seekTime = time.Unix(0, 0)
+	}
+
+	segments := []recordedSegment{}
+	err = h.DB.View(func(tx *bolt.Tx) error {
+		bucket := cameraSubBucket(tx, "segment", camID)
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.Seek(timeKey(seekTime)); k != nil; k, v = c.Next() {
+			segmentTime := keyTime(k)
+			if !segmentTime.Before(end) {
+				break
+			}
+
+			var index segmentIndex
+			if err := json.Unmarshal(v, &index); err != nil {
+				continue
+			}
+
+			if !segmentTime.Add(index.Duration).After(start) {
+				continue
+			}
+
+			segments = append(segments, recordedSegment{
+				recordingFile: recordingFile{
+					Path: fp.Join(cameraDir, index.File),
+					Name: index.File,
+					Time: segmentTime,
+					Size: index.Size,
+				},
+				Duration: index.Duration,
+			})
+		}
+
+		return nil
+	})
+
+	return segments, err
+}
+
+// indexExistingSegments adds the saved segments that not indexed yet into
+// index, e.g. segments that saved before segment index exists.
+func (h *WebHandler) indexExistingSegments() {
+	for _, camID := range h.listStorageCameras() {
+		files, err := h.listRecordings(camID)
+		if err != nil {
+			continue
+		}
+
+		// Find segments that not indexed yet. They are looked up by file name
+		// instead of key, since the older index uses start time in nanosecond.
+		unindexed := []recordingFile{}
+		h.DB.View(func(tx *bolt.Tx) error {
+			indexed := make(map[string]struct{})
+			if bucket := cameraSubBucket(tx, "segment", camID); bucket != nil {
+				bucket.ForEach(func(k, v []byte) error {
+					var index segmentIndex
+					if json.Unmarshal(v, &index) == nil {
+						indexed[index.File] = struct{}{}
+					}
+					return nil
+				})
+			}
+
+			for _, file := range files {
+				if !rxSavedSegment.MatchString(file.Name) {
+					continue
+				}
+
+				if _, exist := indexed[file.Name]; !exist {
+					unindexed = append(unindexed, file)
+				}
+			}
+			return nil
+		})
+
+		if len(unindexed) == 0 {
+			continue
+		}
+
+		// Probe their duration, then save them to index
+		for _, file := range unindexed {
+			duration, err := probeSegmentDuration(file.Path)
+			if err != nil {
+				continue
+			}
+
+			h.indexSegment(camID, file.Time, segmentIndex{
+				File:     file.Name,
+				Duration: duration,
+				Size:     file.Size,
+			})
+		}
+
+		logrus.Infof("indexed %d segments of camera %s\n", len(unindexed), camID)
+	}
+}
+
+func probeSegmentDuration(segmentPath string) (time.Duration, error) {
+	src, err := os.Open(segmentPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	return remux.ProbeDuration(src)
+}
+
+func (h *WebHandler) logRecorderEvent(camID string, event string) {
+	h.DB.Update(func(tx *bolt.Tx) error {
+		bucket := cameraSubBucket(tx, "recorder-event", camID)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Put(timeKey(time.Now()), []byte(event))
+	})
+}
+
+// getRecorderEvents returns recorder events within the specified time range,
+// plus the last event before the range so the state at start is known.
+func (h *WebHandler) getRecorderEvents(camID string, start, end time.Time) []recorderEvent {
+	events := []recorderEvent{}
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := cameraSubBucket(tx, "recorder-event", camID)
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		k, _ := c.Seek(timeKey(start))
+
+		var prevK, prevV []byte
+		if k == nil {
+			prevK, prevV = c.Last()
+		} else {
+			prevK, prevV = c.Prev()
+		}
+
+		if prevK != nil {
+			events = append(events, recorderEvent{Time: keyTime(prevK), Event: string(prevV)})
+		}
+
+		for k, v := c.Seek(timeKey(start)); k != nil; k, v = c.Next() {
+			eventTime := keyTime(k)
+			if eventTime.After(end) {
+				break
+			}
+
+			events = append(events, recorderEvent{Time: eventTime, Event: string(v)})
+		}
+
+		return nil
+	})
+
+	return events
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// countIndexedSegments returns count of entries in the camera's segment index.
+func countIndexedSegments(h *WebHandler, camID string) int {
+	count := 0
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := cameraSubBucket(tx, "segment", camID)
+		if bucket != nil {
+			count = bucket.Stats().KeyN
+		}
+		return nil
+	})
+	return count
+}
+
+func TestRecorderRestartIndex(t *testing.T) {
+	camera := &fakeCamera{}
+	server := httptest.NewServer(camera)
+	defer server.Close()
+
+	// Clock is not in whole millisecond, while segment file is named in millisecond
+	h, clock, cleanup := newTestRecorder(t, server.URL, Schedule{DefaultMode: scheduleContinuous},
+		time.Date(2026, 10, 19, 9, 0, 10, 123456789, time.Local))
+	defer cleanup()
+
+	camera.Publish()
+	h.restartCameraRecorder("1")
+	waitFor(t, "segment 1 saved", func() bool { return countSavedSegments(h, "1") == 1 })
+
+	clock.Set(time.Date(2026, 10, 19, 9, 0, 11, 987654321, time.Local))
+	camera.Publish()
+	waitFor(t, "segment 2 saved", func() bool { return countSavedSegments(h, "1") == 2 })
+
+	// On startup, the saved segments must be found in index
+	// instead of being indexed once again.
+	h.stopCameraRecorder("1")
+	h.indexExistingSegments()
+	if n := countIndexedSegments(h, "1"); n != 2 {
+		t.Fatalf("got %d indexed segments after restart, want 2", n)
+	}
+
+	// Restarted worker saves the cached segments again, which must replace their entries
+	h.restartCameraRecorder("1")
+	clock.Set(time.Date(2026, 10, 19, 9, 0, 13, 555555555, time.Local))
+	camera.Publish()
+	waitFor(t, "segment 3 saved", func() bool { return countSavedSegments(h, "1") == 3 })
+
+	h.stopCameraRecorder("1")
+	h.indexExistingSegments()
+	if n := countIndexedSegments(h, "1"); n != 3 {
+		t.Fatalf("got %d indexed segments after second restart, want 3", n)
+	}
+
+	segments, err := h.getIndexedSegments("1", time.Unix(0, 0), clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, segment := range segments {
+		if segment.Time.Format(segmentTimeFormat)+".ts" != segment.Name {
+			t.Errorf("segment %s is indexed at %s", segment.Name, segment.Time.Format(time.RFC3339Nano))
+		}
+	}
+}
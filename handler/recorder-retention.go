@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	retentionInterval = time.Minute
+	maxDeletionLogs   = 100
+)
+
+// retention keeps the recent deletions that done by retention engine.
+type retention struct {
+	sync.RWMutex
+	deletions []DeletedRecording
+}
+
+// StartRetention starts retention engine which periodically deletes
+// old recordings, following the retention setting of each camera and
+// the minimum free space of storage.
+func (h *WebHandler) StartRetention() {
+	h.retention = &retention{}
+
+	go func() {
+		for {
+			h.applyRetention()
+			time.Sleep(retentionInterval)
+		}
+	}()
+}
+
+func (h *WebHandler) applyRetention() {
+	now := time.Now()
+	allFiles := []recordingFile{}
+	fileCameras := make(map[string]string)
+
+	for _, camID := range h.listStorageCameras() {
+		files, err := h.listRecordings(camID)
+		if err != nil {
+			logrus.Warnf("retention: failed to list recordings of camera %s: %v\n", camID, err)
+			continue
+		}
+
+		// Delete recordings that older than max age
+		setting := h.getRetentionSetting(camID)
+		if setting.MaxAge > 0 {
+			limit := now.Add(-time.Duration(setting.MaxAge) * time.Hour)
+			for len(files) > 0 && files[0].Time.Before(limit) {
+				h.deleteRecording(camID, files[0], "max-age")
+				files = files[1:]
+			}
+		}
+
+		// Delete the oldest recordings until total size below the limit
+		if setting.MaxSize > 0 {
+			var totalSize int64
+			for _, file := range files {
+				totalSize += file.Size
+			}
+
+			for len(files) > 0 && totalSize > setting.MaxSize {
+				h.deleteRecording(camID, files[0], "max-size")
+				totalSize -= files[0].Size
+				files = files[1:]
+			}
+		}
+
+		for _, file := range files {
+			fileCameras[file.Path] = camID
+		}
+		allFiles = append(allFiles, files...)
+	}
+
+	// Make sure free space in storage is above the minimum
+	minFreeSpace := h.getMinFreeSpace()
+	if minFreeSpace <= 0 {
+		return
+	}
+
+	freeSpace, err := diskFreeSpace(h.StorageDir)
+	if err != nil {
+		logrus.Warnln("retention: failed to check free space:", err)
+		return
+	}
+
+	sort.Slice(allFiles, func(i, j int) bool {
+		return allFiles[i].Time.Before(allFiles[j].Time)
+	})
+
+	for _, file := range allFiles {
+		if freeSpace >= minFreeSpace {
+			break
+		}
+
+		h.deleteRecording(fileCameras[file.Path], file, "min-free-space")
+		freeSpace += file.Size
+	}
+}
+
+func (h *WebHandler) deleteRecording(camID string, file recordingFile, reason string) {
+	err := os.Remove(file.Path)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("retention: failed to delete %s of camera %s: %v\n", file.Name, camID, err)
+		return
+	}
+
+	if rxSavedSegment.MatchString(file.Name) {
+		h.deleteSegmentIndex(camID, file.Time, file.Name)
+	}
+
+	logrus.Infof("retention: deleted %s of camera %s (%s)\n", file.Name, camID, reason)
+
+	h.retention.Lock()
+	h.retention.deletions = append(h.retention.deletions, DeletedRecording{
+		Time:     time.Now(),
+		CameraID: camID,
+		File:     file.Name,
+		Size:     file.Size,
+		Reason:   reason,
+	})
+
+	if n := len(h.retention.deletions); n > maxDeletionLogs {
+		h.retention.deletions = h.retention.deletions[n-maxDeletionLogs:]
+	}
+	h.retention.Unlock()
+}
+
+func (h *WebHandler) getDeletedRecordings() []DeletedRecording {
+	deletions := []DeletedRecording{}
+	if h.retention == nil {
+		return deletions
+	}
+
+	h.retention.RLock()
+	deletions = append(deletions, h.retention.deletions...)
+	h.retention.RUnlock()
+
+	return deletions
+}
+
+func (h *WebHandler) getRetentionSetting(camID string) RetentionSetting {
+	setting := RetentionSetting{}
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("camera"))
+		if bucket == nil {
+			return nil
+		}
+
+		cameraBucket := bucket.Bucket([]byte(camID))
+		if cameraBucket == nil {
+			return nil
+		}
+
+		setting.MaxAge, _ = strconv.Atoi(string(cameraBucket.Get([]byte("max-age"))))
+		setting.MaxSize, _ = strconv.ParseInt(string(cameraBucket.Get([]byte("max-size"))), 10, 64)
+		return nil
+	})
+
+	return setting
+}
+
+func (h *WebHandler) getMinFreeSpace() int64 {
+	var minFreeSpace int64
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("setting"))
+		if bucket == nil {
+			return nil
+		}
+
+		minFreeSpace, _ = strconv.ParseInt(string(bucket.Get([]byte("min-free-space"))), 10, 64)
+		return nil
+	})
+
+	return minFreeSpace
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRetentionDeleteIndex(t *testing.T) {
+	camera := &fakeCamera{}
+	server := httptest.NewServer(camera)
+	defer server.Close()
+
+	h, clock, cleanup := newTestRecorder(t, server.URL, Schedule{DefaultMode: scheduleContinuous},
+		time.Date(2026, 10, 19, 9, 0, 10, 123456789, time.Local))
+	defer cleanup()
+
+	camera.Publish()
+	h.restartCameraRecorder("1")
+	waitFor(t, "segment 1 saved", func() bool { return countSavedSegments(h, "1") == 1 })
+
+	clock.Set(time.Date(2026, 10, 19, 9, 0, 11, 987654321, time.Local))
+	camera.Publish()
+	waitFor(t, "segment 2 saved", func() bool { return countSavedSegments(h, "1") == 2 })
+	h.stopCameraRecorder("1")
+
+	// Limit the size so only the oldest segment is deleted
+	maxSize := int64(len(fakeSegment)) * 3 / 2
+	err := h.DB.Update(func(tx *bolt.Tx) error {
+		cameraBucket := tx.Bucket([]byte("camera")).Bucket([]byte("1"))
+		return cameraBucket.Put([]byte("max-size"), []byte(strconv.FormatInt(maxSize, 10)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := h.listRecordings("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.retention = &retention{}
+	h.applyRetention()
+
+	if n := countSavedSegments(h, "1"); n != 1 {
+		t.Fatalf("got %d segments after retention, want 1", n)
+	}
+
+	// The deleted segment must be removed from index as well
+	segments, err := h.getIndexedSegments("1", time.Unix(0, 0), clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countIndexedSegments(h, "1"); n != 1 || len(segments) != 1 {
+		t.Fatalf("got %d indexed segments after retention, want 1", n)
+	}
+
+	if segments[0].Name != files[1].Name {
+		t.Errorf("got indexed segment %s, want %s", segments[0].Name, files[1].Name)
+	}
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Recording mode in schedule
+const (
+	scheduleContinuous = "continuous"
+	scheduleOff        = "off"
+	scheduleEvent      = "event"
+)
+
+// defaultSchedule is used for camera that doesn't have schedule,
+// which makes the camera recorded all the time.
+var defaultSchedule = Schedule{
+	DefaultMode: scheduleContinuous,
+	Ranges:      []ScheduleRange{},
+	PreEvent:    defaultPreEvent,
+	PostEvent:   defaultPostEvent,
+}
+
+// modeAt returns recording mode at the specified time. If there are
+// several ranges that contains the time, the first one is used.
+func (s Schedule) modeAt(t time.Time) string {
+	weekday := int(t.Weekday())
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, rg := range s.Ranges {
+		if rg.Weekday != weekday {
+			continue
+		}
+
+		start, _ := parseScheduleClock(rg.Start)
+		end, _ := parseScheduleClock(rg.End)
+		if minute >= start && minute < end {
+			return rg.Mode
+		}
+	}
+
+	return s.DefaultMode
+}
+
+func (s Schedule) validate() error {
+	if !validScheduleMode(s.DefaultMode) {
+		return fmt.Errorf("mode %s is not valid", s.DefaultMode)
+	}
+
+	if s.PreEvent < 0 || s.PreEvent > maxPreEvent {
+		return fmt.Errorf("pre-event must be between 0 and %d seconds", maxPreEvent)
+	}
+
+	if s.PostEvent < 0 {
+		return fmt.Errorf("post-event must not be negative")
+	}
+
+	for _, rg := range s.Ranges {
+		if !validScheduleMode(rg.Mode) {
+			return fmt.Errorf("mode %s is not valid", rg.Mode)
+		}
+
+		if rg.Weekday < 0 || rg.Weekday > 6 {
+			return fmt.Errorf("weekday %d is not valid", rg.Weekday)
+		}
+
+		start, err := parseScheduleClock(rg.Start)
+		if err != nil {
+			return err
+		}
+
+		end, err := parseScheduleClock(rg.End)
+		if err != nil {
+			return err
+		}
+
+		if start >= end {
+			return fmt.Errorf("start time %s must be before end time %s", rg.Start, rg.End)
+		}
+	}
+
+	return nil
+}
+
+func validScheduleMode(mode string) bool {
+	switch mode {
+	case scheduleContinuous, scheduleOff, scheduleEvent:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseScheduleClock converts HH:MM into minutes since midnight.
+// To mark the end of a day, 24:00 is allowed.
+func parseScheduleClock(clock string) (int, error) {
+	var hour, minute int
+	_, err := fmt.Sscanf(clock, "%d:%d", &hour, &minute)
+	if err != nil || hour < 0 || minute < 0 || minute > 59 ||
+		hour > 24 || (hour == 24 && minute > 0) {
+		return 0, fmt.Errorf("time %s is not valid", clock)
+	}
+
+	return hour*60 + minute, nil
+}
+
+func (h *WebHandler) getCameraSchedule(camID string) (Schedule, error) {
+	schedule := defaultSchedule
+	err := h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("camera"))
+		if bucket == nil {
+			return fmt.Errorf("camera %s doesn't exist", camID)
+		}
+
+		cameraBucket := bucket.Bucket([]byte(camID))
+		if cameraBucket == nil {
+			return fmt.Errorf("camera %s doesn't exist", camID)
+		}
+
+		val := cameraBucket.Get([]byte("schedule"))
+		if val == nil {
+			return nil
+		}
+
+		return json.Unmarshal(val, &schedule)
+	})
+
+	return schedule, err
+}
+
+// saveCameraSchedule saves schedule of the camera. If schedule is nil,
+// the saved schedule is removed so camera will be recorded all the time.
+func (h *WebHandler) saveCameraSchedule(camID string, schedule *Schedule) error {
+	return h.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("camera"))
+		if bucket == nil {
+			return fmt.Errorf("camera %s doesn't exist", camID)
+		}
+
+		cameraBucket := bucket.Bucket([]byte(camID))
+		if cameraBucket == nil {
+			return fmt.Errorf("camera %s doesn't exist", camID)
+		}
+
+		if schedule == nil {
+			return cameraBucket.Delete([]byte("schedule"))
+		}
+
+		val, err := json.Marshal(schedule)
+		if err != nil {
+			return err
+		}
+
+		return cameraBucket.Put([]byte("schedule"), val)
+	})
+}
@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+	cch "github.com/patrickmn/go-cache"
+	bolt "go.etcd.io/bbolt"
+)
+
+// fakeClock is clock that only moves when it's set, so the schedule
+// transitions can be simulated without waiting for the real time.
+type fakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(t time.Time) {
+	c.Lock()
+	c.now = t
+	c.Unlock()
+}
+
+// fakeSegment is one second of 30 FPS H.264 video in MPEG-TS, so its duration can be
+// probed when the recorder indexes existing segments and it can be remuxed into MP4.
+var fakeSegment = func() []byte {
+	sps := []byte{0x67, 0x42, 0xC0, 0x1E, 0xDA, 0x02, 0x80, 0xBF, 0xE5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xF0, 0x3C, 0x58, 0xBA, 0x80}
+	pps := []byte{0x68, 0xCE, 0x0F, 0xC8}
+
+	buffer := &bytes.Buffer{}
+	writer := remux.NewTSWriter(buffer, remux.H264)
+	for i := 0; i < 30; i++ {
+		au := []byte{0, 0, 0, 1, 0x09, 0xF0}
+		if i == 0 {
+			au = append(append(au, 0, 0, 0, 1), sps...)
+			au = append(append(au, 0, 0, 0, 1), pps...)
+			au = append(au, 0, 0, 0, 1, 0x65, byte(i))
+		} else {
+			au = append(au, 0, 0, 0, 1, 0x41, byte(i))
+		}
+
+		writer.WritePacket(&remux.Packet{
+			Codec:    remux.H264,
+			PTS:      int64(i * 3000),
+			DTS:      int64(i * 3000),
+			Data:     au,
+			Keyframe: i == 0,
+		})
+	}
+	return buffer.Bytes()
+}()
+
+// fakeCamera is Cygnus camera that serves live playlist of the
+// segments that published by test, one second for each segment.
+type fakeCamera struct {
+	sync.Mutex
+	segments int
+}
+
+func (c *fakeCamera) Publish() {
+	c.Lock()
+	c.segments++
+	c.Unlock()
+}
+
+func (c *fakeCamera) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	segments := c.segments
+	c.Unlock()
+
+	switch {
+	case r.URL.Path == "/api/login":
+		fmt.Fprint(w, "session")
+	case r.URL.Path == "/live/playlist":
+		first := segments - 3
+		if first < 0 {
+			first = 0
+		}
+
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n")
+		fmt.Fprintf(w, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+		for i := first; i < segments; i++ {
+			fmt.Fprintf(w, "#EXTINF:1.0,\n/live/stream/%d\n", i)
+		}
+	case strings.HasPrefix(r.URL.Path, "/live/stream/"):
+		w.Write(fakeSegment)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// waitFor waits until condition is true, or fails the test after timeout.
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+
+	timeout := time.After(10 * time.Second)
+	for !condition() {
+		select {
+		case <-timeout:
+			t.Fatalf("timeout while waiting for %s", description)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestScheduleModeAt(t *testing.T) {
+	schedule := Schedule{
+		DefaultMode: scheduleEvent,
+		Ranges: []ScheduleRange{
+			{Weekday: 1, Start: "09:00", End: "17:00", Mode: scheduleContinuous},
+			{Weekday: 1, Start: "12:00", End: "13:00", Mode: scheduleOff},
+			{Weekday: 0, Start: "00:00", End: "24:00", Mode: scheduleOff},
+		},
+	}
+
+	// 19 October 2026 is Monday
+	tests := []struct {
+		time time.Time
+		mode string
+	}{
+		{time.Date(2026, 10, 19, 8, 59, 59, 0, time.Local), scheduleEvent},
+		{time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local), scheduleContinuous},
+		{time.Date(2026, 10, 19, 12, 30, 0, 0, time.Local), scheduleContinuous},
+		{time.Date(2026, 10, 19, 16, 59, 59, 0, time.Local), scheduleContinuous},
+		{time.Date(2026, 10, 19, 17, 0, 0, 0, time.Local), scheduleEvent},
+		{time.Date(2026, 10, 20, 9, 30, 0, 0, time.Local), scheduleEvent},
+		{time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), scheduleOff},
+		{time.Date(2026, 10, 18, 23, 59, 59, 0, time.Local), scheduleOff},
+	}
+
+	for _, tt := range tests {
+		if mode := schedule.modeAt(tt.time); mode != tt.mode {
+			t.Errorf("mode at %s: got %s, want %s", tt.time.Format(time.RFC1123), mode, tt.mode)
+		}
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		valid    bool
+	}{
+		{"default", defaultSchedule, true},
+		{"whole day", Schedule{DefaultMode: scheduleOff, Ranges: []ScheduleRange{{Weekday: 6, Start: "00:00", End: "24:00", Mode: scheduleEvent}}}, true},
+		{"unknown mode", Schedule{DefaultMode: "sometimes"}, false},
+		{"unknown range mode", Schedule{DefaultMode: scheduleOff, Ranges: []ScheduleRange{{Weekday: 1, Start: "09:00", End: "10:00", Mode: "sometimes"}}}, false},
+		{"invalid weekday", Schedule{DefaultMode: scheduleOff, Ranges: []ScheduleRange{{Weekday: 7, Start: "09:00", End: "10:00", Mode: scheduleEvent}}}, false},
+		{"invalid time", Schedule{DefaultMode: scheduleOff, Ranges: []ScheduleRange{{Weekday: 1, Start: "09:60", End: "10:00", Mode: scheduleEvent}}}, false},
+		{"past midnight", Schedule{DefaultMode: scheduleOff, Ranges: []ScheduleRange{{Weekday: 1, Start: "09:00", End: "24:01", Mode: scheduleEvent}}}, false},
+		{"reversed range", Schedule{DefaultMode: scheduleOff, Ranges: []ScheduleRange{{Weekday: 1, Start: "10:00", End: "09:00", Mode: scheduleEvent}}}, false},
+		{"too long pre-event", Schedule{DefaultMode: scheduleOff, PreEvent: maxPreEvent + 1}, false},
+		{"negative post-event", Schedule{DefaultMode: scheduleOff, PostEvent: -1}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.schedule.validate()
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("%s: got error %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
+
+// newTestRecorder prepares handler that records camera 1 from the specified URL
+// following the schedule. Both live hub and recorder use the returned clock.
+func newTestRecorder(t *testing.T, cameraURL string, schedule Schedule, now time.Time) (*WebHandler, *fakeClock, func()) {
+	dir, err := ioutil.TempDir("", "cygnus-nvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(fp.Join(dir, "cygnus.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	h := &WebHandler{
+		DB:          db,
+		StorageDir:  fp.Join(dir, "recording"),
+		CameraCache: cch.New(time.Hour, time.Minute),
+		VideoCache:  cch.New(time.Hour, time.Minute),
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("camera"))
+		if err != nil {
+			return err
+		}
+
+		cameraBucket, err := bucket.CreateBucketIfNotExists([]byte("1"))
+		if err != nil {
+			return err
+		}
+
+		return cameraBucket.Put([]byte("url"), []byte(cameraURL))
+	})
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	err = h.saveCameraSchedule("1", &schedule)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{now: now}
+	h.StartLiveHub()
+	h.liveHub.clock = clock.Now
+	h.recorder = &recorder{
+		workers:     make(map[string]*recordWorker),
+		cameraLocks: make(map[string]*sync.Mutex),
+		clock:       clock.Now,
+	}
+
+	return h, clock, func() {
+		h.StopRecorder()
+		h.stopLiveFeed("1")
+		cleanup()
+	}
+}
+
+// countSavedSegments returns count of segment files in the camera's storage directory.
+func countSavedSegments(h *WebHandler, camID string) int {
+	files, _ := ioutil.ReadDir(fp.Join(h.StorageDir, camID))
+	count := 0
+	for _, file := range files {
+		if fp.Ext(file.Name()) == ".ts" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRecorderScheduleTransition(t *testing.T) {
+	camera := &fakeCamera{}
+	server := httptest.NewServer(camera)
+	defer server.Close()
+
+	// On Monday, camera is recorded continuously from 09:00 until 09:01,
+	// and only around the events for the rest of the time. Live feed is
+	// stopped when it's idle for a minute, so the clock is never moved
+	// more than that in a single step.
+	h, clock, cleanup := newTestRecorder(t, server.URL, Schedule{
+		DefaultMode: scheduleEvent,
+		Ranges:      []ScheduleRange{{Weekday: 1, Start: "09:00", End: "09:01", Mode: scheduleContinuous}},
+		PreEvent:    10,
+		PostEvent:   60,
+	}, time.Date(2026, 10, 19, 8, 59, 20, 0, time.Local))
+	defer cleanup()
+
+	countEvents := func(event string) int {
+		count := 0
+		for _, e := range h.getRecorderEvents("1", time.Unix(0, 0), time.Now().Add(time.Hour)) {
+			if e.Event == event {
+				count++
+			}
+		}
+		return count
+	}
+
+	countSegments := func() int {
+		return countSavedSegments(h, "1")
+	}
+
+	// Before 09:00, segment is only buffered in case there is an event
+	camera.Publish()
+	h.restartCameraRecorder("1")
+	waitFor(t, "recorder paused", func() bool { return countEvents(eventSchedulePaused) == 1 })
+
+	// Since 09:00, all segments are saved
+	for i, second := range []int{10, 50} {
+		clock.Set(time.Date(2026, 10, 19, 9, 0, second, 0, time.Local))
+		camera.Publish()
+		waitFor(t, fmt.Sprintf("segment %d saved", i+1), func() bool { return countSegments() == i+1 })
+	}
+
+	if n := countEvents(eventScheduleResumed); n != 1 {
+		t.Fatalf("got %d resumed events, want 1", n)
+	}
+
+	// Since 09:01, recording is paused again. The segment is kept in buffer,
+	// then saved along with the segment after the event.
+	clock.Set(time.Date(2026, 10, 19, 9, 1, 30, 0, time.Local))
+	camera.Publish()
+	waitFor(t, "recorder paused again", func() bool { return countEvents(eventSchedulePaused) == 2 })
+
+	time.Sleep(time.Second)
+	if n := countSegments(); n != 2 {
+		t.Fatalf("got %d segments after recording paused, want 2", n)
+	}
+
+	err := h.TriggerEvent("1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Buffer is trimmed from the current time, so the next segment
+	// must come while the buffered one is still within pre-event.
+	clock.Set(time.Date(2026, 10, 19, 9, 1, 35, 0, time.Local))
+	camera.Publish()
+	waitFor(t, "segments around event saved", func() bool { return countSegments() == 4 })
+}
@@ -0,0 +1,424 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	segmentTimeFormat = "2006-01-02-15:04:05.000"
+	videoTimeFormat   = "2006-01-02-15:04:05"
+)
+
+// recorder keeps track of the recording worker for each camera.
+type recorder struct {
+	sync.Mutex
+	workers map[string]*recordWorker
+
+	// cameraLocks makes sure a camera's worker is stopped and started
+	// by one caller at a time, so there is never more than one worker
+	// recording the same camera.
+	cameraLocks map[string]*sync.Mutex
+
+	// clock returns the current time. It's used to estimate the start time
+	// of segments and to follow the schedule, so it can be replaced when
+	// the schedule transitions need to be simulated.
+	clock func() time.Time
+}
+
+// recordWorker is goroutine that record HLS stream of a camera.
+type recordWorker struct {
+	sync.Mutex
+	stop         chan struct{}
+	done         chan struct{}
+	schedule     Schedule
+	eventWindows []eventWindow
+}
+
+// StartRecorder starts recording all cameras that saved in database.
+func (h *WebHandler) StartRecorder() {
+	h.recorder = &recorder{
+		workers:     make(map[string]*recordWorker),
+		cameraLocks: make(map[string]*sync.Mutex),
+		clock:       time.Now,
+	}
+
+	h.prepareVideoCache()
+	h.removeUnfinishedFiles()
+	for _, camID := range h.getCameraIDs() {
+		h.restartCameraRecorder(camID)
+	}
+
+	// Segments from the previous version are not indexed yet
+	go h.indexExistingSegments()
+}
+
+// removeUnfinishedFiles removes the temporary segment and video files that left
+// by the previous run, e.g. because it's crashed while recording. It must be done
+// before the recorder is started, since the workers create their own temporary files.
+func (h *WebHandler) removeUnfinishedFiles() {
+	for _, camID := range h.listStorageCameras() {
+		dir, err := h.cameraStorageDir(camID)
+		if err != nil {
+			continue
+		}
+
+		items, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, item := range items {
+			if item.IsDir() || fp.Ext(item.Name()) != ".tmp" {
+				continue
+			}
+
+			err = os.Remove(fp.Join(dir, item.Name()))
+			if err != nil {
+				logrus.Warnf("failed to remove unfinished recording %s of camera %s: %v\n", item.Name(), camID, err)
+				continue
+			}
+
+			logrus.Infof("removed unfinished recording %s of camera %s\n", item.Name(), camID)
+		}
+	}
+}
+
+// StopRecorder stops recording of all cameras.
+func (h *WebHandler) StopRecorder() {
+	if h.recorder == nil {
+		return
+	}
+
+	h.recorder.Lock()
+	camIDs := []string{}
+	for camID := range h.recorder.workers {
+		camIDs = append(camIDs, camID)
+	}
+	h.recorder.Unlock()
+
+	for _, camID := range camIDs {
+		h.stopCameraRecorder(camID)
+	}
+}
+
+func (h *WebHandler) restartCameraRecorder(camID string) {
+	if h.recorder == nil {
+		return
+	}
+
+	cameraLock := h.recorder.cameraLock(camID)
+	cameraLock.Lock()
+	defer cameraLock.Unlock()
+
+	// Stop the old worker
+	h.stopRecordWorker(camID)
+
+	// Get camera data, its storage directory and its schedule
+	cam, err := h.getCamera(camID)
+	if err != nil {
+		logrus.Warnln("failed to start recorder:", err)
+		return
+	}
+
+	dstDir, err := h.cameraStorageDir(camID)
+	if err != nil {
+		logrus.Warnln("failed to start recorder:", err)
+		return
+	}
+
+	schedule, err := h.getCameraSchedule(camID)
+	if err != nil {
+		logrus.Warnln("failed to start recorder:", err)
+		return
+	}
+
+	// Start the new worker
+	worker := &recordWorker{
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		schedule: schedule,
+	}
+
+	h.recorder.Lock()
+	h.recorder.workers[camID] = worker
+	h.recorder.Unlock()
+
+	go func() {
+		defer close(worker.done)
+		h.logRecorderEvent(camID, eventRecorderStarted)
+		if isFrameCamera(cam) {
+			h.recordFrames(cam, dstDir, schedule, worker)
+		} else {
+			h.recordCamera(cam, dstDir, schedule, worker)
+		}
+		h.logRecorderEvent(camID, eventRecorderStopped)
+	}()
+
+	logrus.Infoln("start recording camera", camID)
+}
+
+func (h *WebHandler) stopCameraRecorder(camID string) {
+	if h.recorder == nil {
+		return
+	}
+
+	cameraLock := h.recorder.cameraLock(camID)
+	cameraLock.Lock()
+	defer cameraLock.Unlock()
+
+	h.stopRecordWorker(camID)
+}
+
+// stopRecordWorker stops the worker of camera and waits until it's done.
+// The caller must hold the camera's lock.
+func (h *WebHandler) stopRecordWorker(camID string) {
+	h.recorder.Lock()
+	worker, exist := h.recorder.workers[camID]
+	delete(h.recorder.workers, camID)
+	h.recorder.Unlock()
+
+	if !exist {
+		return
+	}
+
+	close(worker.stop)
+	<-worker.done
+
+	logrus.Infoln("stop recording camera", camID)
+}
+
+// cameraLock returns the lock for stopping and starting worker of camera.
+func (rc *recorder) cameraLock(camID string) *sync.Mutex {
+	rc.Lock()
+	defer rc.Unlock()
+
+	lock, exist := rc.cameraLocks[camID]
+	if !exist {
+		lock = &sync.Mutex{}
+		rc.cameraLocks[camID] = lock
+	}
+
+	return lock
+}
+
+// recordCamera polls live feed of the camera, then saves each new segment
+// into the camera's storage directory until stop channel is closed. The
+// segments are the only copy of the recording, they are remuxed into MP4
+// only when served as recorded video.
+// Segments are saved all the time while the schedule is in continuous mode,
+// and only around the events while the schedule is in event mode.
+func (h *WebHandler) recordCamera(cam Camera, dstDir string, schedule Schedule, worker *recordWorker) {
+	savedURIs := make(map[string]struct{})
+	lastSegmentEnd := time.Time{}
+	maxDrift := time.Duration(0)
+	buffer := []bufferedSegment{}
+	online := true
+	paused := false
+
+	// saveSegment saves segment that read from src, then adds it into index.
+	saveSegment := func(src io.Reader, startTime time.Time, duration time.Duration) error {
+		// If this segment continues the previous one, use the previous
+		// end time so there are no artificial gap between segments.
+		drift := startTime.Sub(lastSegmentEnd)
+		if drift > -maxDrift && drift < maxDrift {
+			startTime = lastSegmentEnd
+		}
+
+		// Segment file is named by its start time in milliseconds, so
+		// use the same precision in index to find it by its file name.
+		startTime = startTime.Truncate(time.Millisecond)
+
+		segmentFile, err := writeSegmentFile(src, dstDir, startTime)
+		if err != nil {
+			return err
+		}
+
+		err = h.indexSegment(cam.ID, startTime, segmentIndex{
+			File:     segmentFile.Name,
+			Duration: duration,
+			Size:     segmentFile.Size,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to index segment: %v", err)
+		}
+
+		lastSegmentEnd = startTime.Add(duration)
+		return nil
+	}
+
+	// wait returns false if recorder is stopped while waiting
+	wait := func(duration time.Duration) bool {
+		select {
+		case <-worker.stop:
+			return false
+		case <-time.After(duration):
+			return true
+		}
+	}
+
+	for {
+		waitTime := 5 * time.Second
+
+		// Follow the schedule. Continuous recording is paused when schedule is
+		// not in continuous mode, and the camera is not polled at all when it's off.
+		mode := schedule.modeAt(h.recorder.clock())
+		scheduled := mode == scheduleContinuous
+		if scheduled && paused {
+			logrus.Infof("recorder for camera %s resumed by schedule\n", cam.ID)
+			h.logRecorderEvent(cam.ID, eventScheduleResumed)
+		} else if !scheduled && !paused {
+			logrus.Infof("recorder for camera %s paused by schedule\n", cam.ID)
+			h.logRecorderEvent(cam.ID, eventSchedulePaused)
+		}
+		paused = !scheduled
+
+		if mode == scheduleOff {
+			buffer = nil
+			if !wait(waitTime) {
+				return
+			}
+			continue
+		}
+
+		err := func() error {
+			// Make sure storage directory exists
+			err := os.MkdirAll(dstDir, os.ModePerm)
+			if err != nil {
+				return fmt.Errorf("failed to create storage dir: %v", err)
+			}
+
+			// Get live segments from hub
+			snapshot, err := h.getLiveSnapshot(context.Background(), cam)
+			if err != nil {
+				return err
+			}
+
+			if snapshot.TargetDuration > 0 {
+				waitTime = time.Duration(snapshot.TargetDuration * float64(time.Second) / 2)
+			}
+			maxDrift = secondsToDuration(snapshot.TargetDuration)
+
+			// Save new segments
+			currentURIs := make(map[string]struct{})
+			for _, segment := range snapshot.Segments {
+				currentURIs[segment.URI] = struct{}{}
+				if _, saved := savedURIs[segment.URI]; saved {
+					continue
+				}
+
+				// Skip segment that recorded outside of schedule
+				startTime := segment.StartTime
+				duration := segment.Duration
+				segmentMode := schedule.modeAt(startTime)
+				if segmentMode == scheduleOff {
+					savedURIs[segment.URI] = struct{}{}
+					continue
+				}
+
+				// In event mode, segment that not around any event is kept in
+				// buffer, in case there is an event that happened shortly after.
+				if segmentMode == scheduleEvent && !worker.nearEvent(startTime, duration) {
+					buffer = append(buffer, bufferedSegment{
+						StartTime: startTime,
+						Duration:  duration,
+						Data:      segment.Data,
+					})
+
+					savedURIs[segment.URI] = struct{}{}
+					continue
+				}
+
+				// Flush the buffered segments that recorded before the event
+				for _, buffered := range buffer {
+					if worker.nearEvent(buffered.StartTime, buffered.Duration) {
+						err = saveSegment(bytes.NewReader(buffered.Data), buffered.StartTime, buffered.Duration)
+						if err != nil {
+							return err
+						}
+					}
+				}
+				buffer = buffer[:0]
+
+				// Save the current segment
+				err = saveSegment(bytes.NewReader(segment.Data), startTime, duration)
+				if err != nil {
+					return err
+				}
+
+				savedURIs[segment.URI] = struct{}{}
+			}
+
+			// Only keep buffered segments within pre-event duration. Since event
+			// is only checked when polling playlist, add the target duration.
+			buffer = trimSegmentBuffer(buffer, h.recorder.clock().Add(-schedule.preEventDuration()-maxDrift))
+
+			// Forget segments that no longer listed in playlist
+			for uri := range savedURIs {
+				if _, listed := currentURIs[uri]; !listed {
+					delete(savedURIs, uri)
+				}
+			}
+
+			return nil
+		}()
+
+		// Log only when camera status changed, to prevent flooding the log
+		if err != nil && online {
+			logrus.Warnf("recorder for camera %s stalled: %v\n", cam.ID, err)
+			h.logRecorderEvent(cam.ID, eventCameraOffline)
+		} else if err == nil && !online {
+			logrus.Infof("recorder for camera %s resumed\n", cam.ID)
+			h.logRecorderEvent(cam.ID, eventCameraOnline)
+		}
+		online = err == nil
+
+		if !wait(waitTime) {
+			return
+		}
+	}
+}
+
+// writeSegmentFile saves segment that read from src into destination
+// directory, which named by its start time.
+func writeSegmentFile(src io.Reader, dstDir string, startTime time.Time) (recordingFile, error) {
+	// Write to temporary file first, so a partially downloaded
+	// segment never looks like a complete recording.
+	fileName := startTime.Format(segmentTimeFormat) + ".ts"
+	dstPath := fp.Join(dstDir, fileName)
+	tmpPath := dstPath + ".tmp"
+
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return recordingFile{}, fmt.Errorf("failed to create segment file: %v", err)
+	}
+
+	size, err := io.Copy(dst, src)
+	dst.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return recordingFile{}, fmt.Errorf("failed to save segment %s: %v", fileName, err)
+	}
+
+	file := recordingFile{
+		Path: dstPath,
+		Name: fileName,
+		Time: startTime,
+		Size: size,
+	}
+
+	return file, os.Rename(tmpPath, dstPath)
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestRemoveUnfinishedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cygnus-nvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Temporary files left by crash, along with the finished recordings
+	cameraDir := fp.Join(dir, "1")
+	err = os.MkdirAll(cameraDir, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{
+		"2026-10-19-09:00:00.avi",
+		"2026-10-19-09:30:00.avi.tmp",
+		"2026-10-19-09:00:00.000.ts",
+		"2026-10-19-09:00:02.000.ts.tmp",
+	}
+
+	for _, name := range names {
+		err = ioutil.WriteFile(fp.Join(cameraDir, name), []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &WebHandler{StorageDir: dir}
+	h.removeUnfinishedFiles()
+
+	items, err := ioutil.ReadDir(cameraDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remaining := []string{}
+	for _, item := range items {
+		remaining = append(remaining, item.Name())
+	}
+	sort.Strings(remaining)
+
+	if len(remaining) != 2 || remaining[0] != names[2] || remaining[1] != names[0] {
+		t.Errorf("got files %v after cleanup, want only the finished recordings", remaining)
+	}
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	cch "github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Interval for removing the expired sessions from database
+const sessionJanitorInterval = time.Hour
+
+// sessionRecord is login session that saved in database, so user stays logged
+// in after NVR restarted. Zero expiry time means the session never expires.
+type sessionRecord struct {
+	Username  string    `json:"username"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+}
+
+// StartSessionJanitor starts removing the expired sessions from database periodically.
+// The session in memory cache is already removed by the cache's own janitor.
+func (h *WebHandler) StartSessionJanitor() {
+	go func() {
+		for {
+			h.deleteExpiredSessions()
+			time.Sleep(sessionJanitorInterval)
+		}
+	}()
+}
+
+// createSession saves a new session for user into database and memory cache.
+// The session expires after the specified duration, or never if it's negative.
+func (h *WebHandler) createSession(username string, expTime time.Duration, r *http.Request) (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	sessionID := id.String()
+	record := sessionRecord{
+		Username:  username,
+		Created:   now,
+		IP:        requestIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	if expTime > 0 {
+		record.Expires = now.Add(expTime)
+	}
+
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return "", err
+	}
+
+	err = h.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("session"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(sessionID), data)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	h.cacheSession(sessionID, record, now)
+	return sessionID, nil
+}
+
+// loadSession returns username that owns the session. The session is read from
+// memory cache, or from database if it's not cached yet, e.g. after NVR restarted.
+func (h *WebHandler) loadSession(sessionID string) (string, bool) {
+	if username, found := h.SessionCache.Get(sessionID); found {
+		return username.(string), true
+	}
+
+	var record sessionRecord
+	found := false
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("session"))
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get([]byte(sessionID))
+		found = data != nil && json.Unmarshal(data, &record) == nil
+		return nil
+	})
+
+	now := time.Now()
+	if !found || record.isExpired(now) {
+		return "", false
+	}
+
+	h.cacheSession(sessionID, record, now)
+	return record.Username, true
+}
+
+// cacheSession puts session into memory cache, along with the list of user's
+// sessions. The cached session expires at the same time as the saved session.
+func (h *WebHandler) cacheSession(sessionID string, record sessionRecord, now time.Time) {
+	expTime := cch.NoExpiration
+	if !record.Expires.IsZero() {
+		expTime = record.Expires.Sub(now)
+	}
+
+	h.SessionCache.Set(sessionID, record.Username, expTime)
+
+	// Save user's session IDs to cache as well
+	// useful for mass logout
+	sessionIDs := []string{sessionID}
+	if val, found := h.UserCache.Get(record.Username); found {
+		sessionIDs = append(val.([]string), sessionID)
+	}
+	h.UserCache.Set(record.Username, sessionIDs, cch.NoExpiration)
+}
+
+// deleteSession removes session from memory cache and database.
+func (h *WebHandler) deleteSession(sessionID string) {
+	h.SessionCache.Delete(sessionID)
+	h.deleteStoredSessions(sessionID)
+}
+
+// deleteUserSessions removes all sessions of user, including
+// the saved sessions that not loaded into memory cache yet.
+func (h *WebHandler) deleteUserSessions(username string) {
+	if val, found := h.UserCache.Get(username); found {
+		for _, sessionID := range val.([]string) {
+			h.SessionCache.Delete(sessionID)
+		}
+		h.UserCache.Delete(username)
+	}
+
+	sessionIDs := []string{}
+	h.forEachStoredSession(func(sessionID string, record sessionRecord) {
+		if record.Username == username {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	})
+
+	h.deleteStoredSessions(sessionIDs...)
+}
+
+// deleteExpiredSessions removes the expired sessions from database.
+func (h *WebHandler) deleteExpiredSessions() {
+	now := time.Now()
+	sessionIDs := []string{}
+	h.forEachStoredSession(func(sessionID string, record sessionRecord) {
+		if record.isExpired(now) {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	})
+
+	h.deleteStoredSessions(sessionIDs...)
+}
+
+// forEachStoredSession calls fn for each session that saved in database.
+func (h *WebHandler) forEachStoredSession(fn func(sessionID string, record sessionRecord)) {
+	h.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("session"))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, val []byte) error {
+			var record sessionRecord
+			if json.Unmarshal(val, &record) == nil {
+				fn(string(key), record)
+			}
+			return nil
+		})
+	})
+}
+
+// deleteStoredSessions removes the sessions from database.
+func (h *WebHandler) deleteStoredSessions(sessionIDs ...string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	err := h.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("session"))
+		if bucket == nil {
+			return nil
+		}
+
+		for _, sessionID := range sessionIDs {
+			if err := bucket.Delete([]byte(sessionID)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logrus.Warnf("failed to delete sessions: %v\n", err)
+	}
+}
+
+func (record sessionRecord) isExpired(now time.Time) bool {
+	return !record.Expires.IsZero() && !now.Before(record.Expires)
+}
+
+// requestIP returns IP address of the client that sends request.
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
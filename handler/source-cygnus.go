@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	nurl "net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var rxCygnusSessionError = regexp.MustCompile(`(?i)session (is not exist|has been expired)`)
+
+func init() {
+	registerCameraSource("cygnus", func(cam Camera) (CameraSource, error) {
+		reqURL, err := nurl.ParseRequestURI(cam.URL)
+		if err != nil || reqURL.Scheme == "" || reqURL.Hostname() == "" {
+			return nil, fmt.Errorf("url is not valid")
+		}
+
+		return &cygnusSource{cam: cam, client: newCameraHTTPClient(cam, false)}, nil
+	})
+}
+
+// Age of login session before it's renewed. Cygnus camera keeps the session for 6
+// hours since it's requested with remember 6, so it's renewed before it expires.
+const cygnusSessionRenewal = 5*time.Hour + 30*time.Minute
+
+// cygnusSource is driver for Cygnus camera, which serves its live stream
+// as HLS in /live/playlist after logged in through /api/login. If camera
+// rejects the session, it logs in again then retries the request once.
+type cygnusSource struct {
+	sync.Mutex
+	cam       Camera
+	client    *http.Client
+	sessionID string
+	loginTime time.Time
+}
+
+func (s *cygnusSource) Open() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.login()
+}
+
+func (s *cygnusSource) Playlist(ctx context.Context) (hlsPlaylist, error) {
+	body, err := s.get(ctx, "/live/playlist")
+	if err != nil {
+		return hlsPlaylist{}, err
+	}
+	defer body.Close()
+
+	playlist, err := parseHLSPlaylist(body)
+	if err != nil {
+		return hlsPlaylist{}, fmt.Errorf("failed to read /live/playlist from camera %s: %v", s.cam.ID, err)
+	}
+
+	return playlist, nil
+}
+
+func (s *cygnusSource) Segment(ctx context.Context, uri string, w io.Writer) error {
+	urlPath := path.Join("/", uri)
+	body, err := s.get(ctx, urlPath)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = io.Copy(w, body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s from camera %s: %v", urlPath, s.cam.ID, err)
+	}
+
+	return nil
+}
+
+func (s *cygnusSource) Snapshot() ([]byte, error) {
+	return nil, errSnapshotNotSupported
+}
+
+func (s *cygnusSource) Close() error {
+	return nil
+}
+
+// login logs in into camera, then saves the session ID. Caller must hold the lock.
+func (s *cygnusSource) login() error {
+	// Create URL
+	reqURL, err := nurl.ParseRequestURI(s.cam.URL)
+	if err != nil || reqURL.Scheme == "" || reqURL.Hostname() == "" {
+		return fmt.Errorf("camera url is not valid")
+	}
+	reqURL.Path = "/api/login"
+
+	// Create login request
+	loginRequest := LoginRequest{
+		Username: s.cam.Username,
+		Password: s.cam.Password,
+		Remember: 6,
+	}
+
+	// Encode request to JSON
+	buffer := bytes.NewBuffer(nil)
+	err = json.NewEncoder(buffer).Encode(&loginRequest)
+	if err != nil {
+		return fmt.Errorf("failed to create login request: %v", err)
+	}
+
+	// Create HTTP request, then send it
+	req, err := http.NewRequest("POST", reqURL.String(), buffer)
+	if err != nil {
+		return fmt.Errorf("failed to create login request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send login request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Parse response
+	btSessionID, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to parse camera login response: %v", err)
+	}
+
+	if isAuthStatus(resp.StatusCode) {
+		return &authError{camID: s.cam.ID, reason: resp.Status}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &upstreamError{camID: s.cam.ID, statusCode: resp.StatusCode, status: resp.Status}
+	}
+
+	s.sessionID = string(btSessionID)
+	s.loginTime = time.Now()
+	logrus.Infoln("log in into camera", s.cam.ID)
+
+	return nil
+}
+
+// session returns the current session ID. If the session is
+// about to expire, it's renewed by logging in again.
+func (s *cygnusSource) session() (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if time.Since(s.loginTime) >= cygnusSessionRenewal {
+		logrus.Infoln("renew session of camera", s.cam.ID)
+		if err := s.login(); err != nil {
+			return "", err
+		}
+	}
+
+	return s.sessionID, nil
+}
+
+// relogin logs in again after the session is rejected by camera. Since many requests
+// may fail at the same time, it only logs in when the session is not renewed yet by
+// another request, so there is only one login for the same rejected session.
+func (s *cygnusSource) relogin(rejectedSession string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.sessionID == rejectedSession {
+		logrus.Infoln("session of camera", s.cam.ID, "is rejected, log in again")
+		if err := s.login(); err != nil {
+			return "", err
+		}
+	}
+
+	return s.sessionID, nil
+}
+
+// get sends GET request to the specified path in camera, then returns the response
+// body which must be closed by caller. If the session is rejected, it logs in again
+// then retries the request once.
+func (s *cygnusSource) get(ctx context.Context, urlPath string) (io.ReadCloser, error) {
+	sessionID, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := s.request(ctx, urlPath, sessionID)
+	if _, isAuthError := err.(*authError); !isAuthError {
+		return body, err
+	}
+
+	sessionID, err = s.relogin(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.request(ctx, urlPath, sessionID)
+}
+
+// request sends GET request with the specified session to the path in camera.
+func (s *cygnusSource) request(ctx context.Context, urlPath string, sessionID string) (io.ReadCloser, error) {
+	// Create URL
+	reqURL, err := nurl.ParseRequestURI(s.cam.URL)
+	if err != nil || reqURL.Scheme == "" || reqURL.Hostname() == "" {
+		return nil, fmt.Errorf("failed to connect to camera %s: camera url is not valid", s.cam.ID)
+	}
+	reqURL.Path = urlPath
+
+	// Create HTTP request
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to camera %s: %v", s.cam.ID, err)
+	}
+
+	req.AddCookie(&http.Cookie{
+		Name:  "session-id",
+		Value: sessionID,
+	})
+
+	// Send request to camera
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to camera %s: %v", s.cam.ID, err)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+
+	// Only the error message is needed from failed response
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from camera %s: %v", urlPath, s.cam.ID, err)
+	}
+
+	// Cygnus camera reports invalid session as internal server error,
+	// so the session error is detected from its message as well.
+	if isAuthStatus(resp.StatusCode) || (resp.StatusCode == http.StatusInternalServerError &&
+		rxCygnusSessionError.Match(content)) {
+		return nil, &authError{camID: s.cam.ID, reason: strings.TrimSpace(string(content))}
+	}
+
+	return nil, &upstreamError{camID: s.cam.ID, statusCode: resp.StatusCode, status: resp.Status}
+}
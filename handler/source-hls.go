@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	nurl "net/url"
+)
+
+func init() {
+	registerCameraSource("hls", func(cam Camera) (CameraSource, error) {
+		playlistURL, err := nurl.ParseRequestURI(cam.URL)
+		if err != nil || playlistURL.Hostname() == "" ||
+			(playlistURL.Scheme != "http" && playlistURL.Scheme != "https") {
+			return nil, fmt.Errorf("url is not valid")
+		}
+
+		return &hlsSource{
+			cam:         cam,
+			client:      newCameraHTTPClient(cam, false),
+			playlistURL: playlistURL,
+		}, nil
+	})
+}
+
+// hlsSource is driver for camera or service that publishes its stream as plain
+// HLS URL. The URL may point to master playlist, in which case the variant with
+// highest bandwidth is used. If username is set, it's sent as basic auth.
+type hlsSource struct {
+	cam         Camera
+	client      *http.Client
+	playlistURL *nurl.URL
+	mediaURL    *nurl.URL
+}
+
+func (s *hlsSource) Open() error {
+	// Fetch the playlist
+	playlist, err := s.playlist(context.Background(), s.playlistURL)
+	if err != nil {
+		return err
+	}
+
+	// If it's media playlist, use it as it is
+	if len(playlist.Variants) == 0 {
+		s.mediaURL = s.playlistURL
+		return nil
+	}
+
+	// If it's master playlist, find variant with the highest bandwidth
+	variant := playlist.Variants[0]
+	for _, v := range playlist.Variants[1:] {
+		if v.Bandwidth > variant.Bandwidth {
+			variant = v
+		}
+	}
+
+	s.mediaURL, err = s.playlistURL.Parse(variant.URI)
+	if err != nil {
+		return fmt.Errorf("variant url %s is not valid: %v", variant.URI, err)
+	}
+
+	return nil
+}
+
+func (s *hlsSource) Playlist(ctx context.Context) (hlsPlaylist, error) {
+	playlist, err := s.playlist(ctx, s.mediaURL)
+	if err != nil {
+		return hlsPlaylist{}, err
+	}
+
+	// Segment URI may be relative to the playlist, so resolve it into absolute URL
+	for i, segment := range playlist.Segments {
+		segmentURL, err := s.mediaURL.Parse(segment.URI)
+		if err != nil {
+			return hlsPlaylist{}, fmt.Errorf("segment url %s is not valid: %v", segment.URI, err)
+		}
+
+		playlist.Segments[i].URI = segmentURL.String()
+	}
+
+	return playlist, nil
+}
+
+func (s *hlsSource) Segment(ctx context.Context, uri string, w io.Writer) error {
+	segmentURL, err := nurl.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("segment url %s is not valid: %v", uri, err)
+	}
+
+	body, err := s.get(ctx, segmentURL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = io.Copy(w, body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s from camera %s: %v", segmentURL.Path, s.cam.ID, err)
+	}
+
+	return nil
+}
+
+func (s *hlsSource) Snapshot() ([]byte, error) {
+	return nil, errSnapshotNotSupported
+}
+
+func (s *hlsSource) Close() error {
+	return nil
+}
+
+// playlist fetches then parses playlist in the specified URL.
+func (s *hlsSource) playlist(ctx context.Context, playlistURL *nurl.URL) (hlsPlaylist, error) {
+	body, err := s.get(ctx, playlistURL)
+	if err != nil {
+		return hlsPlaylist{}, err
+	}
+	defer body.Close()
+
+	playlist, err := parseHLSPlaylist(body)
+	if err != nil {
+		return hlsPlaylist{}, fmt.Errorf("failed to read %s from camera %s: %v", playlistURL.Path, s.cam.ID, err)
+	}
+
+	return playlist, nil
+}
+
+// get sends GET request to the specified URL, then returns the response
+// body which must be closed by caller.
+func (s *hlsSource) get(ctx context.Context, reqURL *nurl.URL) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to camera %s: %v", s.cam.ID, err)
+	}
+
+	// Only send credential to the camera's own host
+	if s.cam.Username != "" && reqURL.Host == s.playlistURL.Host {
+		req.SetBasicAuth(s.cam.Username, s.cam.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to camera %s: %v", s.cam.ID, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if isAuthStatus(resp.StatusCode) {
+			return nil, &authError{camID: s.cam.ID, reason: resp.Status}
+		}
+		return nil, &upstreamError{camID: s.cam.ID, statusCode: resp.StatusCode, status: resp.Status}
+	}
+
+	return resp.Body, nil
+}
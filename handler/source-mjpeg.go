@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	nurl "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum time to wait for the first frame
+	mjpegOpenTimeout = 20 * time.Second
+
+	// Maximum size of a single JPEG frame in stream
+	maxMJPEGFrameSize = 8 * 1024 * 1024
+)
+
+func init() {
+	registerCameraSource("mjpeg", func(cam Camera) (CameraSource, error) {
+		streamURL, err := nurl.ParseRequestURI(cam.URL)
+		if err != nil || streamURL.Hostname() == "" ||
+			(streamURL.Scheme != "http" && streamURL.Scheme != "https") {
+			return nil, fmt.Errorf("url is not valid")
+		}
+
+		return &mjpegSource{
+			cam:         cam,
+			client:      newCameraHTTPClient(cam, true),
+			ready:       make(chan struct{}),
+			subscribers: make(map[chan []byte]struct{}),
+		}, nil
+	})
+}
+
+// mjpegSource is driver for cheap camera that serves its stream as MJPEG over HTTP,
+// i.e. JPEG frames inside multipart/x-mixed-replace response. If username is set,
+// it's sent as basic auth. Since there are no HLS segments, the stream is served
+// to viewer as it is and recorded as Motion-JPEG AVI.
+type mjpegSource struct {
+	sync.RWMutex
+	cam         Camera
+	client      *http.Client
+	body        interface{ Close() error }
+	ready       chan struct{}
+	lastFrame   []byte
+	subscribers map[chan []byte]struct{}
+	err         error
+}
+
+func (s *mjpegSource) Open() error {
+	req, err := http.NewRequest("GET", s.cam.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to camera %s: %v", s.cam.ID, err)
+	}
+
+	if s.cam.Username != "" {
+		req.SetBasicAuth(s.cam.Username, s.cam.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to camera %s: %v", s.cam.ID, err)
+	}
+
+	if isAuthStatus(resp.StatusCode) {
+		resp.Body.Close()
+		return &authError{camID: s.cam.ID, reason: resp.Status}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return &upstreamError{camID: s.cam.ID, statusCode: resp.StatusCode, status: resp.Status}
+	}
+
+	// Make sure response is multipart stream
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		resp.Body.Close()
+		return fmt.Errorf("camera %s doesn't serve MJPEG stream", s.cam.ID)
+	}
+
+	s.body = resp.Body
+	go s.receive(multipart.NewReader(resp.Body, params["boundary"]))
+
+	// Wait until the first frame received, so snapshot is available
+	select {
+	case <-s.ready:
+	case <-time.After(mjpegOpenTimeout):
+		resp.Body.Close()
+		return fmt.Errorf("timeout while waiting for stream")
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	return s.err
+}
+
+func (s *mjpegSource) Playlist(ctx context.Context) (hlsPlaylist, error) {
+	return hlsPlaylist{}, errNoHLSStream
+}
+
+func (s *mjpegSource) Segment(ctx context.Context, uri string, w io.Writer) error {
+	return errNoHLSStream
+}
+
+func (s *mjpegSource) Snapshot() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return s.lastFrame, nil
+}
+
+func (s *mjpegSource) Close() error {
+	if s.body == nil {
+		return nil
+	}
+
+	return s.body.Close()
+}
+
+func (s *mjpegSource) subscribe() (<-chan []byte, func()) {
+	s.Lock()
+	defer s.Unlock()
+
+	// If stream already ended, return closed channel
+	ch := make(chan []byte, 1)
+	if s.err != nil {
+		close(ch)
+		return ch, func() {}
+	}
+
+	s.subscribers[ch] = struct{}{}
+	unsubscribe := func() {
+		s.Lock()
+		defer s.Unlock()
+
+		if _, exist := s.subscribers[ch]; exist {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+// receive reads JPEG frames from multipart stream, then sends them to the
+// subscribers. It stops when the stream is failed, e.g. because it's closed.
+func (s *mjpegSource) receive(reader *multipart.Reader) {
+	readyClosed := false
+	setReady := func() {
+		if !readyClosed {
+			close(s.ready)
+			readyClosed = true
+		}
+	}
+
+	// Close the stream when camera stops sending frame longer than read timeout
+	watchdog := time.AfterFunc(s.cam.readTimeout(), func() { s.body.Close() })
+	defer watchdog.Stop()
+
+	for {
+		frame, err := readMJPEGFrame(reader)
+		if err != nil {
+			s.Lock()
+			s.err = fmt.Errorf("failed to read stream of camera %s: %v", s.cam.ID, err)
+			for ch := range s.subscribers {
+				close(ch)
+			}
+			s.subscribers = make(map[chan []byte]struct{})
+			s.Unlock()
+			setReady()
+			return
+		}
+
+		watchdog.Reset(s.cam.readTimeout())
+
+		// Send frame to subscribers. If a subscriber is still busy
+		// with the previous frame, skip this frame for it.
+		s.Lock()
+		s.lastFrame = frame
+		for ch := range s.subscribers {
+			select {
+			case ch <- frame:
+			default:
+			}
+		}
+		s.Unlock()
+		setReady()
+	}
+}
+
+// readMJPEGFrame reads the next part of MJPEG stream, skipping part that not JPEG.
+func readMJPEGFrame(reader *multipart.Reader) ([]byte, error) {
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+
+		contentType := part.Header.Get("Content-Type")
+		if contentType != "" && !strings.HasPrefix(contentType, "image/jpeg") {
+			part.Close()
+			continue
+		}
+
+		// Limit the frame size, so broken camera can't exhaust the memory. The rest
+		// of oversized part is not drained, since the stream is abandoned anyway.
+		frame, err := ioutil.ReadAll(io.LimitReader(part, maxMJPEGFrameSize+1))
+		if err == nil && len(frame) > maxMJPEGFrameSize {
+			return nil, fmt.Errorf("frame is larger than %d bytes", maxMJPEGFrameSize)
+		}
+
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// Make sure it's a JPEG image
+		if len(frame) < 2 || frame[0] != 0xFF || frame[1] != 0xD8 {
+			continue
+		}
+
+		return frame, nil
+	}
+}
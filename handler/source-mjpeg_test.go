@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/textproto"
+	"testing"
+)
+
+func TestReadMJPEGFrame(t *testing.T) {
+	jpeg := func(size int) []byte {
+		return append([]byte{0xFF, 0xD8}, bytes.Repeat([]byte{0x42}, size-2)...)
+	}
+
+	stream := &bytes.Buffer{}
+	writer := multipart.NewWriter(stream)
+	parts := []struct {
+		contentType string
+		data        []byte
+	}{
+		{"text/plain", []byte("not a frame")},
+		{"image/jpeg", jpeg(1024)},
+		{"image/jpeg", jpeg(maxMJPEGFrameSize + 1)},
+		{"image/jpeg", jpeg(1024)},
+	}
+
+	for _, p := range parts {
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(p.data)
+	}
+	writer.Close()
+
+	// Part that is not JPEG is skipped
+	reader := multipart.NewReader(stream, writer.Boundary())
+	frame, err := readMJPEGFrame(reader)
+	if err != nil || len(frame) != 1024 {
+		t.Fatalf("got frame with %d bytes and error %v, want 1024 bytes", len(frame), err)
+	}
+
+	// Oversized frame fails the stream
+	frame, err = readMJPEGFrame(reader)
+	if err == nil {
+		t.Fatalf("got frame with %d bytes, want error for oversized frame", len(frame))
+	}
+}
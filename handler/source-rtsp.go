@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	nurl "net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+	"github.com/RadhiFadlillah/cygnus-nvr/rtsp"
+)
+
+const (
+	// Minimum duration of TS segment that created from RTSP stream.
+	// Segment is only cut in keyframe, so it may be longer than this.
+	rtspSegmentDuration = 2 * time.Second
+
+	// Count of TS segments that kept in memory
+	rtspSegmentCount = 6
+
+	// Maximum time to wait for the first segment
+	rtspOpenTimeout = 20 * time.Second
+
+	// Maximum gap between packets before it's considered as discontinuity, in 90 kHz clock
+	maxTimestampJump = 10 * 90000
+)
+
+func init() {
+	registerCameraSource("rtsp", newRTSPSourceFactory(rtsp.TCP))
+	registerCameraSource("rtsp-udp", newRTSPSourceFactory(rtsp.UDP))
+}
+
+func newRTSPSourceFactory(transport rtsp.Transport) cameraSourceFactory {
+	return func(cam Camera) (CameraSource, error) {
+		streamURL, err := nurl.Parse(cam.URL)
+		if err != nil || streamURL.Scheme != "rtsp" || streamURL.Hostname() == "" {
+			return nil, fmt.Errorf("url is not valid")
+		}
+
+		return &rtspSource{
+			cam:       cam,
+			transport: transport,
+			ready:     make(chan struct{}),
+		}, nil
+	}
+}
+
+// rtspSource is driver for IP camera that serves its stream through RTSP.
+// The video track is packaged into TS segments and kept in memory, so it
+// can be served as HLS live playlist. Audio track is not recorded.
+type rtspSource struct {
+	sync.RWMutex
+	cam          Camera
+	transport    rtsp.Transport
+	client       *rtsp.Client
+	ready        chan struct{}
+	segments     []rtspSegment
+	nextSequence int
+	err          error
+}
+
+// rtspSegment is TS segment that created from RTSP stream.
+type rtspSegment struct {
+	URI      string
+	Duration float64
+	Data     []byte
+}
+
+func (s *rtspSource) Open() error {
+	client, err := rtsp.DialTimeout(s.cam.URL, s.cam.Username, s.cam.Password,
+		s.transport, s.cam.connectTimeout(), s.cam.readTimeout())
+	if err != nil {
+		if statusErr, ok := err.(*rtsp.StatusError); ok && statusErr.StatusCode == 401 {
+			return &authError{camID: s.cam.ID, reason: statusErr.Status}
+		}
+		return err
+	}
+
+	s.client = client
+	go s.receive()
+
+	// Wait until the first segment created, so playlist is not empty
+	select {
+	case <-s.ready:
+	case <-time.After(rtspOpenTimeout):
+		client.Close()
+		return fmt.Errorf("timeout while waiting for stream")
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	return s.err
+}
+
+func (s *rtspSource) Playlist(ctx context.Context) (hlsPlaylist, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.err != nil {
+		return hlsPlaylist{}, s.err
+	}
+
+	playlist := hlsPlaylist{
+		TargetDuration: rtspSegmentDuration.Seconds(),
+		MediaSequence:  s.nextSequence - len(s.segments),
+	}
+
+	for _, segment := range s.segments {
+		playlist.TargetDuration = math.Max(playlist.TargetDuration, math.Ceil(segment.Duration))
+		playlist.Segments = append(playlist.Segments, hlsSegment{
+			URI:      segment.URI,
+			Duration: segment.Duration,
+		})
+	}
+
+	return playlist, nil
+}
+
+func (s *rtspSource) Segment(ctx context.Context, uri string, w io.Writer) error {
+	var data []byte
+	s.RLock()
+	for _, segment := range s.segments {
+		if segment.URI == uri {
+			data = segment.Data
+			break
+		}
+	}
+	s.RUnlock()
+
+	if data == nil {
+		return fmt.Errorf("segment %s of camera %s is not exist", uri, s.cam.ID)
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+func (s *rtspSource) Snapshot() ([]byte, error) {
+	return nil, errSnapshotNotSupported
+}
+
+func (s *rtspSource) Close() error {
+	if s.client == nil {
+		return nil
+	}
+
+	return s.client.Close()
+}
+
+// receive reads packets from RTSP stream, then cut them into TS segments in
+// keyframe. It stops when the stream is failed, e.g. because it's closed.
+func (s *rtspSource) receive() {
+	var buffer bytes.Buffer
+	var writer *remux.TSWriter
+	var segmentStart, lastDTS int64
+	readyClosed := false
+
+	setReady := func() {
+		if !readyClosed {
+			close(s.ready)
+			readyClosed = true
+		}
+	}
+
+	for {
+		packet, err := s.client.ReadPacket()
+		if err != nil {
+			s.Lock()
+			s.err = fmt.Errorf("failed to read stream of camera %s: %v", s.cam.ID, err)
+			s.Unlock()
+			setReady()
+			return
+		}
+
+		// If timestamp jumps, e.g. camera restarted the stream, drop the
+		// current segment and wait for the next keyframe.
+		jumped := packet.DTS < lastDTS || packet.DTS-lastDTS > maxTimestampJump
+		lastDTS = packet.DTS
+		if writer != nil && jumped {
+			writer = nil
+		}
+
+		// Cut the segment when it's long enough
+		elapsed := time.Duration(packet.DTS-segmentStart) * time.Second / 90000
+		if writer != nil && packet.Keyframe && elapsed >= rtspSegmentDuration {
+			s.addSegment(buffer.Bytes(), elapsed)
+			setReady()
+			writer = nil
+		}
+
+		// Segment must be started with keyframe
+		if writer == nil {
+			if !packet.Keyframe {
+				continue
+			}
+
+			buffer = bytes.Buffer{}
+			writer = remux.NewTSWriter(&buffer, s.client.Codec())
+			segmentStart = packet.DTS
+		}
+
+		err = writer.WritePacket(packet)
+		if err != nil {
+			s.Lock()
+			s.err = err
+			s.Unlock()
+			setReady()
+			return
+		}
+	}
+}
+
+func (s *rtspSource) addSegment(data []byte, duration time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.segments = append(s.segments, rtspSegment{
+		URI:      strconv.Itoa(s.nextSequence) + ".ts",
+		Duration: duration.Seconds(),
+		Data:     data,
+	})
+	s.nextSequence++
+
+	if n := len(s.segments); n > rtspSegmentCount {
+		s.segments = append([]rtspSegment{}, s.segments[n-rtspSegmentCount:]...)
+	}
+}
@@ -0,0 +1,303 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Type of camera that used when it's not specified
+const defaultCameraType = "cygnus"
+
+// Duration before an opened camera source is reopened
+const cameraSourceExpiration = 6 * time.Hour
+
+// Maximum count of concurrent requests to a camera
+const maxCameraRequests = 4
+
+// Timeouts that used when camera doesn't specify its own
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultReadTimeout    = 30 * time.Second
+)
+
+var errSnapshotNotSupported = fmt.Errorf("snapshot is not supported by this camera")
+
+// authError is returned by camera source when the camera rejects its
+// credential, so it can be told apart from camera that unreachable.
+type authError struct {
+	camID  string
+	reason string
+}
+
+func (e *authError) Error() string {
+	return fmt.Sprintf("camera %s rejected the credential: %s", e.camID, e.reason)
+}
+
+// upstreamError is returned by camera source when the camera responds
+// with unexpected HTTP status, so the status can be passed to viewer.
+type upstreamError struct {
+	camID      string
+	statusCode int
+	status     string
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("failed to connect to camera %s: %s", e.camID, e.status)
+}
+
+// upstreamStatus returns HTTP status that should be sent to viewer for error
+// from camera source, or zero if the error is not caused by camera's response
+// or by its open circuit.
+// Since viewer's own session is still valid, the camera rejecting credential is
+// reported as bad gateway instead of passing its 401 or 403 status.
+func upstreamStatus(err error) int {
+	switch e := err.(type) {
+	case *circuitOpenError:
+		return http.StatusServiceUnavailable
+	case *authError:
+		return http.StatusBadGateway
+	case *upstreamError:
+		switch e.statusCode {
+		case http.StatusNotFound, http.StatusTooManyRequests,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return e.statusCode
+		}
+		return http.StatusBadGateway
+	}
+
+	return 0
+}
+
+// isAuthStatus checks if HTTP status code means the credential is rejected.
+func isAuthStatus(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+// CameraSource is driver for fetching live stream from a kind of camera.
+// The live stream is provided as HLS playlist and its segments. The request
+// for playlist and segment is aborted when the context is canceled.
+type CameraSource interface {
+	// Open connects to the camera, e.g. by logging in to it.
+	Open() error
+
+	// Playlist returns the current live playlist of the camera.
+	Playlist(ctx context.Context) (hlsPlaylist, error)
+
+	// Segment writes content of a segment that listed in playlist into w,
+	// while it's received from the camera.
+	Segment(ctx context.Context, uri string, w io.Writer) error
+
+	// Snapshot returns the current image of the camera as JPEG.
+	Snapshot() ([]byte, error)
+
+	// Close disconnects from the camera and releases its resources.
+	Close() error
+}
+
+// frameSource is camera source that provides its stream as separate
+// JPEG frames instead of HLS segments, e.g. MJPEG camera.
+type frameSource interface {
+	CameraSource
+
+	// subscribe returns channel that receives every new frame. The channel is
+	// closed when the stream is ended. Call the returned function to unsubscribe.
+	subscribe() (<-chan []byte, func())
+}
+
+// cameraSourceFactory creates driver for the specified camera. It should
+// return error when camera data is not valid for the driver, e.g. its URL.
+type cameraSourceFactory func(cam Camera) (CameraSource, error)
+
+var cameraSources = map[string]cameraSourceFactory{}
+
+// registerCameraSource registers driver for a camera type.
+// It's supposed to be called from init function of the driver.
+func registerCameraSource(cameraType string, factory cameraSourceFactory) {
+	cameraSources[cameraType] = factory
+}
+
+// newCameraSource creates driver for the camera following its type.
+func newCameraSource(cam Camera) (CameraSource, error) {
+	cameraType := cam.Type
+	if cameraType == "" {
+		cameraType = defaultCameraType
+	}
+
+	factory, exist := cameraSources[cameraType]
+	if !exist {
+		return nil, fmt.Errorf("camera type %s is not supported", cameraType)
+	}
+
+	return factory(cam)
+}
+
+// prepareCameraCache makes sure camera source is closed
+// when it's removed or expired from cache.
+func (h *WebHandler) prepareCameraCache() {
+	h.CameraCache.OnEvicted(func(camID string, val interface{}) {
+		val.(CameraSource).Close()
+	})
+}
+
+// getCameraSource returns the opened source for camera. The source is
+// cached, so the camera doesn't need to be reopened for every request.
+// Only one caller opens the source at a time, while the others wait and
+// use the opened one, since some cameras only accept a single client.
+func (h *WebHandler) getCameraSource(cam Camera) (CameraSource, error) {
+	if cached, exist := h.CameraCache.Get(cam.ID); exist {
+		return cached.(CameraSource), nil
+	}
+
+	unlock := h.lockCameraSource(cam.ID)
+	defer unlock()
+
+	if cached, exist := h.CameraCache.Get(cam.ID); exist {
+		return cached.(CameraSource), nil
+	}
+
+	source, err := newCameraSource(cam)
+	if err != nil {
+		return nil, err
+	}
+
+	err = source.Open()
+	if err != nil {
+		if upstreamStatus(err) != 0 {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to open camera %s: %v", cam.ID, err)
+	}
+
+	h.CameraCache.Set(cam.ID, source, cameraSourceExpiration)
+	return source, nil
+}
+
+// closeCameraSource closes the cached source of camera, e.g. because the camera
+// is disconnected or its data is changed. It will be reopened on the next request.
+// If the source is being opened, it waits until it's opened then closes it.
+func (h *WebHandler) closeCameraSource(camID string) {
+	unlock := h.lockCameraSource(camID)
+	defer unlock()
+
+	h.CameraCache.Delete(camID)
+}
+
+// releaseCameraSource closes the cached source of camera only if it's still the
+// specified source. It's used when the source is found broken by one of its
+// users, so it doesn't close the new source that opened by another user.
+func (h *WebHandler) releaseCameraSource(camID string, source CameraSource) {
+	if cached, exist := h.CameraCache.Get(camID); exist && cached == source {
+		h.closeCameraSource(camID)
+	}
+}
+
+// cameraSourceLocks makes sure each camera source
+// is opened and closed by one caller at a time.
+type cameraSourceLocks struct {
+	sync.Mutex
+	items map[string]*sync.Mutex
+}
+
+// lockCameraSource locks the source of camera. Call the returned function
+// to unlock it. It never locks if the locks are not prepared.
+func (h *WebHandler) lockCameraSource(camID string) func() {
+	if h.sourceLocks == nil {
+		return func() {}
+	}
+
+	h.sourceLocks.Lock()
+	lock, exist := h.sourceLocks.items[camID]
+	if !exist {
+		lock = &sync.Mutex{}
+		h.sourceLocks.items[camID] = lock
+	}
+	h.sourceLocks.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// cameraLimiter limits the count of concurrent requests to each camera,
+// so many viewers at once don't overload camera with limited resources.
+type cameraLimiter struct {
+	sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// acquireCameraSlot waits until there is a free request slot for camera. Call the
+// returned function to free the slot. If the context is canceled while waiting,
+// it returns the context's error. It never waits if the limiter is not prepared.
+func (h *WebHandler) acquireCameraSlot(ctx context.Context, camID string) (func(), error) {
+	if h.limiter == nil {
+		return func() {}, nil
+	}
+
+	h.limiter.Lock()
+	slots, exist := h.limiter.slots[camID]
+	if !exist {
+		slots = make(chan struct{}, maxCameraRequests)
+		h.limiter.slots[camID] = slots
+	}
+	h.limiter.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// isFrameCamera checks if camera provides its stream as JPEG frames instead of HLS.
+func isFrameCamera(cam Camera) bool {
+	source, err := newCameraSource(cam)
+	if err != nil {
+		return false
+	}
+
+	_, isFrameSource := source.(frameSource)
+	return isFrameSource
+}
+
+func (cam Camera) connectTimeout() time.Duration {
+	if cam.ConnectTimeout <= 0 {
+		return defaultConnectTimeout
+	}
+	return time.Duration(cam.ConnectTimeout) * time.Second
+}
+
+func (cam Camera) readTimeout() time.Duration {
+	if cam.ReadTimeout <= 0 {
+		return defaultReadTimeout
+	}
+	return time.Duration(cam.ReadTimeout) * time.Second
+}
+
+// newCameraHTTPClient returns HTTP client that follows the timeouts of camera.
+// For stream that never ends, e.g. MJPEG, the read timeout only limits the time
+// to wait for response header, so the stream is not cut in the middle.
+func newCameraHTTPClient(cam Camera, stream bool) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   cam.connectTimeout(),
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   cam.connectTimeout(),
+		ResponseHeaderTimeout: cam.readTimeout(),
+		MaxIdleConnsPerHost:   maxCameraRequests,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	client := &http.Client{Transport: transport}
+	if !stream {
+		client.Timeout = cam.connectTimeout() + cam.readTimeout()
+	}
+
+	return client
+}
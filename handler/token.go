@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Lifetime of access token, when it's not specified and at most
+const (
+	defaultTokenExpiration = 24 * time.Hour
+	maxTokenExpiration     = 30 * 24 * time.Hour
+)
+
+// Access token is JWT which signed with HMAC SHA-256 using the secret key,
+// so it can be verified without saving it. Only this header is accepted.
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// tokenClaims is the content of access token. Scope is the space
+// separated list of scopes that allowed for the token.
+type tokenClaims struct {
+	Subject  string `json:"sub"`
+	Scope    string `json:"scope"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+// createToken encodes the claims into a signed access token.
+func createToken(claims tokenClaims) (string, error) {
+	payload, err := json.Marshal(&claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + signToken(unsigned), nil
+}
+
+// parseToken verifies signature and expiry of access token, then returns its claims.
+func parseToken(token string, now time.Time) (tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return tokenClaims{}, fmt.Errorf("token is not valid")
+	}
+
+	signature := signToken(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(signature), []byte(parts[2])) {
+		return tokenClaims{}, fmt.Errorf("token is not valid")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return tokenClaims{}, fmt.Errorf("token is not valid")
+	}
+
+	var claims tokenClaims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return tokenClaims{}, fmt.Errorf("token is not valid")
+	}
+
+	if now.Unix() >= claims.Expires {
+		return tokenClaims{}, fmt.Errorf("token has been expired")
+	}
+
+	return claims, nil
+}
+
+func signToken(unsigned string) string {
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// getTokenAccount returns account of user that owns the access token. The account
+// is limited by the token's scopes. Since user may be deleted or its role changed
+// after the token created, the account is always read from database.
+func (h *WebHandler) getTokenAccount(token string) (account, error) {
+	claims, err := parseToken(token, time.Now())
+	if err != nil {
+		return account{}, err
+	}
+
+	acc, err := h.getAccount(claims.Subject)
+	if err != nil {
+		return account{}, err
+	}
+
+	acc.limitScopes(strings.Fields(claims.Scope))
+	return acc, nil
+}
+
+// bearerToken returns the token in request's Authorization header, if any.
+func bearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(authorization[7:])
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package handler
+
+import "fmt"
+
+// diskFreeSpace returns free space in bytes of the disk that contains dir.
+func diskFreeSpace(dir string) (int64, error) {
+	return 0, fmt.Errorf("checking free space is not supported in windows")
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package handler
+
+import "syscall"
+
+// diskFreeSpace returns free space in bytes of the disk that contains dir.
+func diskFreeSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
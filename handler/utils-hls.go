@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// hlsPlaylist is the parsed content of HLS playlist. For master
+// playlist, it only contains the variant streams.
+type hlsPlaylist struct {
+	TargetDuration float64
+	MediaSequence  int
+	Segments       []hlsSegment
+	Variants       []hlsVariant
+}
+
+// hlsSegment is a single media segment inside HLS playlist
+type hlsSegment struct {
+	URI      string
+	Duration float64
+}
+
+// hlsVariant is a variant stream inside HLS master playlist
+type hlsVariant struct {
+	URI       string
+	Bandwidth int
+}
+
+// parseHLSPlaylist parses HLS playlist line by line while it's read,
+// so the response body doesn't need to be read into memory first.
+func parseHLSPlaylist(r io.Reader) (hlsPlaylist, error) {
+	playlist := hlsPlaylist{}
+	segmentDuration := 0.0
+	var variant *hlsVariant
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			value := strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:")
+			playlist.TargetDuration, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			value := strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:")
+			playlist.MediaSequence, _ = strconv.Atoi(value)
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			value = strings.SplitN(value, ",", 2)[0]
+			segmentDuration, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attributes := strings.TrimPrefix(line, "#EXT-X-STREAM-INF:")
+			variant = &hlsVariant{}
+			for _, attribute := range strings.Split(attributes, ",") {
+				if strings.HasPrefix(attribute, "BANDWIDTH=") {
+					value := strings.TrimPrefix(attribute, "BANDWIDTH=")
+					variant.Bandwidth, _ = strconv.Atoi(value)
+				}
+			}
+		case strings.HasPrefix(line, "#"):
+			continue
+		case variant != nil:
+			variant.URI = line
+			playlist.Variants = append(playlist.Variants, *variant)
+			variant = nil
+		default:
+			playlist.Segments = append(playlist.Segments, hlsSegment{
+				URI:      line,
+				Duration: segmentDuration,
+			})
+			segmentDuration = 0
+		}
+	}
+
+	return playlist, scanner.Err()
+}
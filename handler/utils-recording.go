@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var rxSavedSegment = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(\d{2}:\d{2}:\d{2}\.\d{3})\.ts$`)
+
+// recordingFile is a video or segment that saved by recorder. Since
+// the file is last written when its recording ended, its modification
+// time is used as the end time of the recording.
+type recordingFile struct {
+	Path    string
+	Name    string
+	Time    time.Time
+	Size    int64
+	ModTime time.Time
+}
+
+// cameraStorageDir returns the recording directory of a camera. It makes
+// sure the returned path is directly inside the storage directory.
+func (h *WebHandler) cameraStorageDir(camID string) (string, error) {
+	storageDir, err := fp.Abs(h.StorageDir)
+	if err != nil {
+		return "", err
+	}
+
+	cameraDir := fp.Join(storageDir, camID)
+	if fp.Dir(cameraDir) != storageDir {
+		return "", fmt.Errorf("path is not valid")
+	}
+
+	return cameraDir, nil
+}
+
+// listRecordings returns video and segment files of a camera, sorted by time.
+func (h *WebHandler) listRecordings(camID string) ([]recordingFile, error) {
+	dir, err := h.cameraStorageDir(camID)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	files := []recordingFile{}
+	for _, item := range items {
+		if item.IsDir() {
+			continue
+		}
+
+		name := item.Name()
+		timeFormat := ""
+		switch {
+		case rxSavedVideo.MatchString(name):
+			timeFormat = videoTimeFormat
+		case rxSavedSegment.MatchString(name):
+			timeFormat = segmentTimeFormat
+		default:
+			continue
+		}
+
+		strTime := strings.TrimSuffix(name, fp.Ext(name))
+		fileTime, err := time.ParseInLocation(timeFormat, strTime, time.Local)
+		if err != nil {
+			continue
+		}
+
+		files = append(files, recordingFile{
+			Path:    fp.Join(dir, name),
+			Name:    name,
+			Time:    fileTime,
+			Size:    item.Size(),
+			ModTime: item.ModTime(),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Time.Before(files[j].Time)
+	})
+
+	return files, nil
+}
+
+// listStorageCameras returns ID of cameras that has directory in storage,
+// including cameras that already deleted from database.
+func (h *WebHandler) listStorageCameras() []string {
+	items, err := ioutil.ReadDir(h.StorageDir)
+	if err != nil {
+		return nil
+	}
+
+	camIDs := []string{}
+	for _, item := range items {
+		if item.IsDir() && !strings.HasPrefix(item.Name(), ".") {
+			camIDs = append(camIDs, item.Name())
+		}
+	}
+
+	return camIDs
+}
@@ -20,6 +20,10 @@ import (
 var (
 	portNumber = 8081
 	dbPath     = "cygnus-nvr.db"
+	storageDir = "recordings"
+
+	// Length of each video that served from recorded segments
+	videoDuration = 10 * time.Minute
 )
 
 func main() {
@@ -43,12 +47,28 @@ func main() {
 func serveApp(db *bbolt.DB) {
 	// Prepare web handler
 	hdl := handler.WebHandler{
-		DB:           db,
-		UserCache:    cch.New(time.Hour, 10*time.Minute),
-		SessionCache: cch.New(time.Hour, 10*time.Minute),
-		CameraCache:  cch.New(time.Hour, 10*time.Minute),
+		DB:            db,
+		UserCache:     cch.New(time.Hour, 10*time.Minute),
+		SessionCache:  cch.New(time.Hour, 10*time.Minute),
+		CameraCache:   cch.New(time.Hour, 10*time.Minute),
+		PTZCache:      cch.New(time.Hour, 10*time.Minute),
+		VideoCache:    cch.New(time.Hour, time.Minute),
+		StorageDir:    storageDir,
+		VideoDuration: videoDuration,
 	}
 
+	// Restore login sessions from database and clean up the expired ones
+	hdl.PrepareLoginCache()
+	hdl.StartSessionJanitor()
+
+	// Start live feed, recording and health monitor of cameras, clean
+	// up the old recordings and resume the unfinished export jobs
+	hdl.StartLiveHub()
+	hdl.StartRecorder()
+	hdl.StartHealthMonitor()
+	hdl.StartRetention()
+	hdl.StartExporter()
+
 	// Prepare router
 	router := httprouter.New()
 
@@ -59,21 +79,57 @@ func serveApp(db *bbolt.DB) {
 
 	router.GET("/", hdl.ServeIndexPage)
 	router.GET("/login", hdl.ServeLoginPage)
-	router.GET("/cam/:camID/live/playlist", hdl.ServeLivePlaylist)
-	router.GET("/cam/:camID/live/stream/:index", hdl.ServeLiveSegment)
+	// Viewer may only watch its cameras, operator may also control them,
+	// while only admin may manage cameras, users and setting. Request that
+	// uses API key must also have the route's scope in the key.
+	viewer, operator, admin := handler.RoleViewer, handler.RoleOperator, handler.RoleAdmin
+	readCameras, manageCameras := handler.ScopeReadCameras, handler.ScopeManageCameras
+	manageUsers, playback := handler.ScopeManageUsers, handler.ScopePlayback
+
+	router.GET("/cam/:camID/live/playlist", hdl.AuthorizeCamera(viewer, readCameras, hdl.ServeLivePlaylist))
+	router.GET("/cam/:camID/live/stream/:index", hdl.AuthorizeCamera(viewer, readCameras, hdl.ServeLiveSegment))
+	router.GET("/cam/:camID/live/mjpeg", hdl.AuthorizeCamera(viewer, readCameras, hdl.ServeLiveMJPEG))
+	router.GET("/cam/:camID/snapshot", hdl.AuthorizeCamera(viewer, readCameras, hdl.ServeSnapshot))
+	router.GET("/cam/:camID/records", hdl.AuthorizeCamera(viewer, playback, hdl.ServeRecordList))
+	router.GET("/cam/:camID/records/:file", hdl.AuthorizeCamera(viewer, playback, hdl.ServeRecordFile))
+	router.GET("/cam/:camID/vod/playlist", hdl.AuthorizeCamera(viewer, playback, hdl.ServeVODPlaylist))
+	router.GET("/cam/:camID/vod/segment/:file", hdl.AuthorizeCamera(viewer, playback, hdl.ServeVODSegment))
 
 	router.POST("/api/login", hdl.APILogin)
 	router.POST("/api/logout", hdl.APILogout)
-
-	router.GET("/api/camera", hdl.APIGetCameraList)
-	router.POST("/api/camera", hdl.APISaveCamera)
-	router.DELETE("/api/camera/:id", hdl.APIDeleteCamera)
-
-	router.GET("/api/user", hdl.APIGetUsers)
-	router.POST("/api/user", hdl.APIInsertUser)
-	router.DELETE("/api/user/:username", hdl.APIDeleteUser)
-
-	router.GET("/api/setting", hdl.APIGetSetting)
+	router.POST("/api/token", hdl.APICreateToken)
+
+	router.GET("/api/camera", hdl.Authorize(viewer, readCameras, hdl.APIGetCameraList))
+	router.GET("/api/camera/:id", handler.RouteByParam("id", map[string]httprouter.Handle{
+		"status": hdl.Authorize(viewer, readCameras, hdl.APIGetCameraStatus),
+	}))
+	router.POST("/api/camera", hdl.Authorize(admin, manageCameras, hdl.APISaveCamera))
+	router.DELETE("/api/camera/:id", hdl.Authorize(admin, manageCameras, hdl.APIDeleteCamera))
+	router.POST("/api/camera/:id", handler.RouteByParam("id", map[string]httprouter.Handle{
+		"discover": hdl.Authorize(admin, manageCameras, hdl.APIDiscoverCameras),
+	}))
+	router.GET("/api/camera/:id/schedule", hdl.AuthorizeCamera(operator, readCameras, hdl.APIGetCameraSchedule))
+	router.POST("/api/camera/:id/schedule", hdl.Authorize(admin, manageCameras, hdl.APISaveCameraSchedule))
+	router.DELETE("/api/camera/:id/schedule", hdl.Authorize(admin, manageCameras, hdl.APIDeleteCameraSchedule))
+	router.POST("/api/camera/:id/event", hdl.AuthorizeCamera(operator, manageCameras, hdl.APITriggerEvent))
+	router.GET("/api/camera/:id/timeline", hdl.AuthorizeCamera(viewer, playback, hdl.APIGetTimeline))
+	router.POST("/api/camera/:id/ptz", hdl.AuthorizeCamera(operator, manageCameras, hdl.APIControlPTZ))
+	router.GET("/api/camera/:id/ptz/presets", hdl.AuthorizeCamera(operator, readCameras, hdl.APIGetPTZPresets))
+	router.POST("/api/camera/:id/export", hdl.AuthorizeCamera(operator, playback, hdl.APIExportCamera))
+	router.GET("/api/camera/:id/export/:job", hdl.AuthorizeCamera(operator, playback, hdl.APIGetExportJob))
+	router.GET("/api/camera/:id/export/:job/download", hdl.AuthorizeCamera(operator, playback, hdl.APIDownloadExport))
+
+	router.GET("/api/user", hdl.Authorize(admin, manageUsers, hdl.APIGetUsers))
+	router.POST("/api/user", hdl.Authorize(admin, manageUsers, hdl.APIInsertUser))
+	router.PUT("/api/user/:username", hdl.Authorize(admin, manageUsers, hdl.APIUpdateUser))
+	router.DELETE("/api/user/:username", hdl.Authorize(admin, manageUsers, hdl.APIDeleteUser))
+	router.POST("/api/user/:username/unlock", hdl.Authorize(admin, manageUsers, hdl.APIUnlockUser))
+	router.GET("/api/user/:username/keys", hdl.Authorize(viewer, manageUsers, hdl.APIGetAPIKeys))
+	router.POST("/api/user/:username/keys", hdl.Authorize(viewer, manageUsers, hdl.APICreateAPIKey))
+	router.DELETE("/api/user/:username/keys", hdl.Authorize(viewer, manageUsers, hdl.APIDeleteAPIKey))
+
+	router.GET("/api/setting", hdl.Authorize(admin, manageCameras, hdl.APIGetSetting))
+	router.POST("/api/setting", hdl.Authorize(admin, manageCameras, hdl.APISaveSetting))
 
 	router.PanicHandler = func(w http.ResponseWriter, r *http.Request, arg interface{}) {
 		http.Error(w, fmt.Sprint(arg), 500)
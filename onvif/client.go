@@ -0,0 +1,156 @@
+package onvif
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Client is connection to the services of an ONVIF device.
+type Client struct {
+	// DeviceURL is URL of the device service, which advertised in XAddrs.
+	DeviceURL string
+	Username  string
+	Password  string
+
+	// HTTPClient is used to send request. If nil, client with default timeout is used.
+	HTTPClient *http.Client
+
+	mediaURL     string
+	ptzURL       string
+	profileToken string
+}
+
+// DeviceInformation is the basic information of ONVIF device.
+type DeviceInformation struct {
+	Manufacturer    string `xml:"GetDeviceInformationResponse>Manufacturer"`
+	Model           string `xml:"GetDeviceInformationResponse>Model"`
+	FirmwareVersion string `xml:"GetDeviceInformationResponse>FirmwareVersion"`
+	SerialNumber    string `xml:"GetDeviceInformationResponse>SerialNumber"`
+	HardwareID      string `xml:"GetDeviceInformationResponse>HardwareId"`
+}
+
+// NewClient returns client for device service in deviceURL.
+func NewClient(deviceURL, username, password string) *Client {
+	return &Client{
+		DeviceURL: deviceURL,
+		Username:  username,
+		Password:  password,
+	}
+}
+
+// GetDeviceInformation returns manufacturer, model and serial number of device.
+func (c *Client) GetDeviceInformation() (DeviceInformation, error) {
+	var info DeviceInformation
+	err := c.call(c.DeviceURL, `<GetDeviceInformation xmlns="http://www.onvif.org/ver10/device/wsdl"/>`, &info)
+	if err != nil {
+		return DeviceInformation{}, fmt.Errorf("failed to get device information: %v", err)
+	}
+
+	return info, nil
+}
+
+// GetStreamURI returns RTSP URI of the first media profile of device.
+func (c *Client) GetStreamURI() (string, error) {
+	mediaURL, err := c.getMediaURL()
+	if err != nil {
+		return "", err
+	}
+
+	profileToken, err := c.getProfileToken()
+	if err != nil {
+		return "", err
+	}
+
+	var stream struct {
+		URI string `xml:"GetStreamUriResponse>MediaUri>Uri"`
+	}
+
+	body := `<GetStreamUri xmlns="http://www.onvif.org/ver10/media/wsdl">` +
+		`<StreamSetup>` +
+		`<Stream xmlns="http://www.onvif.org/ver10/schema">RTP-Unicast</Stream>` +
+		`<Transport xmlns="http://www.onvif.org/ver10/schema"><Protocol>RTSP</Protocol></Transport>` +
+		`</StreamSetup>` +
+		`<ProfileToken>` + escapeXML(profileToken) + `</ProfileToken>` +
+		`</GetStreamUri>`
+
+	err = c.call(mediaURL, body, &stream)
+	if err != nil {
+		return "", fmt.Errorf("failed to get stream uri: %v", err)
+	}
+
+	uri := strings.TrimSpace(stream.URI)
+	if uri == "" {
+		return "", fmt.Errorf("device doesn't return stream uri")
+	}
+
+	return uri, nil
+}
+
+// getProfileToken returns token of the first media profile, which usually is the
+// main stream. The same profile is used for getting stream URI and controlling PTZ.
+func (c *Client) getProfileToken() (string, error) {
+	if c.profileToken != "" {
+		return c.profileToken, nil
+	}
+
+	mediaURL, err := c.getMediaURL()
+	if err != nil {
+		return "", err
+	}
+
+	var profiles struct {
+		Profiles []struct {
+			Token string `xml:"token,attr"`
+		} `xml:"GetProfilesResponse>Profiles"`
+	}
+
+	err = c.call(mediaURL, `<GetProfiles xmlns="http://www.onvif.org/ver10/media/wsdl"/>`, &profiles)
+	if err != nil {
+		return "", fmt.Errorf("failed to get media profiles: %v", err)
+	}
+
+	if len(profiles.Profiles) == 0 {
+		return "", fmt.Errorf("device doesn't have any media profile")
+	}
+
+	c.profileToken = profiles.Profiles[0].Token
+	return c.profileToken, nil
+}
+
+// getMediaURL returns URL of the media service. If device
+// doesn't advertise it, the device service is used.
+func (c *Client) getMediaURL() (string, error) {
+	if c.mediaURL == "" {
+		if err := c.getCapabilities(); err != nil {
+			return "", err
+		}
+	}
+
+	return c.mediaURL, nil
+}
+
+// getCapabilities fetches URL of the media and PTZ service from device capabilities.
+func (c *Client) getCapabilities() error {
+	var capabilities struct {
+		MediaURL string `xml:"GetCapabilitiesResponse>Capabilities>Media>XAddr"`
+		PTZURL   string `xml:"GetCapabilitiesResponse>Capabilities>PTZ>XAddr"`
+	}
+
+	body := `<GetCapabilities xmlns="http://www.onvif.org/ver10/device/wsdl">` +
+		`<Category>All</Category>` +
+		`</GetCapabilities>`
+
+	err := c.call(c.DeviceURL, body, &capabilities)
+	if err != nil {
+		return fmt.Errorf("failed to get capabilities: %v", err)
+	}
+
+	c.mediaURL = strings.TrimSpace(capabilities.MediaURL)
+	if c.mediaURL == "" {
+		c.mediaURL = c.DeviceURL
+	}
+
+	c.ptzURL = strings.TrimSpace(capabilities.PTZURL)
+	return nil
+}
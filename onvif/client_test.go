@@ -0,0 +1,263 @@
+package onvif
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testUsername = "admin"
+	testPassword = "secret"
+)
+
+// testDevice is local HTTP stand-in of ONVIF device. It checks the WS-Security
+// username token of each request, then responds with the body that registered
+// for the path and action of request. The requests are recorded, so the test
+// can check what is sent by client.
+type testDevice struct {
+	sync.Mutex
+	server    *httptest.Server
+	responses map[string]string
+	requests  []testRequest
+}
+
+// testRequest is SOAP request received by test device.
+type testRequest struct {
+	Path   string
+	Action string
+	Body   string
+}
+
+// testEnvelope is SOAP envelope of the request sent by client.
+type testEnvelope struct {
+	Token struct {
+		Username string `xml:"Username"`
+		Password string `xml:"Password"`
+		Nonce    string `xml:"Nonce"`
+		Created  string `xml:"Created"`
+	} `xml:"Header>Security>UsernameToken"`
+	Body struct {
+		Content []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// newTestDevice starts device that responds with the registered responses, which keyed
+// by the path and action of request, e.g. "/onvif/device GetDeviceInformation".
+// Inside the response, {{URL}} is replaced by the URL of device.
+func newTestDevice(responses map[string]string) *testDevice {
+	d := &testDevice{responses: responses}
+	d.server = httptest.NewServer(http.HandlerFunc(d.serveHTTP))
+	return d
+}
+
+func (d *testDevice) URL() string {
+	return d.server.URL + "/onvif/device"
+}
+
+func (d *testDevice) Close() {
+	d.server.Close()
+}
+
+// Requests returns the recorded requests with the specified action.
+func (d *testDevice) Requests(action string) []testRequest {
+	d.Lock()
+	defer d.Unlock()
+
+	requests := []testRequest{}
+	for _, request := range d.requests {
+		if request.Action == action {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+func (d *testDevice) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	content, _ := ioutil.ReadAll(r.Body)
+
+	var env testEnvelope
+	err := xml.Unmarshal(content, &env)
+	if err != nil {
+		writeTestFault(w, http.StatusBadRequest, "env:Sender", "Request is not valid SOAP")
+		return
+	}
+
+	// Find the action, which is the first element inside body
+	action := ""
+	decoder := xml.NewDecoder(bytes.NewReader(env.Body.Content))
+	for action == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			action = start.Name.Local
+		}
+	}
+
+	d.Lock()
+	d.requests = append(d.requests, testRequest{
+		Path:   r.URL.Path,
+		Action: action,
+		Body:   string(env.Body.Content),
+	})
+	d.Unlock()
+
+	// Check password digest, i.e. Base64(SHA1(nonce + created + password))
+	nonce, _ := base64.StdEncoding.DecodeString(env.Token.Nonce)
+	hash := sha1.New()
+	hash.Write(nonce)
+	hash.Write([]byte(env.Token.Created))
+	hash.Write([]byte(testPassword))
+	digest := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+
+	if env.Token.Username != testUsername || env.Token.Password != digest {
+		writeTestFault(w, http.StatusBadRequest, "env:Sender", "Sender not Authorized")
+		return
+	}
+
+	response, exist := d.responses[r.URL.Path+" "+action]
+	if !exist {
+		writeTestFault(w, http.StatusInternalServerError, "env:Receiver", "Action "+action+" is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" `+
+		`xmlns:tds="http://www.onvif.org/ver10/device/wsdl" `+
+		`xmlns:trt="http://www.onvif.org/ver10/media/wsdl" `+
+		`xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl" `+
+		`xmlns:tt="http://www.onvif.org/ver10/schema">`+
+		`<env:Body>`+strings.Replace(response, "{{URL}}", d.server.URL, -1)+`</env:Body>`+
+		`</env:Envelope>`)
+}
+
+func writeTestFault(w http.ResponseWriter, status int, code string, reason string) {
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body>`+
+		`<env:Fault><env:Code><env:Value>%s</env:Value></env:Code>`+
+		`<env:Reason><env:Text xml:lang="en">%s</env:Text></env:Reason></env:Fault>`+
+		`</env:Body></env:Envelope>`, code, reason)
+}
+
+// testMediaResponses is the responses of device that has media service in separate path.
+var testMediaResponses = map[string]string{
+	"/onvif/device GetDeviceInformation": `<tds:GetDeviceInformationResponse>` +
+		`<tds:Manufacturer>Acme</tds:Manufacturer>` +
+		`<tds:Model>Dome 2000</tds:Model>` +
+		`<tds:FirmwareVersion>1.2.3</tds:FirmwareVersion>` +
+		`<tds:SerialNumber>SN42</tds:SerialNumber>` +
+		`<tds:HardwareId>HW1</tds:HardwareId>` +
+		`</tds:GetDeviceInformationResponse>`,
+	"/onvif/device GetCapabilities": `<tds:GetCapabilitiesResponse><tds:Capabilities>` +
+		`<tt:Media><tt:XAddr>{{URL}}/onvif/media</tt:XAddr></tt:Media>` +
+		`</tds:Capabilities></tds:GetCapabilitiesResponse>`,
+	"/onvif/media GetProfiles": `<trt:GetProfilesResponse>` +
+		`<trt:Profiles token="main" fixed="true"><tt:Name>Main</tt:Name></trt:Profiles>` +
+		`<trt:Profiles token="sub" fixed="true"><tt:Name>Sub</tt:Name></trt:Profiles>` +
+		`</trt:GetProfilesResponse>`,
+	"/onvif/media GetStreamUri": `<trt:GetStreamUriResponse><trt:MediaUri>` +
+		`<tt:Uri>rtsp://192.168.1.10:554/main</tt:Uri>` +
+		`</trt:MediaUri></trt:GetStreamUriResponse>`,
+}
+
+func TestGetDeviceInformation(t *testing.T) {
+	device := newTestDevice(testMediaResponses)
+	defer device.Close()
+
+	info, err := NewClient(device.URL(), testUsername, testPassword).GetDeviceInformation()
+	if err != nil {
+		t.Fatalf("failed to get device information: %v", err)
+	}
+
+	expected := DeviceInformation{
+		Manufacturer:    "Acme",
+		Model:           "Dome 2000",
+		FirmwareVersion: "1.2.3",
+		SerialNumber:    "SN42",
+		HardwareID:      "HW1",
+	}
+
+	if info != expected {
+		t.Errorf("got %+v, want %+v", info, expected)
+	}
+}
+
+func TestGetStreamURI(t *testing.T) {
+	device := newTestDevice(testMediaResponses)
+	defer device.Close()
+
+	uri, err := NewClient(device.URL(), testUsername, testPassword).GetStreamURI()
+	if err != nil {
+		t.Fatalf("failed to get stream uri: %v", err)
+	}
+
+	if uri != "rtsp://192.168.1.10:554/main" {
+		t.Errorf("got stream uri %s", uri)
+	}
+
+	// Stream URI must be requested from media service for the first profile
+	requests := device.Requests("GetStreamUri")
+	if len(requests) != 1 {
+		t.Fatalf("got %d GetStreamUri requests, want 1", len(requests))
+	}
+
+	if requests[0].Path != "/onvif/media" {
+		t.Errorf("GetStreamUri is sent to %s, want media service", requests[0].Path)
+	}
+
+	for _, part := range []string{"<ProfileToken>main</ProfileToken>", "RTP-Unicast", "<Protocol>RTSP</Protocol>"} {
+		if !strings.Contains(requests[0].Body, part) {
+			t.Errorf("GetStreamUri doesn't contain %s: %s", part, requests[0].Body)
+		}
+	}
+}
+
+func TestGetStreamURIWithoutMediaService(t *testing.T) {
+	// Device that doesn't advertise media service
+	// serves it in the same path as device service.
+	device := newTestDevice(map[string]string{
+		"/onvif/device GetCapabilities": `<tds:GetCapabilitiesResponse><tds:Capabilities/></tds:GetCapabilitiesResponse>`,
+		"/onvif/device GetProfiles":     `<trt:GetProfilesResponse><trt:Profiles token="profile&amp;1"/></trt:GetProfilesResponse>`,
+		"/onvif/device GetStreamUri":    `<trt:GetStreamUriResponse><trt:MediaUri><tt:Uri> rtsp://camera/stream </tt:Uri></trt:MediaUri></trt:GetStreamUriResponse>`,
+	})
+	defer device.Close()
+
+	uri, err := NewClient(device.URL(), testUsername, testPassword).GetStreamURI()
+	if err != nil {
+		t.Fatalf("failed to get stream uri: %v", err)
+	}
+
+	if uri != "rtsp://camera/stream" {
+		t.Errorf("got stream uri %q", uri)
+	}
+
+	requests := device.Requests("GetStreamUri")
+	if len(requests) != 1 || !strings.Contains(requests[0].Body, "<ProfileToken>profile&amp;1</ProfileToken>") {
+		t.Errorf("profile token is not escaped: %+v", requests)
+	}
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	device := newTestDevice(testMediaResponses)
+	defer device.Close()
+
+	_, err := NewClient(device.URL(), testUsername, "wrong").GetDeviceInformation()
+	if err == nil || !strings.Contains(err.Error(), "Sender not Authorized") {
+		t.Errorf("got error %v, want fault reason", err)
+	}
+}
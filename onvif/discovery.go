@@ -0,0 +1,142 @@
+// Package onvif is a minimal ONVIF client that finds cameras in the local
+// network using WS-Discovery, queries their device information and RTSP
+// stream URI, and controls the PTZ of camera through ONVIF web services.
+package onvif
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// DiscoveryAddress is the multicast group and port for WS-Discovery.
+var DiscoveryAddress = "239.255.255.250:3702"
+
+const maxDiscoveryMessageSize = 65536
+
+// Device is ONVIF device that responds to WS-Discovery probe.
+type Device struct {
+	// Address is the unique endpoint reference of the device, usually an UUID URN.
+	Address string
+
+	// XAddrs is the URLs of the device service.
+	XAddrs []string
+
+	// Types and Scopes are the types and the scopes that advertised by device.
+	Types  []string
+	Scopes []string
+}
+
+// probeMatches is the response of WS-Discovery probe.
+type probeMatches struct {
+	RelatesTo string `xml:"Header>RelatesTo"`
+	Matches   []struct {
+		Address string `xml:"EndpointReference>Address"`
+		Types   string `xml:"Types"`
+		Scopes  string `xml:"Scopes"`
+		XAddrs  string `xml:"XAddrs"`
+	} `xml:"Body>ProbeMatches>ProbeMatch"`
+}
+
+const probeTemplate = `<?xml version="1.0" encoding="UTF-8"?>` +
+	`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" ` +
+	`xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing" ` +
+	`xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" ` +
+	`xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+	`<e:Header>` +
+	`<w:MessageID>%s</w:MessageID>` +
+	`<w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To>` +
+	`<w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action>` +
+	`</e:Header>` +
+	`<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body>` +
+	`</e:Envelope>`
+
+// Discover sends WS-Discovery probe for network video transmitter to the
+// multicast group, then collects the devices that responded until timeout.
+func Discover(timeout time.Duration) ([]Device, error) {
+	return Probe(DiscoveryAddress, timeout)
+}
+
+// Probe sends WS-Discovery probe to the specified UDP address, then
+// collects the devices that responded until timeout. The same device
+// is only returned once, even if it responded several times.
+func Probe(address string, timeout time.Duration) ([]Device, error) {
+	dstAddr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, fmt.Errorf("discovery address is not valid: %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open discovery socket: %v", err)
+	}
+	defer conn.Close()
+
+	// Send the probe
+	messageID, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	probeID := "uuid:" + messageID.String()
+	_, err = conn.WriteToUDP([]byte(fmt.Sprintf(probeTemplate, probeID)), dstAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send discovery probe: %v", err)
+	}
+
+	// Collect the responses until timeout
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []Device{}
+	known := make(map[string]struct{})
+	buffer := make([]byte, maxDiscoveryMessageSize)
+
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			return nil, fmt.Errorf("failed to read discovery response: %v", err)
+		}
+
+		// Skip message that invalid or not responding to our probe
+		var matches probeMatches
+		if xml.Unmarshal(buffer[:n], &matches) != nil {
+			continue
+		}
+
+		if strings.TrimSpace(matches.RelatesTo) != probeID {
+			continue
+		}
+
+		for _, match := range matches.Matches {
+			xAddrs := strings.Fields(match.XAddrs)
+			address := strings.TrimSpace(match.Address)
+			if address == "" && len(xAddrs) > 0 {
+				address = xAddrs[0]
+			}
+
+			if _, exist := known[address]; exist || len(xAddrs) == 0 {
+				continue
+			}
+			known[address] = struct{}{}
+
+			devices = append(devices, Device{
+				Address: address,
+				XAddrs:  xAddrs,
+				Types:   strings.Fields(match.Types),
+				Scopes:  strings.Fields(match.Scopes),
+			})
+		}
+	}
+
+	return devices, nil
+}
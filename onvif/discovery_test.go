@@ -0,0 +1,136 @@
+package onvif
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testResponder is local UDP stand-in of the devices in multicast group. For each probe,
+// it sends the responses returned by reply, which receives the message ID of probe.
+type testResponder struct {
+	conn   *net.UDPConn
+	probes chan string
+}
+
+func newTestResponder(t *testing.T, reply func(probeID string) []string) *testResponder {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	r := &testResponder{conn: conn, probes: make(chan string, 10)}
+	go func() {
+		buffer := make([]byte, maxDiscoveryMessageSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			var probe struct {
+				MessageID string `xml:"Header>MessageID"`
+				Types     string `xml:"Body>Probe>Types"`
+			}
+
+			if xml.Unmarshal(buffer[:n], &probe) != nil || probe.Types != "dn:NetworkVideoTransmitter" {
+				t.Errorf("probe is not valid: %s", buffer[:n])
+				continue
+			}
+
+			r.probes <- probe.MessageID
+			for _, response := range reply(probe.MessageID) {
+				conn.WriteToUDP([]byte(response), addr)
+			}
+		}
+	}()
+
+	return r
+}
+
+func (r *testResponder) Address() string {
+	return r.conn.LocalAddr().String()
+}
+
+func (r *testResponder) Close() {
+	r.conn.Close()
+}
+
+// testProbeMatch returns ProbeMatches message for a device, as response of the specified probe.
+func testProbeMatch(probeID, address, xAddrs string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>`+
+		`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" `+
+		`xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" `+
+		`xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">`+
+		`<SOAP-ENV:Header><wsa:RelatesTo>%s</wsa:RelatesTo></SOAP-ENV:Header>`+
+		`<SOAP-ENV:Body><d:ProbeMatches><d:ProbeMatch>`+
+		`<wsa:EndpointReference><wsa:Address>%s</wsa:Address></wsa:EndpointReference>`+
+		`<d:Types>dn:NetworkVideoTransmitter tds:Device</d:Types>`+
+		`<d:Scopes>onvif://www.onvif.org/name/Camera onvif://www.onvif.org/location/Gate</d:Scopes>`+
+		`<d:XAddrs>%s</d:XAddrs>`+
+		`</d:ProbeMatch></d:ProbeMatches></SOAP-ENV:Body>`+
+		`</SOAP-ENV:Envelope>`, probeID, address, xAddrs)
+}
+
+func TestProbe(t *testing.T) {
+	responder := newTestResponder(t, func(probeID string) []string {
+		return []string{
+			// Response for the other probe, and message that is not valid
+			testProbeMatch("uuid:other-probe", "urn:uuid:other", "http://192.168.1.20/onvif/device_service"),
+			"<Envelope>",
+
+			// The first device responds twice, while the second one doesn't have
+			// endpoint address. Device without XAddrs can't be used, so it's skipped.
+			testProbeMatch(probeID, "urn:uuid:camera-1", "http://192.168.1.10/onvif/device_service http://[fe80::1]/onvif/device_service"),
+			testProbeMatch(probeID, "urn:uuid:camera-1", "http://192.168.1.10/onvif/device_service"),
+			testProbeMatch(probeID, "", "http://192.168.1.11/onvif/device_service"),
+			testProbeMatch(probeID, "urn:uuid:camera-3", ""),
+		}
+	})
+	defer responder.Close()
+
+	devices, err := Probe(responder.Address(), 500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to probe: %v", err)
+	}
+
+	expected := []Device{{
+		Address: "urn:uuid:camera-1",
+		XAddrs:  []string{"http://192.168.1.10/onvif/device_service", "http://[fe80::1]/onvif/device_service"},
+		Types:   []string{"dn:NetworkVideoTransmitter", "tds:Device"},
+		Scopes:  []string{"onvif://www.onvif.org/name/Camera", "onvif://www.onvif.org/location/Gate"},
+	}, {
+		Address: "http://192.168.1.11/onvif/device_service",
+		XAddrs:  []string{"http://192.168.1.11/onvif/device_service"},
+		Types:   []string{"dn:NetworkVideoTransmitter", "tds:Device"},
+		Scopes:  []string{"onvif://www.onvif.org/name/Camera", "onvif://www.onvif.org/location/Gate"},
+	}}
+
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("got devices %+v, want %+v", devices, expected)
+	}
+}
+
+func TestProbeUniqueMessageID(t *testing.T) {
+	responder := newTestResponder(t, func(string) []string { return nil })
+	defer responder.Close()
+
+	for i := 0; i < 2; i++ {
+		devices, err := Probe(responder.Address(), 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("failed to probe: %v", err)
+		}
+
+		if len(devices) != 0 {
+			t.Fatalf("got %d devices without any response", len(devices))
+		}
+	}
+
+	first, second := <-responder.probes, <-responder.probes
+	if first == "" || first == second {
+		t.Errorf("probes must have unique message ID, got %q and %q", first, second)
+	}
+}
@@ -0,0 +1,142 @@
+package onvif
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Vector is the position, translation or velocity of PTZ. Axis that nil is
+// not sent, so it's not moved. The value is usually within the generic space
+// of ONVIF, i.e. -1 to 1 for pan and tilt, and 0 to 1 for zoom position.
+type Vector struct {
+	PanTilt *PanTilt
+	Zoom    *float64
+}
+
+// PanTilt is the pan (x) and tilt (y) component of PTZ vector.
+type PanTilt struct {
+	X float64
+	Y float64
+}
+
+// Preset is saved PTZ position of camera.
+type Preset struct {
+	Token string `json:"token"`
+	Name  string `json:"name"`
+}
+
+// ContinuousMove moves the camera with the specified velocity until Stop
+// is called or until timeout. If timeout is zero, the camera's default is used.
+func (c *Client) ContinuousMove(velocity Vector, timeout time.Duration) error {
+	body := "<Velocity>" + velocity.xml() + "</Velocity>"
+	if timeout > 0 {
+		body += fmt.Sprintf("<Timeout>PT%gS</Timeout>", timeout.Seconds())
+	}
+
+	return c.callPTZ("ContinuousMove", body, nil)
+}
+
+// Stop stops the ongoing pan, tilt and zoom movement of camera.
+func (c *Client) Stop() error {
+	return c.callPTZ("Stop", "<PanTilt>true</PanTilt><Zoom>true</Zoom>", nil)
+}
+
+// AbsoluteMove moves the camera to the specified position.
+func (c *Client) AbsoluteMove(position Vector) error {
+	return c.callPTZ("AbsoluteMove", "<Position>"+position.xml()+"</Position>", nil)
+}
+
+// RelativeMove moves the camera by the specified translation from its current position.
+func (c *Client) RelativeMove(translation Vector) error {
+	return c.callPTZ("RelativeMove", "<Translation>"+translation.xml()+"</Translation>", nil)
+}
+
+// GetPresets returns the saved positions of camera.
+func (c *Client) GetPresets() ([]Preset, error) {
+	var response struct {
+		Presets []struct {
+			Token string `xml:"token,attr"`
+			Name  string `xml:"Name"`
+		} `xml:"GetPresetsResponse>Preset"`
+	}
+
+	err := c.callPTZ("GetPresets", "", &response)
+	if err != nil {
+		return nil, err
+	}
+
+	presets := []Preset{}
+	for _, preset := range response.Presets {
+		presets = append(presets, Preset{
+			Token: preset.Token,
+			Name:  strings.TrimSpace(preset.Name),
+		})
+	}
+
+	return presets, nil
+}
+
+// GotoPreset moves the camera to the saved position.
+func (c *Client) GotoPreset(token string) error {
+	return c.callPTZ("GotoPreset", "<PresetToken>"+escapeXML(token)+"</PresetToken>", nil)
+}
+
+// SetPreset saves the current position of camera as preset with the specified name.
+// If token is not empty, the existing preset is replaced. It returns the token of preset.
+func (c *Client) SetPreset(name, token string) (string, error) {
+	body := "<PresetName>" + escapeXML(name) + "</PresetName>"
+	if token != "" {
+		body += "<PresetToken>" + escapeXML(token) + "</PresetToken>"
+	}
+
+	var response struct {
+		Token string `xml:"SetPresetResponse>PresetToken"`
+	}
+
+	err := c.callPTZ("SetPreset", body, &response)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(response.Token), nil
+}
+
+// callPTZ calls the method of PTZ service for the first media profile.
+func (c *Client) callPTZ(method string, params string, result interface{}) error {
+	profileToken, err := c.getProfileToken()
+	if err != nil {
+		return err
+	}
+
+	if c.ptzURL == "" {
+		return fmt.Errorf("device doesn't support PTZ")
+	}
+
+	body := `<` + method + ` xmlns="http://www.onvif.org/ver20/ptz/wsdl">` +
+		`<ProfileToken>` + escapeXML(profileToken) + `</ProfileToken>` +
+		params +
+		`</` + method + `>`
+
+	err = c.call(c.ptzURL, body, result)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %v", method, err)
+	}
+
+	return nil
+}
+
+// xml returns the vector as ONVIF PTZVector or PTZSpeed elements.
+func (v Vector) xml() string {
+	sb := strings.Builder{}
+	if v.PanTilt != nil {
+		sb.WriteString(fmt.Sprintf(`<PanTilt xmlns="http://www.onvif.org/ver10/schema" x="%g" y="%g"/>`,
+			v.PanTilt.X, v.PanTilt.Y))
+	}
+
+	if v.Zoom != nil {
+		sb.WriteString(fmt.Sprintf(`<Zoom xmlns="http://www.onvif.org/ver10/schema" x="%g"/>`, *v.Zoom))
+	}
+
+	return sb.String()
+}
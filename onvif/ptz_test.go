@@ -0,0 +1,176 @@
+package onvif
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPTZResponses is the responses of device that has media and PTZ service.
+var testPTZResponses = map[string]string{
+	"/onvif/device GetCapabilities": `<tds:GetCapabilitiesResponse><tds:Capabilities>` +
+		`<tt:Media><tt:XAddr>{{URL}}/onvif/media</tt:XAddr></tt:Media>` +
+		`<tt:PTZ><tt:XAddr>{{URL}}/onvif/ptz</tt:XAddr></tt:PTZ>` +
+		`</tds:Capabilities></tds:GetCapabilitiesResponse>`,
+	"/onvif/media GetProfiles": `<trt:GetProfilesResponse>` +
+		`<trt:Profiles token="main" fixed="true"/>` +
+		`<trt:Profiles token="sub" fixed="true"/>` +
+		`</trt:GetProfilesResponse>`,
+	"/onvif/ptz ContinuousMove": `<tptz:ContinuousMoveResponse/>`,
+	"/onvif/ptz Stop":           `<tptz:StopResponse/>`,
+	"/onvif/ptz AbsoluteMove":   `<tptz:AbsoluteMoveResponse/>`,
+	"/onvif/ptz RelativeMove":   `<tptz:RelativeMoveResponse/>`,
+	"/onvif/ptz GotoPreset":     `<tptz:GotoPresetResponse/>`,
+	"/onvif/ptz GetPresets": `<tptz:GetPresetsResponse>` +
+		`<tptz:Preset token="1"><tt:Name> Front Door </tt:Name></tptz:Preset>` +
+		`<tptz:Preset token="2"><tt:Name>Gate</tt:Name></tptz:Preset>` +
+		`</tptz:GetPresetsResponse>`,
+	"/onvif/ptz SetPreset": `<tptz:SetPresetResponse><tptz:PresetToken>3</tptz:PresetToken></tptz:SetPresetResponse>`,
+}
+
+func TestPTZMove(t *testing.T) {
+	zoom := 0.5
+	tests := []struct {
+		action string
+		call   func(c *Client) error
+		parts  []string
+	}{{
+		action: "ContinuousMove",
+		call: func(c *Client) error {
+			return c.ContinuousMove(Vector{PanTilt: &PanTilt{X: -0.3, Y: 1}, Zoom: &zoom}, 1500*time.Millisecond)
+		},
+		parts: []string{
+			`<Velocity><PanTilt xmlns="http://www.onvif.org/ver10/schema" x="-0.3" y="1"/>` +
+				`<Zoom xmlns="http://www.onvif.org/ver10/schema" x="0.5"/></Velocity>`,
+			`<Timeout>PT1.5S</Timeout>`,
+		},
+	}, {
+		action: "Stop",
+		call:   func(c *Client) error { return c.Stop() },
+		parts:  []string{`<PanTilt>true</PanTilt><Zoom>true</Zoom>`},
+	}, {
+		action: "AbsoluteMove",
+		call:   func(c *Client) error { return c.AbsoluteMove(Vector{Zoom: &zoom}) },
+		parts:  []string{`<Position><Zoom xmlns="http://www.onvif.org/ver10/schema" x="0.5"/></Position>`},
+	}, {
+		action: "RelativeMove",
+		call:   func(c *Client) error { return c.RelativeMove(Vector{PanTilt: &PanTilt{X: 0.1}}) },
+		parts:  []string{`<Translation><PanTilt xmlns="http://www.onvif.org/ver10/schema" x="0.1" y="0"/></Translation>`},
+	}, {
+		action: "GotoPreset",
+		call:   func(c *Client) error { return c.GotoPreset("1") },
+		parts:  []string{`<PresetToken>1</PresetToken>`},
+	}}
+
+	device := newTestDevice(testPTZResponses)
+	defer device.Close()
+
+	client := NewClient(device.URL(), testUsername, testPassword)
+	for _, tt := range tests {
+		err := tt.call(client)
+		if err != nil {
+			t.Errorf("%s failed: %v", tt.action, err)
+			continue
+		}
+
+		requests := device.Requests(tt.action)
+		if len(requests) != 1 {
+			t.Errorf("got %d %s requests, want 1", len(requests), tt.action)
+			continue
+		}
+
+		// Request must be sent to PTZ service, for the first media profile
+		request := requests[0]
+		if request.Path != "/onvif/ptz" {
+			t.Errorf("%s is sent to %s, want PTZ service", tt.action, request.Path)
+		}
+
+		parts := append([]string{`<ProfileToken>main</ProfileToken>`}, tt.parts...)
+		for _, part := range parts {
+			if !strings.Contains(request.Body, part) {
+				t.Errorf("%s doesn't contain %s: %s", tt.action, part, request.Body)
+			}
+		}
+	}
+
+	// Capabilities and profiles are only fetched once by client
+	if n := len(device.Requests("GetCapabilities")); n != 1 {
+		t.Errorf("got %d GetCapabilities requests, want 1", n)
+	}
+
+	if n := len(device.Requests("GetProfiles")); n != 1 {
+		t.Errorf("got %d GetProfiles requests, want 1", n)
+	}
+}
+
+func TestPTZPresets(t *testing.T) {
+	device := newTestDevice(testPTZResponses)
+	defer device.Close()
+
+	client := NewClient(device.URL(), testUsername, testPassword)
+	presets, err := client.GetPresets()
+	if err != nil {
+		t.Fatalf("failed to get presets: %v", err)
+	}
+
+	expected := []Preset{{Token: "1", Name: "Front Door"}, {Token: "2", Name: "Gate"}}
+	if !reflect.DeepEqual(presets, expected) {
+		t.Errorf("got presets %+v, want %+v", presets, expected)
+	}
+
+	token, err := client.SetPreset("Yard & Garden", "")
+	if err != nil {
+		t.Fatalf("failed to set preset: %v", err)
+	}
+
+	if token != "3" {
+		t.Errorf("got preset token %s, want 3", token)
+	}
+
+	// Preset name must be escaped, and the token is only sent when replacing preset
+	_, err = client.SetPreset("Gate", "2")
+	if err != nil {
+		t.Fatalf("failed to replace preset: %v", err)
+	}
+
+	requests := device.Requests("SetPreset")
+	if len(requests) != 2 {
+		t.Fatalf("got %d SetPreset requests, want 2", len(requests))
+	}
+
+	if body := requests[0].Body; !strings.Contains(body, "<PresetName>Yard &amp; Garden</PresetName>") || strings.Contains(body, "<PresetToken>") {
+		t.Errorf("new preset is not valid: %s", body)
+	}
+
+	if body := requests[1].Body; !strings.Contains(body, "<PresetName>Gate</PresetName><PresetToken>2</PresetToken>") {
+		t.Errorf("replaced preset is not valid: %s", body)
+	}
+}
+
+func TestPTZNotSupported(t *testing.T) {
+	device := newTestDevice(testMediaResponses)
+	defer device.Close()
+
+	err := NewClient(device.URL(), testUsername, testPassword).Stop()
+	if err == nil || !strings.Contains(err.Error(), "doesn't support PTZ") {
+		t.Errorf("got error %v, want PTZ is not supported", err)
+	}
+}
+
+func TestPTZFault(t *testing.T) {
+	responses := map[string]string{}
+	for key, response := range testPTZResponses {
+		if key != "/onvif/ptz GotoPreset" {
+			responses[key] = response
+		}
+	}
+
+	device := newTestDevice(responses)
+	defer device.Close()
+
+	err := NewClient(device.URL(), testUsername, testPassword).GotoPreset("9")
+	if err == nil || err.Error() != "failed to call GotoPreset: Action GotoPreset is not supported" {
+		t.Errorf("got error %v, want fault reason", err)
+	}
+}
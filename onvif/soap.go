@@ -0,0 +1,132 @@
+package onvif
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	requestTimeout  = 10 * time.Second
+	maxResponseSize = 1 << 20
+)
+
+// envelope is the SOAP envelope of ONVIF response. Body is decoded later
+// into the expected response, while fault is checked for error.
+type envelope struct {
+	Body struct {
+		Content []byte `xml:",innerxml"`
+		Fault   *struct {
+			Code   string `xml:"Code>Value"`
+			Reason string `xml:"Reason>Text"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// call sends SOAP request that contains body to the service URL, then decodes the
+// response body into result. If username is set, the request is authenticated
+// using WS-Security username token with password digest.
+func (c *Client) call(serviceURL string, body string, result interface{}) error {
+	sb := strings.Builder{}
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	sb.WriteString(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">`)
+	if c.Username != "" {
+		sb.WriteString(`<s:Header>`)
+		sb.WriteString(securityHeader(c.Username, c.Password, time.Now()))
+		sb.WriteString(`</s:Header>`)
+	}
+	sb.WriteString(`<s:Body>`)
+	sb.WriteString(body)
+	sb.WriteString(`</s:Body></s:Envelope>`)
+
+	req, err := http.NewRequest("POST", serviceURL, strings.NewReader(sb.String()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: requestTimeout}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	// ONVIF error is returned as SOAP fault, usually with status 400 or 500
+	var env envelope
+	err = xml.Unmarshal(content, &env)
+	if err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s", resp.Status)
+		}
+		return fmt.Errorf("response is not valid SOAP: %v", err)
+	}
+
+	if fault := env.Body.Fault; fault != nil {
+		reason := strings.TrimSpace(fault.Reason)
+		if reason == "" {
+			reason = strings.TrimSpace(fault.Code)
+		}
+		return fmt.Errorf("%s", reason)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	// Wrap the body content, so the result is decoded from its root element
+	content = append([]byte("<Body>"), env.Body.Content...)
+	content = append(content, []byte("</Body>")...)
+	return xml.NewDecoder(bytes.NewReader(content)).Decode(result)
+}
+
+// securityHeader returns WS-Security header with username token, which
+// password digest is Base64(SHA1(nonce + created + password)).
+func securityHeader(username, password string, created time.Time) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	strCreated := created.UTC().Format("2006-01-02T15:04:05.000Z")
+	hash := sha1.New()
+	hash.Write(nonce)
+	hash.Write([]byte(strCreated))
+	hash.Write([]byte(password))
+	digest := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+
+	return `<Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+		`<UsernameToken>` +
+		`<Username>` + escapeXML(username) + `</Username>` +
+		`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` + digest + `</Password>` +
+		`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` +
+		base64.StdEncoding.EncodeToString(nonce) + `</Nonce>` +
+		`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` + strCreated + `</Created>` +
+		`</UsernameToken>` +
+		`</Security>`
+}
+
+func escapeXML(s string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(s))
+	return buffer.String()
+}
@@ -0,0 +1,98 @@
+package onvif
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeader(t *testing.T) {
+	created := time.Date(2026, 10, 19, 8, 30, 15, 123456789, time.FixedZone("WIB", 7*60*60))
+	header := `<s:Header xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+		securityHeader("admin<1>", "secret", created) +
+		`</s:Header>`
+
+	var token struct {
+		Username string `xml:"Security>UsernameToken>Username"`
+		Password string `xml:"Security>UsernameToken>Password"`
+		Nonce    string `xml:"Security>UsernameToken>Nonce"`
+		Created  string `xml:"Security>UsernameToken>Created"`
+	}
+
+	err := xml.Unmarshal([]byte(header), &token)
+	if err != nil {
+		t.Fatalf("security header is not valid XML: %v", err)
+	}
+
+	if token.Username != "admin<1>" {
+		t.Errorf("got username %q", token.Username)
+	}
+
+	// Created time must be in UTC
+	if token.Created != "2026-10-19T01:30:15.123Z" {
+		t.Errorf("got created time %s", token.Created)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(token.Nonce)
+	if err != nil || len(nonce) != 16 {
+		t.Fatalf("nonce is not valid: %q", token.Nonce)
+	}
+
+	hash := sha1.New()
+	hash.Write(nonce)
+	hash.Write([]byte(token.Created))
+	hash.Write([]byte("secret"))
+	if digest := base64.StdEncoding.EncodeToString(hash.Sum(nil)); token.Password != digest {
+		t.Errorf("got password digest %s, want %s", token.Password, digest)
+	}
+
+	// Nonce must be random for each header
+	var other struct {
+		Nonce string `xml:"Security>UsernameToken>Nonce"`
+	}
+
+	xml.Unmarshal([]byte(`<s:Header xmlns:s="http://www.w3.org/2003/05/soap-envelope">`+
+		securityHeader("admin", "secret", created)+`</s:Header>`), &other)
+	if other.Nonce == token.Nonce {
+		t.Error("nonce is reused")
+	}
+}
+
+func TestCallError(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		expected string
+	}{
+		{"fault without reason", http.StatusInternalServerError,
+			`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body>` +
+				`<env:Fault><env:Code><env:Value>env:Receiver</env:Value></env:Code></env:Fault>` +
+				`</env:Body></env:Envelope>`,
+			"env:Receiver"},
+		{"error without SOAP", http.StatusUnauthorized, "Unauthorized", "401 Unauthorized"},
+		{"success without SOAP", http.StatusOK, "OK", "response is not valid SOAP: EOF"},
+		{"error with empty body", http.StatusServiceUnavailable,
+			`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body/></env:Envelope>`,
+			"503 Service Unavailable"},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.response)
+		}))
+
+		err := NewClient(server.URL, "", "").call(server.URL, `<GetSystemDateAndTime/>`, nil)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: got error %v, want %s", tt.name, err, tt.expected)
+		}
+
+		server.Close()
+	}
+}
@@ -0,0 +1,230 @@
+package remux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	aviHeaderSize      = 224
+	aviFlagHasIndex    = 0x10
+	aviFlagKeyframe    = 0x10
+	aviDefaultFPS      = 10
+	aviMaxChunkSize    = 1<<31 - 1
+	aviMicrosPerSecond = 1000000
+)
+
+// AVIWriter writes JPEG frames as Motion-JPEG AVI. Since AVI uses constant frame
+// rate, the frame rate is averaged from timestamp of the first and the last frame.
+type AVIWriter struct {
+	w          io.WriteSeeker
+	width      int
+	height     int
+	maxSize    int
+	moviSize   int64
+	index      []aviIndexEntry
+	firstFrame time.Duration
+	lastFrame  time.Duration
+}
+
+// aviIndexEntry is position of a frame inside movi list.
+type aviIndexEntry struct {
+	offset uint32
+	size   uint32
+}
+
+// NewAVIWriter returns a new writer that writes AVI into w.
+func NewAVIWriter(w io.WriteSeeker) *AVIWriter {
+	return &AVIWriter{w: w}
+}
+
+// WriteFrame writes a single JPEG frame. Timestamp is the time of frame
+// since any reference, which only used to calculate the frame rate.
+func (a *AVIWriter) WriteFrame(jpeg []byte, timestamp time.Duration) error {
+	// In the first frame, write header placeholder. The actual
+	// header will be written once all frames has been written.
+	if len(a.index) == 0 {
+		width, height, err := jpegSize(jpeg)
+		if err != nil {
+			return err
+		}
+
+		a.width, a.height = width, height
+		a.firstFrame = timestamp
+
+		if _, err = a.w.Write(make([]byte, aviHeaderSize)); err != nil {
+			return err
+		}
+	}
+
+	// Write frame as chunk, which must be padded to even size
+	chunk := append([]byte("00dc"), le32(uint32(len(jpeg)))...)
+	chunk = append(chunk, jpeg...)
+	if len(jpeg)%2 != 0 {
+		chunk = append(chunk, 0)
+	}
+
+	if a.moviSize+int64(len(chunk)) > aviMaxChunkSize {
+		return fmt.Errorf("avi file is too large")
+	}
+
+	if _, err := a.w.Write(chunk); err != nil {
+		return err
+	}
+
+	a.index = append(a.index, aviIndexEntry{
+		offset: uint32(4 + a.moviSize),
+		size:   uint32(len(jpeg)),
+	})
+
+	a.moviSize += int64(len(chunk))
+	a.lastFrame = timestamp
+	if len(jpeg) > a.maxSize {
+		a.maxSize = len(jpeg)
+	}
+
+	return nil
+}
+
+// Close writes index and the actual header. It doesn't close the underlying writer.
+func (a *AVIWriter) Close() error {
+	if len(a.index) == 0 {
+		return fmt.Errorf("there are no frames written")
+	}
+
+	// Write index
+	idx1 := append([]byte("idx1"), le32(uint32(len(a.index)*16))...)
+	for _, entry := range a.index {
+		idx1 = append(idx1, []byte("00dc")...)
+		idx1 = append(idx1, le32(aviFlagKeyframe)...)
+		idx1 = append(idx1, le32(entry.offset)...)
+		idx1 = append(idx1, le32(entry.size)...)
+	}
+
+	if _, err := a.w.Write(idx1); err != nil {
+		return err
+	}
+
+	// Calculate frame duration
+	nFrames := len(a.index)
+	microsPerFrame := aviMicrosPerSecond / aviDefaultFPS
+	if elapsed := a.lastFrame - a.firstFrame; nFrames > 1 && elapsed > 0 {
+		microsPerFrame = int(elapsed / time.Microsecond / time.Duration(nFrames-1))
+	}
+
+	if microsPerFrame <= 0 {
+		microsPerFrame = 1
+	}
+
+	// Write the actual header
+	fileSize := aviHeaderSize + a.moviSize + int64(len(idx1))
+	header := []byte("RIFF")
+	header = append(header, le32(uint32(fileSize-8))...)
+	header = append(header, []byte("AVI ")...)
+
+	header = append(header, []byte("LIST")...)
+	header = append(header, le32(192)...)
+	header = append(header, []byte("hdrl")...)
+
+	// Main header
+	header = append(header, []byte("avih")...)
+	header = append(header, le32(56)...)
+	header = append(header, le32(uint32(microsPerFrame))...)
+	header = append(header, le32(0)...) // max bytes per second
+	header = append(header, le32(0)...) // padding granularity
+	header = append(header, le32(aviFlagHasIndex)...)
+	header = append(header, le32(uint32(nFrames))...)
+	header = append(header, le32(0)...) // initial frames
+	header = append(header, le32(1)...) // streams
+	header = append(header, le32(uint32(a.maxSize))...)
+	header = append(header, le32(uint32(a.width))...)
+	header = append(header, le32(uint32(a.height))...)
+	header = append(header, make([]byte, 16)...)
+
+	header = append(header, []byte("LIST")...)
+	header = append(header, le32(116)...)
+	header = append(header, []byte("strl")...)
+
+	// Stream header
+	header = append(header, []byte("strh")...)
+	header = append(header, le32(56)...)
+	header = append(header, []byte("vidsMJPG")...)
+	header = append(header, le32(0)...) // flags
+	header = append(header, le32(0)...) // priority and language
+	header = append(header, le32(0)...) // initial frames
+	header = append(header, le32(uint32(microsPerFrame))...)
+	header = append(header, le32(aviMicrosPerSecond)...)
+	header = append(header, le32(0)...) // start
+	header = append(header, le32(uint32(nFrames))...)
+	header = append(header, le32(uint32(a.maxSize))...)
+	header = append(header, le32(0xFFFFFFFF)...) // quality
+	header = append(header, le32(0)...)          // sample size
+	header = append(header, 0, 0, 0, 0)
+	header = append(header, byte(a.width), byte(a.width>>8), byte(a.height), byte(a.height>>8))
+
+	// Stream format, which is BITMAPINFOHEADER
+	header = append(header, []byte("strf")...)
+	header = append(header, le32(40)...)
+	header = append(header, le32(40)...)
+	header = append(header, le32(uint32(a.width))...)
+	header = append(header, le32(uint32(a.height))...)
+	header = append(header, 1, 0, 24, 0) // planes and bit count
+	header = append(header, []byte("MJPG")...)
+	header = append(header, le32(uint32(a.width*a.height*3))...)
+	header = append(header, make([]byte, 16)...)
+
+	header = append(header, []byte("LIST")...)
+	header = append(header, le32(uint32(4+a.moviSize))...)
+	header = append(header, []byte("movi")...)
+
+	if _, err := a.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := a.w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := a.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// jpegSize reads width and height from SOF marker of JPEG image.
+func jpegSize(jpeg []byte) (width, height int, err error) {
+	if len(jpeg) < 4 || jpeg[0] != 0xFF || jpeg[1] != 0xD8 {
+		return 0, 0, fmt.Errorf("frame is not a JPEG image")
+	}
+
+	for i := 2; i+9 < len(jpeg); {
+		if jpeg[i] != 0xFF {
+			i++
+			continue
+		}
+
+		marker := jpeg[i+1]
+		switch {
+		case marker == 0xFF:
+			i++
+			continue
+		case marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			i += 2
+			continue
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			height = int(binary.BigEndian.Uint16(jpeg[i+5:]))
+			width = int(binary.BigEndian.Uint16(jpeg[i+7:]))
+			return width, height, nil
+		}
+
+		i += 2 + int(binary.BigEndian.Uint16(jpeg[i+2:]))
+	}
+
+	return 0, 0, fmt.Errorf("size of JPEG image is not found")
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
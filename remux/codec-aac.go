@@ -0,0 +1,70 @@
+package remux
+
+import (
+	"fmt"
+)
+
+const aacSamplesPerFrame = 1024
+
+var aacSampleRates = []int{
+	96000, 88200, 64000, 48000, 44100, 32000,
+	24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// adtsFrame is a single AAC frame inside ADTS stream.
+type adtsFrame struct {
+	ObjectType      int
+	SampleRateIndex int
+	ChannelConfig   int
+	Data            []byte
+}
+
+// SampleRate returns the sampling frequency of this frame.
+func (f adtsFrame) SampleRate() int {
+	if f.SampleRateIndex >= len(aacSampleRates) {
+		return 0
+	}
+
+	return aacSampleRates[f.SampleRateIndex]
+}
+
+// AudioSpecificConfig returns the decoder config used in esds box.
+func (f adtsFrame) AudioSpecificConfig() []byte {
+	return []byte{
+		byte(f.ObjectType<<3) | byte(f.SampleRateIndex>>1),
+		byte(f.SampleRateIndex<<7) | byte(f.ChannelConfig<<3),
+	}
+}
+
+// parseADTS splits ADTS stream into raw AAC frames.
+func parseADTS(data []byte) ([]adtsFrame, error) {
+	frames := []adtsFrame{}
+
+	for len(data) > 0 {
+		if len(data) < 7 || data[0] != 0xFF || data[1]&0xF0 != 0xF0 {
+			return frames, fmt.Errorf("ADTS header is not valid")
+		}
+
+		protectionAbsent := data[1] & 0x01
+		headerLength := 7
+		if protectionAbsent == 0 {
+			headerLength = 9
+		}
+
+		frameLength := int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5]>>5)
+		if frameLength < headerLength || frameLength > len(data) {
+			return frames, fmt.Errorf("ADTS frame length is not valid")
+		}
+
+		frames = append(frames, adtsFrame{
+			ObjectType:      int(data[2]>>6) + 1,
+			SampleRateIndex: int(data[2]>>2) & 0x0F,
+			ChannelConfig:   int(data[2]&0x01)<<2 | int(data[3]>>6),
+			Data:            data[headerLength:frameLength],
+		})
+
+		data = data[frameLength:]
+	}
+
+	return frames, nil
+}
@@ -0,0 +1,142 @@
+package remux
+
+import (
+	"fmt"
+)
+
+// H.264 NAL unit types
+const (
+	h264NALSlice = 1
+	h264NALIDR   = 5
+	h264NALSEI   = 6
+	h264NALSPS   = 7
+	h264NALPPS   = 8
+	h264NALAUD   = 9
+)
+
+func h264IsKeyframe(data []byte) bool {
+	for _, nalu := range splitAnnexB(data) {
+		if len(nalu) > 0 && nalu[0]&0x1F == h264NALIDR {
+			return true
+		}
+	}
+
+	return false
+}
+
+// h264ParseSPS returns the picture size that described in SPS.
+func h264ParseSPS(sps []byte) (width, height int, err error) {
+	if len(sps) < 4 {
+		return 0, 0, fmt.Errorf("SPS is too short")
+	}
+
+	b := &bitReader{data: removeEmulationPrevention(sps[1:])}
+	profileIDC, _ := b.readBits(8)
+	b.skipBits(16) // constraint flags and level
+	b.readUE()     // seq_parameter_set_id
+
+	chromaFormatIDC := uint(1)
+	separateColourPlane := uint(0)
+	switch profileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIDC, _ = b.readUE()
+		if chromaFormatIDC == 3 {
+			separateColourPlane, _ = b.readBit()
+		}
+
+		b.readUE()  // bit_depth_luma_minus8
+		b.readUE()  // bit_depth_chroma_minus8
+		b.readBit() // qpprime_y_zero_transform_bypass_flag
+
+		scalingMatrixPresent, _ := b.readBit()
+		if scalingMatrixPresent == 1 {
+			nLists := 8
+			if chromaFormatIDC == 3 {
+				nLists = 12
+			}
+
+			for i := 0; i < nLists; i++ {
+				listPresent, _ := b.readBit()
+				if listPresent == 0 {
+					continue
+				}
+
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+
+				lastScale, nextScale := 8, 8
+				for j := 0; j < size; j++ {
+					if nextScale != 0 {
+						delta, _ := b.readSE()
+						nextScale = (lastScale + delta + 256) % 256
+					}
+
+					if nextScale != 0 {
+						lastScale = nextScale
+					}
+				}
+			}
+		}
+	}
+
+	b.readUE() // log2_max_frame_num_minus4
+
+	picOrderCntType, _ := b.readUE()
+	switch picOrderCntType {
+	case 0:
+		b.readUE() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		b.readBit() // delta_pic_order_always_zero_flag
+		b.readSE()  // offset_for_non_ref_pic
+		b.readSE()  // offset_for_top_to_bottom_field
+		nRefFrames, _ := b.readUE()
+		for i := uint(0); i < nRefFrames; i++ {
+			b.readSE()
+		}
+	}
+
+	b.readUE()  // max_num_ref_frames
+	b.readBit() // gaps_in_frame_num_value_allowed_flag
+
+	widthInMbs, _ := b.readUE()
+	heightInMapUnits, _ := b.readUE()
+	frameMbsOnly, err := b.readBit()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse SPS: %v", err)
+	}
+
+	if frameMbsOnly == 0 {
+		b.readBit() // mb_adaptive_frame_field_flag
+	}
+	b.readBit() // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	frameCropping, _ := b.readBit()
+	if frameCropping == 1 {
+		cropLeft, _ = b.readUE()
+		cropRight, _ = b.readUE()
+		cropTop, _ = b.readUE()
+		cropBottom, _ = b.readUE()
+	}
+
+	// Calculate crop unit based on chroma format
+	cropUnitX, cropUnitY := uint(1), 2-frameMbsOnly
+	if chromaFormatIDC != 0 && separateColourPlane == 0 {
+		subWidthC, subHeightC := uint(2), uint(2)
+		switch chromaFormatIDC {
+		case 2:
+			subHeightC = 1
+		case 3:
+			subWidthC, subHeightC = 1, 1
+		}
+
+		cropUnitX = subWidthC
+		cropUnitY = subHeightC * (2 - frameMbsOnly)
+	}
+
+	width = int((widthInMbs+1)*16 - (cropLeft+cropRight)*cropUnitX)
+	height = int((2-frameMbsOnly)*(heightInMapUnits+1)*16 - (cropTop+cropBottom)*cropUnitY)
+	return width, height, nil
+}
@@ -0,0 +1,126 @@
+package remux
+
+import (
+	"fmt"
+)
+
+// H.265 NAL unit types
+const (
+	h265NALIDRWRADL = 19
+	h265NALIDRNLP   = 20
+	h265NALCRA      = 21
+	h265NALVPS      = 32
+	h265NALSPS      = 33
+	h265NALPPS      = 34
+	h265NALAUD      = 35
+)
+
+func h265NALType(nalu []byte) int {
+	return int(nalu[0]>>1) & 0x3F
+}
+
+func h265IsKeyframe(data []byte) bool {
+	for _, nalu := range splitAnnexB(data) {
+		if len(nalu) < 2 {
+			continue
+		}
+
+		// IRAP pictures, i.e. BLA, IDR and CRA
+		if nalType := h265NALType(nalu); nalType >= 16 && nalType <= h265NALCRA {
+			return true
+		}
+	}
+
+	return false
+}
+
+// h265SPSInfo is information from H.265 SPS that required to build hvcC box.
+type h265SPSInfo struct {
+	Width            int
+	Height           int
+	ChromaFormatIDC  uint
+	BitDepthLuma     uint
+	BitDepthChroma   uint
+	ProfileTierLevel []byte
+}
+
+func h265ParseSPS(sps []byte) (info h265SPSInfo, err error) {
+	if len(sps) < 16 {
+		return info, fmt.Errorf("SPS is too short")
+	}
+
+	rbsp := removeEmulationPrevention(sps[2:])
+	if len(rbsp) < 13 {
+		return info, fmt.Errorf("SPS is too short")
+	}
+
+	// First byte contains sps_video_parameter_set_id, sps_max_sub_layers_minus1
+	// and sps_temporal_id_nesting_flag. After that, there are 12 bytes general
+	// profile tier level.
+	maxSubLayersMinus1 := int(rbsp[0]>>1) & 0x07
+	info.ProfileTierLevel = append([]byte{}, rbsp[1:13]...)
+
+	b := &bitReader{data: rbsp, pos: 13 * 8}
+
+	// Skip sub layer profile tier level
+	subLayerProfilePresent := make([]uint, maxSubLayersMinus1)
+	subLayerLevelPresent := make([]uint, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		subLayerProfilePresent[i], _ = b.readBit()
+		subLayerLevelPresent[i], _ = b.readBit()
+	}
+
+	if maxSubLayersMinus1 > 0 {
+		b.skipBits(2 * (8 - maxSubLayersMinus1))
+	}
+
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if subLayerProfilePresent[i] == 1 {
+			b.skipBits(88)
+		}
+
+		if subLayerLevelPresent[i] == 1 {
+			b.skipBits(8)
+		}
+	}
+
+	b.readUE() // sps_seq_parameter_set_id
+
+	info.ChromaFormatIDC, _ = b.readUE()
+	if info.ChromaFormatIDC == 3 {
+		b.readBit() // separate_colour_plane_flag
+	}
+
+	width, _ := b.readUE()
+	height, _ := b.readUE()
+
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	conformanceWindow, err := b.readBit()
+	if err != nil {
+		return info, fmt.Errorf("failed to parse SPS: %v", err)
+	}
+
+	if conformanceWindow == 1 {
+		cropLeft, _ = b.readUE()
+		cropRight, _ = b.readUE()
+		cropTop, _ = b.readUE()
+		cropBottom, _ = b.readUE()
+	}
+
+	bitDepthLuma, _ := b.readUE()
+	bitDepthChroma, _ := b.readUE()
+	info.BitDepthLuma = bitDepthLuma + 8
+	info.BitDepthChroma = bitDepthChroma + 8
+
+	subWidthC, subHeightC := uint(1), uint(1)
+	switch info.ChromaFormatIDC {
+	case 1:
+		subWidthC, subHeightC = 2, 2
+	case 2:
+		subWidthC = 2
+	}
+
+	info.Width = int(width - (cropLeft+cropRight)*subWidthC)
+	info.Height = int(height - (cropTop+cropBottom)*subHeightC)
+	return info, nil
+}
@@ -0,0 +1,636 @@
+package remux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	videoTimescale = 90000
+	movieTimescale = 1000
+	tsWrapAround   = int64(1) << 33
+
+	// Maximum duration of audio that kept while waiting for video
+	maxPendingAudio = 2 * videoTimescale
+
+	// Default duration of video frame, used when it can't be calculated
+	defaultFrameDuration = videoTimescale / 30
+
+	// Maximum jump of timestamp that still considered as continuous. Bigger
+	// jump will be collapsed, e.g. when joining segments from different
+	// recording session.
+	maxTimestampJump = 10 * videoTimescale
+
+	sampleFlagsKeyframe    = 0x02000000
+	sampleFlagsNonKeyframe = 0x01010000
+)
+
+// FMP4Writer writes packets as fragmented MP4. Each fragment contains a
+// single GOP, so the resulting file is seekable without sample tables.
+// The duration is only known after all fragments are written, so if the
+// output is seekable, e.g. a file, moov is rewritten with the duration
+// when the writer is closed. Otherwise the duration is left as zero.
+type FMP4Writer struct {
+	w            io.Writer
+	initWritten  bool
+	moovOffset   int64
+	sequence     uint32
+	origin       int64
+	hasOrigin    bool
+	pendingAudio []*Packet
+
+	video videoTrack
+	audio audioTrack
+}
+
+type mp4Sample struct {
+	data     []byte
+	dts      int64
+	cts      int64
+	keyframe bool
+}
+
+type trackTimeline struct {
+	lastTimestamp int64
+	wrapOffset    int64
+	started       bool
+}
+
+type videoTrack struct {
+	trackID     uint32
+	sampleEntry []byte
+	end         int64
+	codec       CodecType
+	sps         []byte
+	pps         []byte
+	vps         []byte
+	width       int
+	height      int
+	samples     []mp4Sample
+	duration    int64
+	lastDTS     int64
+	started     bool
+	timeline    trackTimeline
+}
+
+type audioTrack struct {
+	trackID     uint32
+	sampleEntry []byte
+	end         int64
+	present     bool
+	config      adtsFrame
+	sampleRate  int
+	samples     []mp4Sample
+	nextTime    int64
+	started     bool
+	timeline    trackTimeline
+}
+
+// NewFMP4Writer returns a new writer that writes fragmented MP4 into w.
+func NewFMP4Writer(w io.Writer) *FMP4Writer {
+	return &FMP4Writer{w: w, moovOffset: -1}
+}
+
+// WritePacket adds packet into current fragment. The fragment will be written
+// when the next video keyframe is received.
+func (m *FMP4Writer) WritePacket(p *Packet) error {
+	switch p.Codec {
+	case H264, H265:
+		return m.writeVideo(p)
+	case AAC:
+		return m.writeAudio(p)
+	default:
+		return fmt.Errorf("codec %d is not supported", p.Codec)
+	}
+}
+
+// Close writes the remaining samples as the last fragment.
+func (m *FMP4Writer) Close() error {
+	if !m.hasOrigin && len(m.pendingAudio) > 0 {
+		err := m.flushPendingAudio(m.pendingAudio[0].PTS)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(m.video.samples) == 0 && len(m.audio.samples) == 0 {
+		if !m.initWritten {
+			return fmt.Errorf("stream doesn't contain any playable media")
+		}
+	} else {
+		err := m.flushFragment(-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.writeDuration()
+}
+
+func (m *FMP4Writer) writeVideo(p *Packet) error {
+	if m.video.codec == 0 {
+		m.video.codec = p.Codec
+	} else if m.video.codec != p.Codec {
+		return nil
+	}
+
+	// Convert Annex B into length prefixed NAL units, and grab parameter sets
+	sample := []byte{}
+	for _, nalu := range splitAnnexB(p.Data) {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		if p.Codec == H264 {
+			switch nalu[0] & 0x1F {
+			case h264NALSPS:
+				m.video.sps = nalu
+				continue
+			case h264NALPPS:
+				m.video.pps = nalu
+				continue
+			case h264NALAUD:
+				continue
+			}
+		} else if len(nalu) >= 2 {
+			switch h265NALType(nalu) {
+			case h265NALVPS:
+				m.video.vps = nalu
+				continue
+			case h265NALSPS:
+				m.video.sps = nalu
+				continue
+			case h265NALPPS:
+				m.video.pps = nalu
+				continue
+			case h265NALAUD:
+				continue
+			}
+		}
+
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(nalu)))
+		sample = append(sample, length...)
+		sample = append(sample, nalu...)
+	}
+
+	// Video that found after init segment is written can't be added anymore
+	if len(sample) == 0 || (m.initWritten && m.video.trackID == 0) {
+		return nil
+	}
+
+	// Wait until the first keyframe with its parameter sets, since
+	// the frames before it can't be decoded.
+	if m.video.trackID == 0 && len(m.video.samples) == 0 {
+		if !p.Keyframe || !m.videoConfigReady() {
+			return nil
+		}
+	}
+
+	if !m.hasOrigin {
+		err := m.flushPendingAudio(p.DTS)
+		if err != nil {
+			return err
+		}
+	}
+
+	dts := m.video.timeline.unwrap(p.DTS) - m.origin
+	pts := m.video.timeline.unwrap(p.PTS) - m.origin
+	if pts < dts {
+		pts = dts
+	}
+
+	// If timestamp is jumping, shift the origin so this frame
+	// continues right after the previous one.
+	if m.video.started && (dts <= m.video.lastDTS || dts > m.video.lastDTS+maxTimestampJump) {
+		frameDuration := m.video.duration
+		if frameDuration <= 0 {
+			frameDuration = defaultFrameDuration
+		}
+
+		shift := dts - (m.video.lastDTS + frameDuration)
+		m.origin += shift
+		dts -= shift
+		pts -= shift
+	}
+
+	if m.video.started {
+		m.video.duration = dts - m.video.lastDTS
+	}
+
+	m.video.started = true
+	m.video.lastDTS = dts
+
+	// On keyframe, write the previous GOP as a fragment
+	if p.Keyframe && len(m.video.samples) > 0 {
+		err := m.flushFragment(dts)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.video.samples = append(m.video.samples, mp4Sample{
+		data:     sample,
+		dts:      dts,
+		cts:      pts - dts,
+		keyframe: p.Keyframe,
+	})
+
+	return nil
+}
+
+func (m *FMP4Writer) writeAudio(p *Packet) error {
+	frames, _ := parseADTS(p.Data)
+	if len(frames) == 0 {
+		return nil
+	}
+
+	// Use the config from the first frame. If audio is found after
+	// init segment is written, it can't be added anymore.
+	if !m.audio.present {
+		if m.initWritten || frames[0].SampleRate() == 0 {
+			return nil
+		}
+
+		m.audio.present = true
+		m.audio.config = frames[0]
+		m.audio.sampleRate = frames[0].SampleRate()
+	}
+
+	// Before the origin is known, keep the audio until video is found. If there
+	// are no video after a while, assume this is an audio only stream.
+	if !m.hasOrigin {
+		if m.video.codec != 0 {
+			return nil
+		}
+
+		m.pendingAudio = append(m.pendingAudio, p)
+		if p.PTS-m.pendingAudio[0].PTS < maxPendingAudio {
+			return nil
+		}
+
+		return m.flushPendingAudio(m.pendingAudio[0].PTS)
+	}
+
+	pts := m.audio.timeline.unwrap(p.PTS) - m.origin
+	if pts < 0 {
+		return nil
+	}
+
+	// Convert PTS into audio timescale. If it's close enough with the expected
+	// time, use the expected one to prevent jitter between frames. If it's
+	// jumping, continue right after the previous frame.
+	sampleTime := pts * int64(m.audio.sampleRate) / videoTimescale
+	if m.audio.started {
+		diff := sampleTime - m.audio.nextTime
+		maxJump := maxTimestampJump * int64(m.audio.sampleRate) / videoTimescale
+		if diff < aacSamplesPerFrame || diff > maxJump {
+			sampleTime = m.audio.nextTime
+		}
+	}
+	m.audio.started = true
+
+	for _, frame := range frames {
+		m.audio.samples = append(m.audio.samples, mp4Sample{
+			data:     frame.Data,
+			dts:      sampleTime,
+			keyframe: true,
+		})
+
+		sampleTime += aacSamplesPerFrame
+	}
+
+	m.audio.nextTime = sampleTime
+
+	// If there are no video, write fragment every second
+	if m.video.codec == 0 || (m.initWritten && m.video.trackID == 0) {
+		first := m.audio.samples[0].dts
+		if sampleTime-first >= int64(m.audio.sampleRate) {
+			return m.flushFragment(-1)
+		}
+	}
+
+	return nil
+}
+
+// flushPendingAudio sets the origin of stream, then writes the audio
+// packets that received before the origin is known.
+func (m *FMP4Writer) flushPendingAudio(origin int64) error {
+	m.origin = origin
+	m.hasOrigin = true
+
+	pending := m.pendingAudio
+	m.pendingAudio = nil
+
+	for _, p := range pending {
+		err := m.writeAudio(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *FMP4Writer) videoConfigReady() bool {
+	if m.video.codec == H265 {
+		return m.video.vps != nil && m.video.sps != nil && m.video.pps != nil
+	}
+
+	return m.video.sps != nil && m.video.pps != nil
+}
+
+func (m *FMP4Writer) writeInit() error {
+	trackID := uint32(1)
+
+	if m.video.codec != 0 && m.videoConfigReady() {
+		if m.video.codec == H264 {
+			width, height, err := h264ParseSPS(m.video.sps)
+			if err != nil {
+				return err
+			}
+
+			m.video.width, m.video.height = width, height
+			m.video.sampleEntry = mp4VisualSampleEntry("avc1", width, height,
+				mp4Avcc(m.video.sps, m.video.pps))
+		} else {
+			info, err := h265ParseSPS(m.video.sps)
+			if err != nil {
+				return err
+			}
+
+			m.video.width, m.video.height = info.Width, info.Height
+			m.video.sampleEntry = mp4VisualSampleEntry("hvc1", info.Width, info.Height,
+				mp4Hvcc(m.video.vps, m.video.sps, m.video.pps, info))
+		}
+
+		m.video.trackID = trackID
+		trackID++
+	}
+
+	if m.audio.present {
+		m.audio.sampleEntry = mp4AudioSampleEntry(m.audio.sampleRate,
+			m.audio.config.ChannelConfig, m.audio.config.AudioSpecificConfig())
+		m.audio.trackID = trackID
+		trackID++
+	}
+
+	if trackID == 1 {
+		return fmt.Errorf("stream doesn't contain any playable media")
+	}
+
+	_, err := m.w.Write(mp4Ftyp())
+	if err != nil {
+		return err
+	}
+
+	// Remember where moov is written, so it can be rewritten with the duration
+	if ws, seekable := m.w.(io.WriteSeeker); seekable {
+		if offset, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			m.moovOffset = offset
+		}
+	}
+
+	_, err = m.w.Write(m.moov())
+	if err != nil {
+		return err
+	}
+
+	m.initWritten = true
+	return nil
+}
+
+// moov creates moov box for the tracks, using the duration of samples that written so far.
+func (m *FMP4Writer) moov() []byte {
+	tracks := [][]byte{}
+	trexes := [][]byte{}
+	movieDuration := int64(0)
+	nextTrackID := uint32(1)
+
+	addTrack := func(trackID uint32, isAudio bool, timescale int64, end int64, width, height int, sampleEntry []byte) {
+		duration := end * movieTimescale / timescale
+		if duration > movieDuration {
+			movieDuration = duration
+		}
+
+		tracks = append(tracks, mp4Box("trak",
+			mp4Tkhd(trackID, uint32(duration), isAudio, width, height),
+			mp4Box("mdia",
+				mp4Mdhd(uint32(timescale), uint32(end)),
+				mp4Hdlr(isAudio),
+				mp4Minf(isAudio, sampleEntry))))
+		trexes = append(trexes, mp4Trex(trackID))
+		nextTrackID = trackID + 1
+	}
+
+	if m.video.trackID != 0 {
+		addTrack(m.video.trackID, false, videoTimescale, m.video.end,
+			m.video.width, m.video.height, m.video.sampleEntry)
+	}
+
+	if m.audio.trackID != 0 {
+		addTrack(m.audio.trackID, true, int64(m.audio.sampleRate), m.audio.end,
+			0, 0, m.audio.sampleEntry)
+	}
+
+	mvex := mp4Box("mvex", append([][]byte{mp4Mehd(uint32(movieDuration))}, trexes...)...)
+	moovContents := [][]byte{mp4Mvhd(movieTimescale, uint32(movieDuration), nextTrackID)}
+	moovContents = append(moovContents, tracks...)
+	moovContents = append(moovContents, mvex)
+
+	return mp4Box("moov", moovContents...)
+}
+
+// writeDuration rewrites moov with the duration of all fragments. It does nothing
+// if the output is not seekable. Since the duration fields have fixed size, the
+// new moov has the same size as the old one.
+func (m *FMP4Writer) writeDuration() error {
+	ws, seekable := m.w.(io.WriteSeeker)
+	if !seekable || m.moovOffset < 0 {
+		return nil
+	}
+
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = ws.Seek(m.moovOffset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = ws.Write(m.moov())
+	if err != nil {
+		return err
+	}
+
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// flushFragment writes samples that collected so far as a single fragment.
+// nextVideoDTS is used to calculate the duration of the last video sample.
+func (m *FMP4Writer) flushFragment(nextVideoDTS int64) error {
+	if !m.initWritten {
+		err := m.writeInit()
+		if err != nil {
+			return err
+		}
+	}
+
+	type trackFragment struct {
+		trackID uint32
+		samples []mp4Sample
+		lastDur int64
+		nextDTS int64
+	}
+
+	fragments := []trackFragment{}
+	hasVideoFragment := false
+	if m.video.trackID != 0 && len(m.video.samples) > 0 {
+		lastDuration := m.video.duration
+		if lastDuration <= 0 {
+			lastDuration = defaultFrameDuration
+		}
+
+		fragments = append(fragments, trackFragment{
+			trackID: m.video.trackID,
+			samples: m.video.samples,
+			lastDur: lastDuration,
+			nextDTS: nextVideoDTS,
+		})
+		hasVideoFragment = true
+	}
+
+	if m.audio.trackID != 0 && len(m.audio.samples) > 0 {
+		fragments = append(fragments, trackFragment{
+			trackID: m.audio.trackID,
+			samples: m.audio.samples,
+			lastDur: aacSamplesPerFrame,
+			nextDTS: m.audio.nextTime,
+		})
+	}
+
+	if len(fragments) == 0 {
+		m.video.samples = nil
+		m.audio.samples = nil
+		return nil
+	}
+
+	// Build each track fragment, with temporary data offset
+	m.sequence++
+	trafs := [][]byte{}
+	mdatContents := [][]byte{}
+	dataOffsets := []int{}
+	mdatSize := 0
+
+	for i, fragment := range fragments {
+		trun := []byte{}
+		samples := fragment.samples
+
+		for j, sample := range samples {
+			duration := fragment.lastDur
+			if j+1 < len(samples) {
+				duration = samples[j+1].dts - sample.dts
+			} else if fragment.nextDTS > sample.dts {
+				duration = fragment.nextDTS - sample.dts
+			}
+
+			if duration <= 0 {
+				duration = fragment.lastDur
+			}
+			fragments[i].lastDur = duration
+
+			if j == len(samples)-1 {
+				m.setTrackEnd(fragment.trackID, sample.dts+duration)
+			}
+
+			flags := uint32(sampleFlagsNonKeyframe)
+			if sample.keyframe {
+				flags = sampleFlagsKeyframe
+			}
+
+			trun = append(trun, u32(uint32(duration))...)
+			trun = append(trun, u32(uint32(len(sample.data)))...)
+			trun = append(trun, u32(flags)...)
+			trun = append(trun, u32(uint32(int32(sample.cts)))...)
+
+			mdatContents = append(mdatContents, sample.data)
+			mdatSize += len(sample.data)
+		}
+
+		dataOffsets = append(dataOffsets, mdatSize)
+		trafs = append(trafs, mp4Box("traf",
+			mp4FullBox("tfhd", 0, 0x020000, u32(fragment.trackID)),
+			mp4FullBox("tfdt", 1, 0, u64(uint64(samples[0].dts))),
+			mp4FullBox("trun", 1, 0x000F01,
+				u32(uint32(len(samples))),
+				u32(0), // data offset, will be updated later
+				trun)))
+	}
+
+	// Now we know the size of moof, so update data offset in each trun.
+	// Data offset is counted from the start of moof until the sample data.
+	moof := mp4Box("moof", append([][]byte{mp4FullBox("mfhd", 0, 0, u32(m.sequence))}, trafs...)...)
+	moofSize := len(moof)
+
+	trafOffset := 8 + 16 // moof header and mfhd
+	trackDataStart := 0
+	for i, traf := range trafs {
+		// Inside traf : header (8), tfhd (16), tfdt (20), then trun header (12)
+		// followed by sample count (4) and data offset.
+		offsetPos := trafOffset + 8 + 16 + 20 + 12 + 4
+		binary.BigEndian.PutUint32(moof[offsetPos:], uint32(moofSize+8+trackDataStart))
+
+		trackDataStart = dataOffsets[i]
+		trafOffset += len(traf)
+	}
+
+	_, err := m.w.Write(moof)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.w.Write(mp4Box("mdat", mdatContents...))
+	if err != nil {
+		return err
+	}
+
+	// Reset samples
+	if hasVideoFragment {
+		m.video.duration = fragments[0].lastDur
+	}
+
+	m.video.samples = nil
+	m.audio.samples = nil
+	return nil
+}
+
+// setTrackEnd saves the end time of track, i.e. end of its last sample.
+func (m *FMP4Writer) setTrackEnd(trackID uint32, end int64) {
+	switch trackID {
+	case m.video.trackID:
+		m.video.end = end
+	case m.audio.trackID:
+		m.audio.end = end
+	}
+}
+
+// unwrap converts 33 bit timestamp into continuous timestamp. Since PTS and DTS
+// share the timeline, timestamp that still before the wrap around is kept there,
+// e.g. DTS of frame whose PTS already wrapped.
+func (t *trackTimeline) unwrap(timestamp int64) int64 {
+	timestamp += t.wrapOffset
+	if t.started && timestamp < t.lastTimestamp-tsWrapAround/2 {
+		t.wrapOffset += tsWrapAround
+		timestamp += tsWrapAround
+	} else if t.started && timestamp > t.lastTimestamp+tsWrapAround/2 {
+		timestamp -= tsWrapAround
+	}
+
+	t.started = true
+	t.lastTimestamp = timestamp
+	return timestamp
+}
@@ -0,0 +1,243 @@
+package remux
+
+import (
+	"encoding/binary"
+)
+
+// mp4Box creates ISO-BMFF box with the specified type and contents.
+func mp4Box(boxType string, contents ...[]byte) []byte {
+	size := 8
+	for _, content := range contents {
+		size += len(content)
+	}
+
+	box := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(box, uint32(size))
+	copy(box[4:], boxType)
+
+	for _, content := range contents {
+		box = append(box, content...)
+	}
+
+	return box
+}
+
+// mp4FullBox creates ISO-BMFF full box, i.e. box with version and flags.
+func mp4FullBox(boxType string, version byte, flags uint32, contents ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(boxType, append([][]byte{header}, contents...)...)
+}
+
+func u8(v uint8) []byte {
+	return []byte{v}
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func zeros(n int) []byte {
+	return make([]byte, n)
+}
+
+// Unity matrix that used in mvhd and tkhd
+var mp4Matrix = []byte{
+	0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00,
+}
+
+func mp4Ftyp() []byte {
+	return mp4Box("ftyp",
+		[]byte("isom"), u32(0x200),
+		[]byte("isom"), []byte("iso5"), []byte("iso6"),
+		[]byte("avc1"), []byte("mp41"))
+}
+
+func mp4Mvhd(timescale uint32, duration uint32, nextTrackID uint32) []byte {
+	return mp4FullBox("mvhd", 0, 0,
+		u32(0),          // creation time
+		u32(0),          // modification time
+		u32(timescale),  // timescale
+		u32(duration),   // duration
+		u32(0x00010000), // rate 1.0
+		u16(0x0100),     // volume 1.0
+		zeros(10),       // reserved
+		mp4Matrix,
+		zeros(24), // pre defined
+		u32(nextTrackID))
+}
+
+func mp4Tkhd(trackID uint32, duration uint32, isAudio bool, width, height int) []byte {
+	volume := uint16(0)
+	if isAudio {
+		volume = 0x0100
+	}
+
+	return mp4FullBox("tkhd", 0, 0x000003,
+		u32(0),        // creation time
+		u32(0),        // modification time
+		u32(trackID),  // track ID
+		u32(0),        // reserved
+		u32(duration), // duration
+		zeros(8),      // reserved
+		u16(0),        // layer
+		u16(0),        // alternate group
+		u16(volume),   // volume
+		u16(0),        // reserved
+		mp4Matrix,
+		u32(uint32(width)<<16),
+		u32(uint32(height)<<16))
+}
+
+func mp4Mdhd(timescale uint32, duration uint32) []byte {
+	return mp4FullBox("mdhd", 0, 0,
+		u32(0),         // creation time
+		u32(0),         // modification time
+		u32(timescale), // timescale
+		u32(duration),  // duration
+		u16(0x55C4),    // language "und"
+		u16(0))         // pre defined
+}
+
+func mp4Hdlr(isAudio bool) []byte {
+	handlerType, name := "vide", "VideoHandler"
+	if isAudio {
+		handlerType, name = "soun", "SoundHandler"
+	}
+
+	return mp4FullBox("hdlr", 0, 0,
+		u32(0), // pre defined
+		[]byte(handlerType),
+		zeros(12), // reserved
+		append([]byte(name), 0))
+}
+
+func mp4Minf(isAudio bool, sampleEntry []byte) []byte {
+	mediaHeader := mp4FullBox("vmhd", 0, 1, zeros(8))
+	if isAudio {
+		mediaHeader = mp4FullBox("smhd", 0, 0, zeros(4))
+	}
+
+	dinf := mp4Box("dinf",
+		mp4FullBox("dref", 0, 0, u32(1),
+			mp4FullBox("url ", 0, 1)))
+
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, u32(1), sampleEntry),
+		mp4FullBox("stts", 0, 0, u32(0)),
+		mp4FullBox("stsc", 0, 0, u32(0)),
+		mp4FullBox("stsz", 0, 0, u32(0), u32(0)),
+		mp4FullBox("stco", 0, 0, u32(0)))
+
+	return mp4Box("minf", mediaHeader, dinf, stbl)
+}
+
+func mp4VisualSampleEntry(format string, width, height int, config []byte) []byte {
+	return mp4Box(format,
+		zeros(6),  // reserved
+		u16(1),    // data reference index
+		zeros(16), // pre defined and reserved
+		u16(uint16(width)),
+		u16(uint16(height)),
+		u32(0x00480000), // horizontal resolution 72 dpi
+		u32(0x00480000), // vertical resolution 72 dpi
+		u32(0),          // reserved
+		u16(1),          // frame count
+		zeros(32),       // compressor name
+		u16(0x0018),     // depth
+		u16(0xFFFF),     // pre defined
+		config)
+}
+
+func mp4Avcc(sps, pps []byte) []byte {
+	return mp4Box("avcC",
+		u8(1),    // configuration version
+		sps[1:4], // profile, compatibility and level
+		u8(0xFF), // NAL unit length is 4 bytes
+		u8(0xE1), // one SPS
+		u16(uint16(len(sps))), sps,
+		u8(1), // one PPS
+		u16(uint16(len(pps))), pps)
+}
+
+func mp4Hvcc(vps, sps, pps []byte, info h265SPSInfo) []byte {
+	nalArray := func(nalType int, nalu []byte) []byte {
+		return append([]byte{0x80 | byte(nalType), 0, 1, byte(len(nalu) >> 8), byte(len(nalu))}, nalu...)
+	}
+
+	return mp4Box("hvcC",
+		u8(1), // configuration version
+		info.ProfileTierLevel,
+		u16(0xF000), // min spatial segmentation
+		u8(0xFC),    // parallelism type
+		u8(0xFC|byte(info.ChromaFormatIDC)),
+		u8(0xF8|byte(info.BitDepthLuma-8)),
+		u8(0xF8|byte(info.BitDepthChroma-8)),
+		u16(0),   // average frame rate
+		u8(0x0F), // NAL unit length is 4 bytes
+		u8(3),    // number of arrays
+		nalArray(h265NALVPS, vps),
+		nalArray(h265NALSPS, sps),
+		nalArray(h265NALPPS, pps))
+}
+
+func mp4AudioSampleEntry(sampleRate, channels int, asc []byte) []byte {
+	// Decoder config descriptor is nested inside ES descriptor
+	decoderSpecificInfo := append([]byte{0x05, byte(len(asc))}, asc...)
+
+	decoderConfig := []byte{0x04, byte(13 + len(decoderSpecificInfo))}
+	decoderConfig = append(decoderConfig,
+		0x40,             // object type : MPEG-4 audio
+		0x15,             // stream type : audio
+		0x00, 0x00, 0x00, // buffer size
+		0x00, 0x00, 0x00, 0x00, // max bitrate
+		0x00, 0x00, 0x00, 0x00) // average bitrate
+	decoderConfig = append(decoderConfig, decoderSpecificInfo...)
+
+	slConfig := []byte{0x06, 0x01, 0x02}
+
+	esDescriptor := []byte{0x03, byte(3 + len(decoderConfig) + len(slConfig))}
+	esDescriptor = append(esDescriptor, 0x00, 0x02, 0x00) // ES ID and flags
+	esDescriptor = append(esDescriptor, decoderConfig...)
+	esDescriptor = append(esDescriptor, slConfig...)
+
+	return mp4Box("mp4a",
+		zeros(6), // reserved
+		u16(1),   // data reference index
+		zeros(8), // reserved
+		u16(uint16(channels)),
+		u16(16), // sample size
+		u16(0),  // pre defined
+		u16(0),  // reserved
+		u32(uint32(sampleRate)<<16),
+		mp4FullBox("esds", 0, 0, esDescriptor))
+}
+
+// mp4Mehd declares duration of the whole fragmented movie, including its fragments.
+func mp4Mehd(duration uint32) []byte {
+	return mp4FullBox("mehd", 0, 0, u32(duration))
+}
+
+func mp4Trex(trackID uint32) []byte {
+	return mp4FullBox("trex", 0, 0,
+		u32(trackID),
+		u32(1), // default sample description index
+		u32(0), // default sample duration
+		u32(0), // default sample size
+		u32(0)) // default sample flags
+}
@@ -0,0 +1,177 @@
+// Package remux converts MPEG-TS stream that served by camera into
+// fragmented MP4 that can be played directly by web browser.
+package remux
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// CodecType is the type of codec used by an elementary stream.
+type CodecType int
+
+// List of codec that supported by this package.
+const (
+	H264 CodecType = iota + 1
+	H265
+	AAC
+)
+
+// Packet is a single access unit of an elementary stream. PTS and DTS
+// are in 90 kHz clock, the same as the one used in MPEG-TS.
+type Packet struct {
+	Codec    CodecType
+	PTS      int64
+	DTS      int64
+	Data     []byte
+	Keyframe bool
+}
+
+// Remux reads MPEG-TS stream from src, then writes it as fragmented MP4 into dst.
+func Remux(dst io.Writer, src io.Reader) error {
+	reader := NewTSReader(src)
+	writer := NewFMP4Writer(dst)
+
+	for {
+		packet, err := reader.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = writer.WritePacket(packet)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// CopyRange reads MPEG-TS stream from src, then writes its packets between start
+// and end into dst. Both start and end are counted from the first timestamp in
+// src. To make sure the result can be decoded, start is moved backward to the
+// nearest keyframe while end is moved forward to the nearest keyframe. Zero or
+// negative start and end means no limit.
+func CopyRange(dst *FMP4Writer, src io.Reader, start, end time.Duration) error {
+	reader := NewTSReader(src)
+	started := start <= 0
+	gop := []*Packet{}
+
+	var base int64
+	var hasBase bool
+	var timeline trackTimeline
+
+	for {
+		packet, err := reader.ReadPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Calculate the offset of this packet from start of stream
+		dts := timeline.unwrap(packet.DTS)
+		if !hasBase {
+			base = dts
+			hasBase = true
+		}
+
+		offset := time.Duration(dts-base) * time.Second / videoTimescale
+		isCutPoint := !reader.hasVideo() || (packet.Codec != AAC && packet.Keyframe)
+
+		// Before start, keep the packets since the latest keyframe
+		if !started {
+			if isCutPoint {
+				gop = gop[:0]
+			}
+
+			gop = append(gop, packet)
+			if offset < start {
+				continue
+			}
+
+			started = true
+			for _, p := range gop {
+				err = dst.WritePacket(p)
+				if err != nil {
+					return err
+				}
+			}
+
+			gop = nil
+			continue
+		}
+
+		if end > 0 && offset >= end && isCutPoint {
+			return nil
+		}
+
+		err = dst.WritePacket(packet)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ProbeDuration returns the duration of MPEG-TS stream by reading timestamps
+// in the beginning and the end of stream, so it doesn't need to read the whole
+// stream. Duration of the last frame is estimated from average frame duration,
+// since it's not stored anywhere in the stream.
+func ProbeDuration(src io.ReadSeeker) (time.Duration, error) {
+	const probeSize = 64 * 1024
+
+	// Get size of stream
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	// Read the first timestamp from the head of stream
+	reader := NewTSReader(io.LimitReader(src, probeSize))
+	head, err := reader.probeTimestamps()
+	if err != nil {
+		return 0, err
+	}
+
+	// Read the last timestamp from the tail of stream
+	tail := head
+	if size > probeSize {
+		offset := (size - probeSize) / tsPacketSize * tsPacketSize
+		_, err = src.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+
+		reader.reset(src)
+		tail, err = reader.probeTimestamps()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if head.count == 0 || tail.count == 0 {
+		return 0, fmt.Errorf("stream doesn't contain any media")
+	}
+
+	// Add the estimated duration of last frame
+	ticks := tail.max - head.min
+	if ticks < 0 {
+		ticks += tsWrapAround
+	}
+
+	if tail.count > 1 {
+		ticks += (tail.max - tail.min) / int64(tail.count-1)
+	}
+
+	return time.Duration(ticks) * time.Second / videoTimescale, nil
+}
@@ -0,0 +1,431 @@
+package remux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Run `go test ./remux -update` to regenerate the sample segments and golden files.
+var update = flag.Bool("update", false, "update sample segments and golden files")
+
+// Sample SPS of 640x360 H.264 baseline stream, and its PPS.
+var (
+	sampleSPS = []byte{0x67, 0x42, 0xC0, 0x1E, 0xDA, 0x02, 0x80, 0xBF, 0xE5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xF0, 0x3C, 0x58, 0xBA, 0x80}
+	samplePPS = []byte{0x68, 0xCE, 0x0F, 0xC8}
+)
+
+// sampleSegment creates MPEG-TS segment with 2 seconds of 30 FPS H.264 video,
+// with keyframe on every second. If withAudio is true, it also contains AAC
+// audio with a frame on every two video frames.
+func sampleSegment(withAudio bool) ([]byte, error) {
+	codecs := []CodecType{H264}
+	if withAudio {
+		codecs = append(codecs, AAC)
+	}
+
+	buffer := &bytes.Buffer{}
+	writer := NewTSWriter(buffer, codecs...)
+
+	for i := 0; i < 60; i++ {
+		isKeyframe := i%30 == 0
+		au := []byte{0, 0, 0, 1, 0x09, 0xF0}
+		if isKeyframe {
+			au = append(au, 0, 0, 0, 1)
+			au = append(au, sampleSPS...)
+			au = append(au, 0, 0, 0, 1)
+			au = append(au, samplePPS...)
+			au = append(au, 0, 0, 0, 1, 0x65)
+		} else {
+			au = append(au, 0, 0, 0, 1, 0x41)
+		}
+		au = append(au, bytes.Repeat([]byte{byte(i)}, 500+i)...)
+
+		dts := int64(1000 + i*3000)
+		err := writer.WritePacket(&Packet{
+			Codec:    H264,
+			PTS:      dts + 3000,
+			DTS:      dts,
+			Data:     au,
+			Keyframe: isKeyframe,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if withAudio && i%2 == 0 {
+			err = writer.WritePacket(&Packet{
+				Codec: AAC,
+				PTS:   dts,
+				DTS:   dts,
+				Data:  sampleADTS(100, byte(i)),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// sampleADTS creates a stereo 44.1 kHz AAC-LC frame with ADTS header.
+func sampleADTS(payloadSize int, fill byte) []byte {
+	frameLength := 7 + payloadSize
+	frame := []byte{
+		0xFF, 0xF1, 0x50,
+		0x80 | byte(frameLength>>11),
+		byte(frameLength >> 3),
+		byte(frameLength<<5) | 0x1F,
+		0xFC,
+	}
+
+	return append(frame, bytes.Repeat([]byte{fill}, payloadSize)...)
+}
+
+// readSample returns the sample segment in testdata, which created when it's updated.
+func readSample(t *testing.T, name string, withAudio bool) []byte {
+	path := filepath.Join("testdata", name)
+	if *update {
+		segment, err := sampleSegment(withAudio)
+		if err != nil {
+			t.Fatalf("failed to create sample %s: %v", name, err)
+		}
+
+		err = ioutil.WriteFile(path, segment, 0644)
+		if err != nil {
+			t.Fatalf("failed to save sample %s: %v", name, err)
+		}
+	}
+
+	segment, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read sample %s: %v", name, err)
+	}
+
+	return segment
+}
+
+// checkGolden compares the output with golden file in testdata.
+func checkGolden(t *testing.T, name string, output []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := ioutil.WriteFile(path, output, 0644)
+		if err != nil {
+			t.Fatalf("failed to save golden file %s: %v", name, err)
+		}
+	}
+
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file %s: %v", name, err)
+	}
+
+	if !bytes.Equal(output, golden) {
+		t.Errorf("output doesn't match golden file %s, got %d bytes, want %d bytes", name, len(output), len(golden))
+	}
+}
+
+// topLevelBoxes returns type of top level boxes in MP4 file.
+func topLevelBoxes(t *testing.T, mp4 []byte) []string {
+	boxes := []string{}
+	for offset := 0; offset < len(mp4); {
+		if len(mp4)-offset < 8 {
+			t.Fatalf("truncated box at offset %d", offset)
+		}
+
+		size := int(binary.BigEndian.Uint32(mp4[offset:]))
+		if size < 8 || offset+size > len(mp4) {
+			t.Fatalf("invalid size %d of box at offset %d", size, offset)
+		}
+
+		boxes = append(boxes, string(mp4[offset+4:offset+8]))
+		offset += size
+	}
+
+	return boxes
+}
+
+func TestRemux(t *testing.T) {
+	tests := []struct {
+		sample    string
+		golden    string
+		withAudio bool
+		boxes     []string
+	}{{
+		sample: "h264.ts",
+		golden: "h264.mp4",
+		boxes:  []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"},
+	}, {
+		sample:    "h264-aac.ts",
+		golden:    "h264-aac.mp4",
+		withAudio: true,
+		boxes:     []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.sample, func(t *testing.T) {
+			segment := readSample(t, tt.sample, tt.withAudio)
+
+			output := &bytes.Buffer{}
+			err := Remux(output, bytes.NewReader(segment))
+			if err != nil {
+				t.Fatalf("failed to remux: %v", err)
+			}
+
+			boxes := topLevelBoxes(t, output.Bytes())
+			if len(boxes) != len(tt.boxes) {
+				t.Fatalf("got boxes %v, want %v", boxes, tt.boxes)
+			}
+			for i := range boxes {
+				if boxes[i] != tt.boxes[i] {
+					t.Fatalf("got boxes %v, want %v", boxes, tt.boxes)
+				}
+			}
+
+			checkGolden(t, tt.golden, output.Bytes())
+		})
+	}
+}
+
+func TestCopyRange(t *testing.T) {
+	segment := readSample(t, "h264-aac.ts", true)
+
+	// Start is moved back to the keyframe in the first second, so the result is the same
+	// as the whole stream. On the other hand, end is moved forward to the next keyframe.
+	tests := []struct {
+		name       string
+		start, end time.Duration
+		golden     string
+	}{
+		{name: "whole", golden: "h264-aac.mp4"},
+		{name: "first second", end: 500 * time.Millisecond, golden: "h264-aac-first.mp4"},
+		{name: "last second", start: 1500 * time.Millisecond, golden: "h264-aac-last.mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			writer := NewFMP4Writer(output)
+
+			err := CopyRange(writer, bytes.NewReader(segment), tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("failed to copy range: %v", err)
+			}
+
+			err = writer.Close()
+			if err != nil {
+				t.Fatalf("failed to close writer: %v", err)
+			}
+
+			checkGolden(t, tt.golden, output.Bytes())
+		})
+	}
+}
+
+func TestProbeDuration(t *testing.T) {
+	segment := readSample(t, "h264.ts", false)
+
+	duration, err := ProbeDuration(bytes.NewReader(segment))
+	if err != nil {
+		t.Fatalf("failed to probe duration: %v", err)
+	}
+
+	if duration != 2*time.Second {
+		t.Errorf("got duration %s, want %s", duration, 2*time.Second)
+	}
+}
+
+func TestH264ParseSPS(t *testing.T) {
+	width, height, err := h264ParseSPS(sampleSPS)
+	if err != nil {
+		t.Fatalf("failed to parse SPS: %v", err)
+	}
+
+	if width != 640 || height != 360 {
+		t.Errorf("got size %dx%d, want 640x360", width, height)
+	}
+}
+
+// mp4Children returns payload of the child boxes with the specified type.
+func mp4Children(t *testing.T, data []byte, boxType string) [][]byte {
+	children := [][]byte{}
+	for offset := 0; offset+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		if size < 8 || offset+size > len(data) {
+			t.Fatalf("invalid size %d of box at offset %d", size, offset)
+		}
+
+		if string(data[offset+4:offset+8]) == boxType {
+			children = append(children, data[offset+8:offset+size])
+		}
+		offset += size
+	}
+
+	return children
+}
+
+// mp4Child returns payload of the box in the specified path, e.g. moov/trak/mdia.
+// If there are several boxes with the same type, the first one is used.
+func mp4Child(t *testing.T, data []byte, path ...string) []byte {
+	for _, boxType := range path {
+		children := mp4Children(t, data, boxType)
+		if len(children) == 0 {
+			t.Fatalf("box %s is not found", boxType)
+		}
+		data = children[0]
+	}
+
+	return data
+}
+
+// TestRemuxCameraSample remuxes sample segment that not created by this package, then
+// checks the result by reading the boxes directly instead of comparing with golden file.
+// The sample is created by testdata/gen-camera-sample.go : 60 frames of 64x48 H.264 at
+// 29.97 FPS with keyframe on every 30 frames, 86 frames of stereo 44.1 kHz AAC, and its
+// timestamps wrap around on the second keyframe.
+func TestRemuxCameraSample(t *testing.T) {
+	segment, err := ioutil.ReadFile(filepath.Join("testdata", "camera.ts"))
+	if err != nil {
+		t.Fatalf("failed to read sample: %v", err)
+	}
+
+	// Remux into file, so the duration is written
+	output, err := ioutil.TempFile("", "remux-*.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	err = Remux(output, bytes.NewReader(segment))
+	if err != nil {
+		t.Fatalf("failed to remux: %v", err)
+	}
+
+	mp4, err := ioutil.ReadFile(output.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boxes := topLevelBoxes(t, mp4)
+	if !reflect.DeepEqual(boxes, []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"}) {
+		t.Fatalf("got boxes %v, want a fragment for each GOP", boxes)
+	}
+
+	// Check duration of movie and tracks
+	moov := mp4Child(t, mp4, "moov")
+	mvhd := mp4Child(t, moov, "mvhd")
+	if timescale, duration := binary.BigEndian.Uint32(mvhd[12:]), binary.BigEndian.Uint32(mvhd[16:]); timescale != 1000 || duration != 2002 {
+		t.Errorf("mvhd: got duration %d in timescale %d, want 2002 in 1000", duration, timescale)
+	}
+
+	if duration := binary.BigEndian.Uint32(mp4Child(t, moov, "mvex", "mehd")[4:]); duration != 2002 {
+		t.Errorf("mehd: got duration %d, want 2002", duration)
+	}
+
+	traks := mp4Children(t, moov, "trak")
+	if len(traks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(traks))
+	}
+
+	tracks := []struct {
+		name      string
+		duration  uint32
+		timescale uint32
+		mediaDur  uint32
+	}{
+		{"video", 2002, 90000, 60 * 3003},
+		{"audio", 1996, 44100, 86 * 1024},
+	}
+
+	for i, track := range tracks {
+		tkhd := mp4Child(t, traks[i], "tkhd")
+		if duration := binary.BigEndian.Uint32(tkhd[20:]); duration != track.duration {
+			t.Errorf("%s tkhd: got duration %d, want %d", track.name, duration, track.duration)
+		}
+
+		mdhd := mp4Child(t, traks[i], "mdia", "mdhd")
+		timescale, duration := binary.BigEndian.Uint32(mdhd[12:]), binary.BigEndian.Uint32(mdhd[16:])
+		if timescale != track.timescale || duration != track.mediaDur {
+			t.Errorf("%s mdhd: got duration %d in timescale %d, want %d in %d",
+				track.name, duration, timescale, track.mediaDur, track.timescale)
+		}
+	}
+
+	// Check size of video and its parameter sets. The sample entry is
+	// after the version, flags and entry count of stsd.
+	tkhd := mp4Child(t, traks[0], "tkhd")
+	if width, height := binary.BigEndian.Uint32(tkhd[len(tkhd)-8:])>>16, binary.BigEndian.Uint32(tkhd[len(tkhd)-4:])>>16; width != 64 || height != 48 {
+		t.Errorf("got video size %dx%d, want 64x48", width, height)
+	}
+
+	stsd := mp4Child(t, traks[0], "mdia", "minf", "stbl", "stsd")
+	avc1 := mp4Child(t, stsd[8:], "avc1")
+	avcC := mp4Child(t, avc1[78:], "avcC")
+
+	sps := []byte{0x67, 0x42, 0xC0, 0x1E, 0xDA, 0x11, 0xE4}
+	pps := []byte{0x68, 0xCE, 0x3C, 0x80}
+	expectedAvcC := []byte{1, 0x42, 0xC0, 0x1E, 0xFF, 0xE1, 0, byte(len(sps))}
+	expectedAvcC = append(expectedAvcC, sps...)
+	expectedAvcC = append(expectedAvcC, 1, 0, byte(len(pps)))
+	expectedAvcC = append(expectedAvcC, pps...)
+	if !bytes.Equal(avcC, expectedAvcC) {
+		t.Errorf("got avcC % X, want % X", avcC, expectedAvcC)
+	}
+
+	stsd = mp4Child(t, traks[1], "mdia", "minf", "stbl", "stsd")
+	mp4a := mp4Child(t, stsd[8:], "mp4a")
+	if channels, sampleRate := binary.BigEndian.Uint16(mp4a[16:]), binary.BigEndian.Uint32(mp4a[24:])>>16; channels != 2 || sampleRate != 44100 {
+		t.Errorf("got audio with %d channels in %d Hz, want 2 channels in 44100 Hz", channels, sampleRate)
+	}
+
+	// Check samples in each fragment. The timestamps must continue after they wrap around.
+	samples := map[uint32]int{}
+	moofs := mp4Children(t, mp4, "moof")
+	mdats := mp4Children(t, mp4, "mdat")
+	for i, moof := range moofs {
+		dataSize := 0
+		for _, traf := range mp4Children(t, moof, "traf") {
+			trackID := binary.BigEndian.Uint32(mp4Child(t, traf, "tfhd")[4:])
+			baseTime := binary.BigEndian.Uint64(mp4Child(t, traf, "tfdt")[4:])
+			trun := mp4Child(t, traf, "trun")
+			count := int(binary.BigEndian.Uint32(trun[4:]))
+			samples[trackID] += count
+
+			for j := 0; j < count; j++ {
+				entry := trun[12+j*16:]
+				duration := binary.BigEndian.Uint32(entry)
+				flags := binary.BigEndian.Uint32(entry[8:])
+				offset := int32(binary.BigEndian.Uint32(entry[12:]))
+				dataSize += int(binary.BigEndian.Uint32(entry[4:]))
+
+				if trackID != 1 {
+					continue
+				}
+
+				if j == 0 && (baseTime != uint64(i*30*3003) || flags != sampleFlagsKeyframe) {
+					t.Errorf("fragment %d: got video starts at %d with flags %X, want keyframe at %d", i, baseTime, flags, i*30*3003)
+				}
+
+				if duration != 3003 || offset != 2*3003 {
+					t.Errorf("fragment %d: got video sample %d with duration %d and offset %d, want 3003 and 6006", i, j, duration, offset)
+				}
+			}
+		}
+
+		if dataSize != len(mdats[i]) {
+			t.Errorf("fragment %d: got %d bytes of samples, but mdat has %d bytes", i, dataSize, len(mdats[i]))
+		}
+	}
+
+	if samples[1] != 60 || samples[2] != 86 {
+		t.Errorf("got %d video and %d audio samples, want 60 and 86", samples[1], samples[2])
+	}
+}
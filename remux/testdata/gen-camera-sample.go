@@ -0,0 +1,442 @@
+//go:build ignore
+// +build ignore
+
+// This program creates camera.ts, the sample segment that used to check the remuxer
+// against stream that not created by this package. It doesn't use the remux package
+// at all : the H.264 stream is encoded here as I_PCM keyframes and skipped P frames,
+// so it's decodable by any H.264 decoder, then muxed into MPEG-TS in the same layout
+// as common IP camera, i.e. PCR in video stream, unbounded video PES, several AAC
+// frames in each audio PES, and timestamps that wrap around in the middle of segment.
+//
+// Run it from remux directory with `go run testdata/gen-camera-sample.go`.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+const (
+	width       = 64
+	height      = 48
+	frameCount  = 60
+	gopSize     = 30
+	frameTicks  = 3003 // 29.97 FPS in 90 kHz clock
+	ptsOffset   = 2 * frameTicks
+	audioFrames = 86
+	audioRate   = 44100
+
+	pmtPID   = 0x1000
+	videoPID = 0x100
+	audioPID = 0x101
+
+	// The first DTS is one GOP before the 33 bit timestamp wraps around
+	firstDTS     = 1<<33 - gopSize*frameTicks
+	timestampMax = 1 << 33
+)
+
+// Silent frame of stereo AAC-LC
+var silentAAC = []byte{0x21, 0x00, 0x49, 0x90, 0x02, 0x19, 0x00, 0x23, 0x80}
+
+func main() {
+	sps, pps := h264SPS(), h264PPS()
+	log.Printf("SPS: % X\n", sps)
+	log.Printf("PPS: % X\n", pps)
+
+	// Prepare the PES of each stream, along with their timestamps
+	type pes struct {
+		pid      uint16
+		time     int64
+		data     []byte
+		keyframe bool
+	}
+
+	packets := []pes{}
+	for i := 0; i < frameCount; i++ {
+		au := nalu(0x09, []byte{0xF0}) // access unit delimiter
+		keyframe := i%gopSize == 0
+		if keyframe {
+			au = append(au, nalu(0x67, sps)...)
+			au = append(au, nalu(0x68, pps)...)
+			au = append(au, nalu(0x65, h264IDRSlice(i/gopSize))...)
+		} else {
+			au = append(au, nalu(0x41, h264PSlice(i%gopSize))...)
+		}
+
+		dts := int64(firstDTS + i*frameTicks)
+		packets = append(packets, pes{
+			pid:      videoPID,
+			time:     dts,
+			data:     pesPacket(0xE0, au, dts+ptsOffset, dts, false),
+			keyframe: keyframe,
+		})
+	}
+
+	for i := 0; i < audioFrames; i += 3 {
+		frames := []byte{}
+		for j := i; j < i+3 && j < audioFrames; j++ {
+			frames = append(frames, adtsFrame(silentAAC)...)
+		}
+
+		pts := int64(firstDTS) + int64(i)*1024*90000/audioRate
+		packets = append(packets, pes{
+			pid:  audioPID,
+			time: pts,
+			data: pesPacket(0xC0, frames, pts, pts, true),
+		})
+	}
+
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].time < packets[j].time
+	})
+
+	// Mux them, with PAT and PMT before every keyframe
+	mux := &tsMuxer{counters: make(map[uint16]byte)}
+	for _, p := range packets {
+		if p.keyframe {
+			mux.writeSection(0, patSection())
+			mux.writeSection(pmtPID, pmtSection())
+		}
+
+		pcr := int64(-1)
+		if p.pid == videoPID {
+			pcr = (p.time - 9000) % timestampMax
+		}
+		mux.writePES(p.pid, p.data, pcr, p.keyframe)
+	}
+
+	err := ioutil.WriteFile("testdata/camera.ts", mux.Bytes(), 0644)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// bitWriter writes RBSP of H.264 syntax elements.
+type bitWriter struct {
+	data  []byte
+	nBits int
+}
+
+func (b *bitWriter) u(n int, v uint) {
+	for i := n - 1; i >= 0; i-- {
+		if b.nBits%8 == 0 {
+			b.data = append(b.data, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			b.data[len(b.data)-1] |= 0x80 >> uint(b.nBits%8)
+		}
+		b.nBits++
+	}
+}
+
+func (b *bitWriter) ue(v uint) {
+	n := 0
+	for (v+1)>>uint(n) > 1 {
+		n++
+	}
+	b.u(n, 0)
+	b.u(n+1, v+1)
+}
+
+func (b *bitWriter) se(v int) {
+	if v > 0 {
+		b.ue(uint(2*v - 1))
+	} else {
+		b.ue(uint(-2 * v))
+	}
+}
+
+func (b *bitWriter) align() {
+	for b.nBits%8 != 0 {
+		b.u(1, 0)
+	}
+}
+
+func (b *bitWriter) trailing() []byte {
+	b.u(1, 1)
+	b.align()
+	return b.data
+}
+
+// h264SPS creates baseline SPS with 4 bit frame number and POC type 2,
+// so there are no frame reordering. It doesn't include NAL header.
+func h264SPS() []byte {
+	b := &bitWriter{}
+	b.u(8, 66)   // profile_idc : baseline
+	b.u(8, 0xC0) // constraint_set0_flag and constraint_set1_flag
+	b.u(8, 30)   // level_idc
+	b.ue(0)      // seq_parameter_set_id
+	b.ue(0)      // log2_max_frame_num_minus4
+	b.ue(2)      // pic_order_cnt_type
+	b.ue(1)      // max_num_ref_frames
+	b.u(1, 0)    // gaps_in_frame_num_value_allowed_flag
+	b.ue(width/16 - 1)
+	b.ue(height/16 - 1)
+	b.u(1, 1) // frame_mbs_only_flag
+	b.u(1, 1) // direct_8x8_inference_flag
+	b.u(1, 0) // frame_cropping_flag
+	b.u(1, 0) // vui_parameters_present_flag
+	return b.trailing()
+}
+
+func h264PPS() []byte {
+	b := &bitWriter{}
+	b.ue(0)   // pic_parameter_set_id
+	b.ue(0)   // seq_parameter_set_id
+	b.u(1, 0) // entropy_coding_mode_flag : CAVLC
+	b.u(1, 0) // bottom_field_pic_order_in_frame_present_flag
+	b.ue(0)   // num_slice_groups_minus1
+	b.ue(0)   // num_ref_idx_l0_default_active_minus1
+	b.ue(0)   // num_ref_idx_l1_default_active_minus1
+	b.u(1, 0) // weighted_pred_flag
+	b.u(2, 0) // weighted_bipred_idc
+	b.se(0)   // pic_init_qp_minus26
+	b.se(0)   // pic_init_qs_minus26
+	b.se(0)   // chroma_qp_index_offset
+	b.u(1, 1) // deblocking_filter_control_present_flag
+	b.u(1, 0) // constrained_intra_pred_flag
+	b.u(1, 0) // redundant_pic_cnt_present_flag
+	return b.trailing()
+}
+
+// h264IDRSlice creates IDR slice that consists of I_PCM macroblocks,
+// i.e. the raw samples, filled with diagonal gradient.
+func h264IDRSlice(idrPicID int) []byte {
+	b := &bitWriter{}
+	b.ue(0)              // first_mb_in_slice
+	b.ue(7)              // slice_type : I, all slices
+	b.ue(0)              // pic_parameter_set_id
+	b.u(4, 0)            // frame_num
+	b.ue(uint(idrPicID)) // idr_pic_id
+	b.u(1, 0)            // no_output_of_prior_pics_flag
+	b.u(1, 0)            // long_term_reference_flag
+	b.se(0)              // slice_qp_delta
+	b.ue(1)              // disable_deblocking_filter_idc
+	for mbY := 0; mbY < height/16; mbY++ {
+		for mbX := 0; mbX < width/16; mbX++ {
+			b.ue(25) // mb_type : I_PCM
+			b.align()
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 16; x++ {
+					b.u(8, uint(16+(mbX*16+x+mbY*16+y+idrPicID*64)%200))
+				}
+			}
+			for i := 0; i < 2*8*8; i++ {
+				b.u(8, 128)
+			}
+		}
+	}
+	return b.trailing()
+}
+
+// h264PSlice creates P slice whose macroblocks are all skipped.
+func h264PSlice(frameNum int) []byte {
+	b := &bitWriter{}
+	b.ue(0)                        // first_mb_in_slice
+	b.ue(5)                        // slice_type : P, all slices
+	b.ue(0)                        // pic_parameter_set_id
+	b.u(4, uint(frameNum%16))      // frame_num
+	b.u(1, 0)                      // num_ref_idx_active_override_flag
+	b.u(1, 0)                      // ref_pic_list_modification_flag_l0
+	b.u(1, 0)                      // adaptive_ref_pic_marking_mode_flag
+	b.se(0)                        // slice_qp_delta
+	b.ue(1)                        // disable_deblocking_filter_idc
+	b.ue(width / 16 * height / 16) // mb_skip_run
+	return b.trailing()
+}
+
+// nalu creates NAL unit in Annex B format, with emulation prevention bytes.
+func nalu(header byte, rbsp []byte) []byte {
+	data := []byte{0, 0, 0, 1, header}
+	zeros := 0
+	for _, c := range rbsp {
+		if zeros >= 2 && c <= 3 {
+			data = append(data, 3)
+			zeros = 0
+		}
+
+		data = append(data, c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return data
+}
+
+// adtsFrame adds ADTS header for stereo 44.1 kHz AAC-LC into the raw frame.
+func adtsFrame(raw []byte) []byte {
+	length := 7 + len(raw)
+	header := []byte{
+		0xFF, 0xF1, // sync word, MPEG-4, no CRC
+		1<<6 | 4<<2 | 2>>2, // AAC-LC, 44.1 kHz, channel config high bit
+		(2&3)<<6 | byte(length>>11),
+		byte(length >> 3),
+		byte(length&7)<<5 | 0x1F, // buffer fullness 0x7FF
+		0xFC,
+	}
+	return append(header, raw...)
+}
+
+// pesPacket creates PES packet. Video PES has no length, like in most cameras.
+func pesPacket(streamID byte, payload []byte, pts, dts int64, withLength bool) []byte {
+	header := []byte{0x84, 0xC0, 10}
+	timestamps := append(timestamp(3, pts), timestamp(1, dts)...)
+	if pts == dts {
+		header = []byte{0x80, 0x80, 5}
+		timestamps = timestamp(2, pts)
+	}
+
+	length := 0
+	if withLength {
+		length = len(header) + len(timestamps) + len(payload)
+	}
+
+	data := []byte{0, 0, 1, streamID, byte(length >> 8), byte(length)}
+	data = append(data, header...)
+	data = append(data, timestamps...)
+	return append(data, payload...)
+}
+
+func timestamp(prefix byte, ts int64) []byte {
+	ts %= timestampMax
+	return []byte{
+		prefix<<4 | byte(ts>>30&7)<<1 | 1,
+		byte(ts >> 22),
+		byte(ts>>15&0x7F)<<1 | 1,
+		byte(ts >> 7),
+		byte(ts&0x7F)<<1 | 1,
+	}
+}
+
+func patSection() []byte {
+	return psiSection(0, 1, []byte{
+		0, 1, // program number
+		0xE0 | pmtPID>>8, pmtPID & 0xFF,
+	})
+}
+
+func pmtSection() []byte {
+	return psiSection(2, 1, []byte{
+		0xE0 | videoPID>>8, videoPID & 0xFF, // PCR PID
+		0xF0, 0, // program info length
+		0x1B, 0xE0 | videoPID>>8, videoPID & 0xFF, 0xF0, 0,
+		0x0F, 0xE0 | audioPID>>8, audioPID & 0xFF, 0xF0, 0,
+	})
+}
+
+// psiSection creates PSI section with the specified table ID, then appends its CRC.
+func psiSection(tableID byte, tableIDExtension uint16, data []byte) []byte {
+	length := 5 + len(data) + 4
+	section := []byte{
+		tableID,
+		0xB0 | byte(length>>8), byte(length),
+		byte(tableIDExtension >> 8), byte(tableIDExtension),
+		0xC1, // version 0, current
+		0, 0, // section number and last section number
+	}
+	section = append(section, data...)
+
+	crc := crc32MPEG(section)
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, c := range data {
+		crc ^= uint32(c) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// tsMuxer splits PSI sections and PES packets into 188 bytes TS packets.
+type tsMuxer struct {
+	bytes.Buffer
+	counters map[uint16]byte
+}
+
+func (m *tsMuxer) writeSection(pid uint16, section []byte) {
+	m.writePayload(pid, append([]byte{0}, section...), -1, false)
+}
+
+func (m *tsMuxer) writePES(pid uint16, pes []byte, pcr int64, randomAccess bool) {
+	m.writePayload(pid, pes, pcr, randomAccess)
+}
+
+// writePayload writes the payload into TS packets. The first packet carries PCR
+// if it's not negative. The last packet is filled with adaptation field stuffing.
+func (m *tsMuxer) writePayload(pid uint16, payload []byte, pcr int64, randomAccess bool) {
+	first := true
+	for len(payload) > 0 {
+		useAF := false
+		afBody := []byte{}
+		if first && (pcr >= 0 || randomAccess) {
+			useAF = true
+			flags := byte(0)
+			if randomAccess {
+				flags |= 0x40
+			}
+			if pcr >= 0 {
+				flags |= 0x10
+			}
+
+			afBody = append(afBody, flags)
+			if pcr >= 0 {
+				afBody = append(afBody,
+					byte(pcr>>25), byte(pcr>>17), byte(pcr>>9), byte(pcr>>1),
+					byte(pcr&1)<<7|0x7E, 0)
+			}
+		}
+
+		space := 184
+		if useAF {
+			space -= 1 + len(afBody)
+		}
+
+		if len(payload) < space {
+			if !useAF {
+				useAF = true
+				space = 183
+			}
+
+			stuffing := space - len(payload)
+			if len(afBody) == 0 && stuffing > 0 {
+				afBody = append(afBody, 0)
+				stuffing--
+			}
+			afBody = append(afBody, bytes.Repeat([]byte{0xFF}, stuffing)...)
+			space = len(payload)
+		}
+
+		pusi := byte(0)
+		if first {
+			pusi = 0x40
+		}
+
+		control := byte(0x10)
+		if useAF {
+			control = 0x30
+		}
+
+		m.Write([]byte{0x47, pusi | byte(pid>>8), byte(pid), control | m.counters[pid]})
+		m.counters[pid] = (m.counters[pid] + 1) & 0x0F
+		if useAF {
+			m.Write([]byte{byte(len(afBody))})
+			m.Write(afBody)
+		}
+
+		m.Write(payload[:space])
+		payload = payload[space:]
+		first = false
+	}
+}
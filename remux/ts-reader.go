@@ -0,0 +1,361 @@
+package remux
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+)
+
+// Stream types that defined in PMT
+const (
+	streamTypeAAC  = 0x0F
+	streamTypeH264 = 0x1B
+	streamTypeH265 = 0x24
+)
+
+// TSReader demuxes MPEG-TS stream into packets of its elementary streams.
+// Only H.264, H.265 and AAC (ADTS) streams are read, the other is ignored.
+type TSReader struct {
+	r       *bufio.Reader
+	pmtPIDs map[uint16]struct{}
+	streams map[uint16]*pesStream
+	queue   []*Packet
+	eof     bool
+}
+
+// pesStream is PES packet that being assembled from several TS packets.
+type pesStream struct {
+	codec  CodecType
+	buffer []byte
+}
+
+// NewTSReader returns a new reader that demuxes MPEG-TS stream from r.
+func NewTSReader(r io.Reader) *TSReader {
+	return &TSReader{
+		r:       bufio.NewReaderSize(r, tsPacketSize*64),
+		pmtPIDs: make(map[uint16]struct{}),
+		streams: make(map[uint16]*pesStream),
+	}
+}
+
+// ReadPacket returns the next packet in stream. It returns io.EOF
+// when there are no packets left.
+func (t *TSReader) ReadPacket() (*Packet, error) {
+	buffer := make([]byte, tsPacketSize)
+
+	for len(t.queue) == 0 {
+		if t.eof {
+			return nil, io.EOF
+		}
+
+		// Read the next TS packet
+		_, err := io.ReadFull(t.r, buffer)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			t.eof = true
+			t.flushAll()
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// If sync byte is lost, skip until we found it again
+		if buffer[0] != tsSyncByte {
+			err = t.resync(buffer)
+			if err != nil {
+				t.eof = true
+				t.flushAll()
+			}
+			continue
+		}
+
+		err = t.handleTSPacket(buffer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	packet := t.queue[0]
+	t.queue = t.queue[1:]
+	return packet, nil
+}
+
+// reset makes reader continue reading from r, e.g. after seeking the source.
+// Incomplete PES packets are discarded, but the program tables are kept.
+func (t *TSReader) reset(r io.Reader) {
+	t.r.Reset(r)
+	t.queue = nil
+	t.eof = false
+
+	for _, stream := range t.streams {
+		stream.buffer = stream.buffer[:0]
+	}
+}
+
+// timestampRange is range of timestamps that found while probing stream.
+type timestampRange struct {
+	min   int64
+	max   int64
+	count int
+}
+
+// probeTimestamps reads all remaining packets and returns range of their PTS.
+// If stream contains video, only video packets are used since audio PES
+// usually contains several frames.
+func (t *TSReader) probeTimestamps() (timestampRange, error) {
+	result := timestampRange{}
+
+	for {
+		packet, err := t.ReadPacket()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if packet.Codec == AAC && t.hasVideo() {
+			continue
+		}
+
+		if result.count == 0 || packet.PTS < result.min {
+			result.min = packet.PTS
+		}
+		if result.count == 0 || packet.PTS > result.max {
+			result.max = packet.PTS
+		}
+		result.count++
+	}
+}
+
+// hasVideo returns true if PMT contains video stream.
+func (t *TSReader) hasVideo() bool {
+	for _, stream := range t.streams {
+		if stream.codec == H264 || stream.codec == H265 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *TSReader) resync(buffer []byte) error {
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if b == tsSyncByte {
+			buffer[0] = b
+			_, err = io.ReadFull(t.r, buffer[1:])
+			return err
+		}
+	}
+}
+
+func (t *TSReader) handleTSPacket(packet []byte) error {
+	// Skip packet with transport error
+	if packet[1]&0x80 != 0 {
+		return nil
+	}
+
+	unitStart := packet[1]&0x40 != 0
+	pid := uint16(packet[1]&0x1F)<<8 | uint16(packet[2])
+	adaptationControl := (packet[3] >> 4) & 0x03
+
+	// Find where payload started
+	offset := 4
+	if adaptationControl&0x02 != 0 {
+		offset += 1 + int(packet[4])
+	}
+
+	if adaptationControl&0x01 == 0 || offset >= tsPacketSize {
+		return nil
+	}
+
+	payload := packet[offset:]
+
+	// Handle program tables
+	if pid == 0 {
+		return t.handlePAT(payload, unitStart)
+	}
+
+	if _, isPMT := t.pmtPIDs[pid]; isPMT {
+		return t.handlePMT(payload, unitStart)
+	}
+
+	// Handle elementary streams
+	stream, exist := t.streams[pid]
+	if !exist {
+		return nil
+	}
+
+	if unitStart {
+		t.flush(stream)
+		stream.buffer = append(stream.buffer[:0], payload...)
+	} else if len(stream.buffer) > 0 {
+		stream.buffer = append(stream.buffer, payload...)
+	}
+
+	return nil
+}
+
+func (t *TSReader) handlePAT(payload []byte, unitStart bool) error {
+	section, err := psiSection(payload, unitStart)
+	if err != nil || section == nil {
+		return err
+	}
+
+	// Each program is 4 bytes : 16 bit program number and 13 bit PID
+	for i := 0; i+4 <= len(section); i += 4 {
+		programNumber := uint16(section[i])<<8 | uint16(section[i+1])
+		pid := uint16(section[i+2]&0x1F)<<8 | uint16(section[i+3])
+		if programNumber != 0 {
+			t.pmtPIDs[pid] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func (t *TSReader) handlePMT(payload []byte, unitStart bool) error {
+	section, err := psiSection(payload, unitStart)
+	if err != nil || section == nil {
+		return err
+	}
+
+	if len(section) < 4 {
+		return fmt.Errorf("PMT is too short")
+	}
+
+	// Skip PCR PID and program info
+	programInfoLength := int(section[2]&0x0F)<<8 | int(section[3])
+	i := 4 + programInfoLength
+
+	// Read elementary streams
+	for i+5 <= len(section) {
+		streamType := section[i]
+		pid := uint16(section[i+1]&0x1F)<<8 | uint16(section[i+2])
+		infoLength := int(section[i+3]&0x0F)<<8 | int(section[i+4])
+		i += 5 + infoLength
+
+		var codec CodecType
+		switch streamType {
+		case streamTypeH264:
+			codec = H264
+		case streamTypeH265:
+			codec = H265
+		case streamTypeAAC:
+			codec = AAC
+		default:
+			continue
+		}
+
+		if _, exist := t.streams[pid]; !exist {
+			t.streams[pid] = &pesStream{codec: codec}
+		}
+	}
+
+	return nil
+}
+
+// psiSection returns the content of a PSI section, i.e. the part after
+// the common 8 bytes header and before the CRC. Here we assume that every
+// section fits in a single TS packet, which is the case for PAT and PMT
+// that generated by camera.
+func psiSection(payload []byte, unitStart bool) ([]byte, error) {
+	if !unitStart {
+		return nil, nil
+	}
+
+	pointer := int(payload[0])
+	if 1+pointer+8 > len(payload) {
+		return nil, fmt.Errorf("PSI section is too short")
+	}
+
+	section := payload[1+pointer:]
+	sectionLength := int(section[1]&0x0F)<<8 | int(section[2])
+	end := 3 + sectionLength - 4
+	if end > len(section) || end < 8 {
+		return nil, fmt.Errorf("PSI section length is not valid")
+	}
+
+	return section[8:end], nil
+}
+
+func (t *TSReader) flushAll() {
+	for _, stream := range t.streams {
+		t.flush(stream)
+	}
+}
+
+// flush parses the assembled PES packet then put it into queue.
+func (t *TSReader) flush(stream *pesStream) {
+	pes := stream.buffer
+	stream.buffer = stream.buffer[:0]
+
+	// Make sure it's PES packet
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return
+	}
+
+	// Parse PTS and DTS
+	flags := pes[7] >> 6
+	headerLength := int(pes[8])
+	if 9+headerLength > len(pes) {
+		return
+	}
+
+	var pts, dts int64
+	switch flags {
+	case 0x02:
+		if headerLength < 5 {
+			return
+		}
+		pts = parseTimestamp(pes[9:14])
+		dts = pts
+	case 0x03:
+		if headerLength < 10 {
+			return
+		}
+		pts = parseTimestamp(pes[9:14])
+		dts = parseTimestamp(pes[14:19])
+	default:
+		return
+	}
+
+	data := make([]byte, len(pes)-9-headerLength)
+	copy(data, pes[9+headerLength:])
+
+	packet := &Packet{
+		Codec: stream.codec,
+		PTS:   pts,
+		DTS:   dts,
+		Data:  data,
+	}
+
+	switch stream.codec {
+	case H264:
+		packet.Keyframe = h264IsKeyframe(data)
+	case H265:
+		packet.Keyframe = h265IsKeyframe(data)
+	case AAC:
+		packet.Keyframe = true
+	}
+
+	t.queue = append(t.queue, packet)
+}
+
+func parseTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 |
+		int64(b[1])<<22 |
+		int64(b[2]>>1)<<15 |
+		int64(b[3])<<7 |
+		int64(b[4]>>1)
+}
@@ -0,0 +1,264 @@
+package remux
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	tsPMTPID        = 0x1000
+	tsFirstStreamID = 0x100
+	tsTimestampMask = 1<<33 - 1
+)
+
+// TSWriter muxes packets of elementary streams into MPEG-TS stream. PAT and PMT
+// are written before the first packet, so each writer produces a standalone
+// stream that suitable for HLS segment.
+type TSWriter struct {
+	w             io.Writer
+	codecs        []CodecType
+	counters      map[uint16]byte
+	headerWritten bool
+}
+
+// NewTSWriter returns a new writer that writes MPEG-TS stream into w. The
+// codecs are the elementary streams inside it, with the first one is used
+// as the clock reference.
+func NewTSWriter(w io.Writer, codecs ...CodecType) *TSWriter {
+	return &TSWriter{
+		w:        w,
+		codecs:   codecs,
+		counters: make(map[uint16]byte),
+	}
+}
+
+// WritePacket writes a single packet into stream.
+func (t *TSWriter) WritePacket(p *Packet) error {
+	// Find stream for this packet
+	streamIndex := -1
+	for i, codec := range t.codecs {
+		if codec == p.Codec {
+			streamIndex = i
+			break
+		}
+	}
+
+	if streamIndex < 0 {
+		return fmt.Errorf("codec %d is not declared in this stream", p.Codec)
+	}
+
+	if !t.headerWritten {
+		err := t.writeHeader()
+		if err != nil {
+			return err
+		}
+		t.headerWritten = true
+	}
+
+	// Create PES packet
+	isVideo := p.Codec != AAC
+	streamID := byte(0xC0)
+	if isVideo {
+		streamID = 0xE0
+	}
+
+	pts := p.PTS & tsTimestampMask
+	dts := p.DTS & tsTimestampMask
+
+	header := []byte{0x80, 0x80, 5}
+	timestamps := tsTimestamp(0x2, pts)
+	if pts != dts {
+		header = []byte{0x80, 0xC0, 10}
+		timestamps = append(tsTimestamp(0x3, pts), tsTimestamp(0x1, dts)...)
+	}
+
+	// PES packet length may be zero for video, which means unbounded
+	pesLength := len(header) + len(timestamps) + len(p.Data)
+	if isVideo || pesLength > 0xFFFF {
+		pesLength = 0
+	}
+
+	pes := []byte{0x00, 0x00, 0x01, streamID, byte(pesLength >> 8), byte(pesLength)}
+	pes = append(pes, header...)
+	pes = append(pes, timestamps...)
+	pes = append(pes, p.Data...)
+
+	// Put PCR in the stream that used as clock reference
+	var pcr []byte
+	if streamIndex == 0 {
+		pcr = tsPCR(dts)
+	}
+
+	return t.writePayload(uint16(tsFirstStreamID+streamIndex), pes, pcr, p.Keyframe)
+}
+
+func (t *TSWriter) writeHeader() error {
+	// PAT, which only contains a single program
+	pat := []byte{
+		0x00, 0x01, // transport stream id
+		0xC1, 0x00, 0x00, // version, section number, last section number
+		0x00, 0x01, // program number
+		0xE0 | tsPMTPID>>8, tsPMTPID & 0xFF,
+	}
+
+	err := t.writePayload(0, tsSection(0x00, pat), nil, false)
+	if err != nil {
+		return err
+	}
+
+	// PMT, which lists all elementary streams
+	pcrPID := tsFirstStreamID
+	pmt := []byte{
+		0x00, 0x01, // program number
+		0xC1, 0x00, 0x00, // version, section number, last section number
+		byte(0xE0 | pcrPID>>8), byte(pcrPID & 0xFF),
+		0xF0, 0x00, // program info length
+	}
+
+	for i, codec := range t.codecs {
+		var streamType byte
+		switch codec {
+		case H264:
+			streamType = streamTypeH264
+		case H265:
+			streamType = streamTypeH265
+		case AAC:
+			streamType = streamTypeAAC
+		default:
+			return fmt.Errorf("codec %d is not supported", codec)
+		}
+
+		pid := tsFirstStreamID + i
+		pmt = append(pmt, streamType, byte(0xE0|pid>>8), byte(pid&0xFF), 0xF0, 0x00)
+	}
+
+	return t.writePayload(tsPMTPID, tsSection(0x02, pmt), nil, false)
+}
+
+// writePayload splits payload into TS packets. PCR and random access
+// indicator are put in adaptation field of the first packet.
+func (t *TSWriter) writePayload(pid uint16, payload []byte, pcr []byte, randomAccess bool) error {
+	// PSI section is prefixed by pointer field
+	if pid == 0 || pid == tsPMTPID {
+		payload = append([]byte{0x00}, payload...)
+	}
+
+	first := true
+	for first || len(payload) > 0 {
+		packet := make([]byte, 0, tsPacketSize)
+
+		// Prepare adaptation field
+		var adaptation []byte
+		if first && (pcr != nil || randomAccess) {
+			flags := byte(0)
+			if randomAccess {
+				flags |= 0x40
+			}
+			if pcr != nil {
+				flags |= 0x10
+			}
+			adaptation = append([]byte{flags}, pcr...)
+		}
+
+		// If the remaining payload is not enough to fill
+		// the packet, add stuffing into adaptation field.
+		space := tsPacketSize - 4
+		if adaptation != nil {
+			space -= len(adaptation) + 1
+		}
+
+		if len(payload) < space {
+			stuffing := space - len(payload)
+			if adaptation == nil {
+				adaptation = []byte{}
+				stuffing--
+				if stuffing > 0 {
+					adaptation = append(adaptation, 0x00)
+					stuffing--
+				}
+			}
+
+			for i := 0; i < stuffing; i++ {
+				adaptation = append(adaptation, 0xFF)
+			}
+			space = len(payload)
+		}
+
+		// Write header
+		flags := byte(0)
+		if first {
+			flags = 0x40
+		}
+
+		control := byte(0x10)
+		if adaptation != nil {
+			control = 0x30
+		}
+
+		counter := t.counters[pid]
+		t.counters[pid] = (counter + 1) & 0x0F
+
+		packet = append(packet, tsSyncByte, flags|byte(pid>>8), byte(pid), control|counter)
+		if adaptation != nil {
+			packet = append(packet, byte(len(adaptation)))
+			packet = append(packet, adaptation...)
+		}
+
+		packet = append(packet, payload[:space]...)
+		payload = payload[space:]
+		first = false
+
+		if _, err := t.w.Write(packet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tsSection creates PSI section with its CRC.
+func tsSection(tableID byte, data []byte) []byte {
+	length := len(data) + 4
+	section := []byte{tableID, 0xB0 | byte(length>>8), byte(length)}
+	section = append(section, data...)
+
+	crc := crc32MPEG(section)
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+func tsTimestamp(prefix byte, ts int64) []byte {
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0E | 1,
+		byte(ts >> 22),
+		byte(ts>>14)&0xFE | 1,
+		byte(ts >> 7),
+		byte(ts<<1)&0xFE | 1,
+	}
+}
+
+func tsPCR(base int64) []byte {
+	return []byte{
+		byte(base >> 25),
+		byte(base >> 17),
+		byte(base >> 9),
+		byte(base >> 1),
+		byte(base<<7)&0x80 | 0x7E,
+		0x00,
+	}
+}
+
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,146 @@
+package remux
+
+import (
+	"errors"
+)
+
+var errBitsExhausted = errors.New("bit stream exhausted")
+
+// bitReader reads bits from RBSP, e.g. the content of SPS.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (b *bitReader) readBit() (uint, error) {
+	if b.pos >= len(b.data)*8 {
+		return 0, errBitsExhausted
+	}
+
+	bit := (b.data[b.pos/8] >> (7 - uint(b.pos%8))) & 0x01
+	b.pos++
+	return uint(bit), nil
+}
+
+func (b *bitReader) readBits(n int) (uint, error) {
+	var value uint
+	for i := 0; i < n; i++ {
+		bit, err := b.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<1 | bit
+	}
+
+	return value, nil
+}
+
+func (b *bitReader) skipBits(n int) error {
+	if b.pos+n > len(b.data)*8 {
+		return errBitsExhausted
+	}
+
+	b.pos += n
+	return nil
+}
+
+// readUE reads unsigned Exp-Golomb code.
+func (b *bitReader) readUE() (uint, error) {
+	leadingZeros := 0
+	for {
+		bit, err := b.readBit()
+		if err != nil {
+			return 0, err
+		}
+
+		if bit == 1 {
+			break
+		}
+
+		leadingZeros++
+		if leadingZeros > 31 {
+			return 0, errors.New("exp-golomb code is too long")
+		}
+	}
+
+	value, err := b.readBits(leadingZeros)
+	if err != nil {
+		return 0, err
+	}
+
+	return (1 << uint(leadingZeros)) - 1 + value, nil
+}
+
+// readSE reads signed Exp-Golomb code.
+func (b *bitReader) readSE() (int, error) {
+	value, err := b.readUE()
+	if err != nil {
+		return 0, err
+	}
+
+	if value%2 == 0 {
+		return -int(value / 2), nil
+	}
+
+	return int(value+1) / 2, nil
+}
+
+// removeEmulationPrevention converts NAL unit into RBSP by removing
+// the emulation prevention byte (0x03 in 0x000003).
+func removeEmulationPrevention(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	zeros := 0
+
+	for _, b := range nalu {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+
+		rbsp = append(rbsp, b)
+	}
+
+	return rbsp
+}
+
+// splitAnnexB splits byte stream in Annex B format into NAL units.
+func splitAnnexB(data []byte) [][]byte {
+	nalus := [][]byte{}
+	start := -1
+
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+
+		if start >= 0 {
+			nalus = append(nalus, trimTrailingZeros(data[start:i]))
+		}
+
+		start = i + 3
+		i += 2
+	}
+
+	if start >= 0 && start < len(data) {
+		nalus = append(nalus, data[start:])
+	} else if start < 0 && len(data) > 0 {
+		nalus = append(nalus, data)
+	}
+
+	return nalus
+}
+
+func trimTrailingZeros(data []byte) []byte {
+	end := len(data)
+	for end > 0 && data[end-1] == 0 {
+		end--
+	}
+
+	return data[:end]
+}
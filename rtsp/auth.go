@@ -0,0 +1,151 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// authenticator creates Authorization header following
+// the challenge that sent by server in WWW-Authenticate.
+type authenticator struct {
+	username string
+	password string
+	digest   bool
+	realm    string
+	nonce    string
+	opaque   string
+	qop      string
+	counter  int
+}
+
+// newAuthenticator parses WWW-Authenticate headers. If server offers
+// several methods, digest is preferred since it doesn't send the password.
+func newAuthenticator(challenges []string, username, password string) (*authenticator, error) {
+	auth := &authenticator{
+		username: username,
+		password: password,
+	}
+
+	hasBasic := false
+	for _, challenge := range challenges {
+		scheme := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+		switch strings.ToLower(scheme[0]) {
+		case "basic":
+			hasBasic = true
+		case "digest":
+			if len(scheme) < 2 {
+				continue
+			}
+
+			params := parseAuthParams(scheme[1])
+			if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+				continue
+			}
+
+			auth.digest = true
+			auth.realm = params["realm"]
+			auth.nonce = params["nonce"]
+			auth.opaque = params["opaque"]
+
+			// Only "auth" quality of protection is supported
+			for _, qop := range strings.Split(params["qop"], ",") {
+				if strings.TrimSpace(qop) == "auth" {
+					auth.qop = "auth"
+				}
+			}
+
+			return auth, nil
+		}
+	}
+
+	if !hasBasic {
+		return nil, fmt.Errorf("authentication method is not supported")
+	}
+
+	return auth, nil
+}
+
+// header returns value of Authorization header for the request.
+func (a *authenticator) header(method, uri string) string {
+	if !a.digest {
+		credential := base64.StdEncoding.EncodeToString([]byte(a.username + ":" + a.password))
+		return "Basic " + credential
+	}
+
+	ha1 := md5Hex(a.username + ":" + a.realm + ":" + a.password)
+	ha2 := md5Hex(method + ":" + uri)
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+		a.username, a.realm, a.nonce, uri)
+
+	if a.qop == "" {
+		response := md5Hex(ha1 + ":" + a.nonce + ":" + ha2)
+		header += fmt.Sprintf(`, response="%s"`, response)
+	} else {
+		a.counter++
+		nc := fmt.Sprintf("%08x", a.counter)
+		cnonce := randomHex(8)
+		response := md5Hex(ha1 + ":" + a.nonce + ":" + nc + ":" + cnonce + ":" + a.qop + ":" + ha2)
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s", response="%s"`, a.qop, nc, cnonce, response)
+	}
+
+	if a.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, a.opaque)
+	}
+
+	return header
+}
+
+// parseAuthParams parses comma separated key=value pairs, where the value may be quoted.
+func parseAuthParams(str string) map[string]string {
+	params := make(map[string]string)
+
+	for str != "" {
+		// Read key
+		str = strings.TrimLeft(str, " ,")
+		eq := strings.Index(str, "=")
+		if eq < 0 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(str[:eq]))
+		str = strings.TrimSpace(str[eq+1:])
+
+		// Read value, which may be quoted
+		var value string
+		if strings.HasPrefix(str, `"`) {
+			end := strings.Index(str[1:], `"`)
+			if end < 0 {
+				value, str = str[1:], ""
+			} else {
+				value, str = str[1:end+1], str[end+2:]
+			}
+		} else {
+			end := strings.Index(str, ",")
+			if end < 0 {
+				value, str = str, ""
+			} else {
+				value, str = str[:end], str[end+1:]
+			}
+		}
+
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return params
+}
+
+func md5Hex(str string) string {
+	sum := md5.Sum([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	buffer := make([]byte, n)
+	rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
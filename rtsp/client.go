@@ -0,0 +1,478 @@
+// Package rtsp is a minimal RTSP client that receives the video track of
+// IP camera, then depacketizes its RTP packets into H.264 or H.265 access
+// units, which can be muxed into MPEG-TS using package remux.
+package rtsp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	nurl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+)
+
+// Transport is the lower transport that used to receive RTP packets.
+type Transport int
+
+// List of supported transport.
+const (
+	// TCP receives RTP packets interleaved in the RTSP connection.
+	TCP Transport = iota
+
+	// UDP receives RTP packets in a separate UDP port.
+	UDP
+)
+
+const (
+	defaultPort           = "554"
+	requestTimeout        = 10 * time.Second
+	defaultSessionTimeout = 60 * time.Second
+	maxUDPPacketSize      = 65536
+)
+
+// Client is connection to RTSP server that plays its first video track.
+type Client struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	url       *nurl.URL
+	username  string
+	password  string
+	auth      *authenticator
+	transport Transport
+	cseq      int
+
+	readTimeout    time.Duration
+	session        string
+	sessionTimeout time.Duration
+	lastKeepAlive  time.Time
+
+	track        track
+	depacketizer *depacketizer
+	rtpConn      *net.UDPConn
+	rtcpConn     *net.UDPConn
+	queue        []*remux.Packet
+}
+
+// StatusError is returned when RTSP server responds with status other than 200
+// OK, e.g. 401 Unauthorized when the credential is rejected by server.
+type StatusError struct {
+	Method     string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s request failed: %s", e.Method, e.Status)
+}
+
+// response is the response of RTSP request.
+type response struct {
+	StatusCode int
+	Status     string
+	Header     textproto.MIMEHeader
+	Body       []byte
+}
+
+// Dial connects to RTSP server in rawURL, then starts playing its first video
+// track. If username is empty, the credential inside URL is used instead.
+func Dial(rawURL, username, password string, transport Transport) (*Client, error) {
+	return DialTimeout(rawURL, username, password, transport, requestTimeout, requestTimeout)
+}
+
+// DialTimeout acts like Dial but with custom timeouts. Connect timeout limits
+// the time to connect to server, while read timeout limits the time to wait
+// for RTSP response and for each RTP packet.
+func DialTimeout(rawURL, username, password string, transport Transport, connectTimeout, readTimeout time.Duration) (*Client, error) {
+	// Parse URL and separate its credential
+	url, err := nurl.Parse(rawURL)
+	if err != nil || url.Scheme != "rtsp" || url.Hostname() == "" {
+		return nil, fmt.Errorf("url is not valid")
+	}
+
+	if username == "" && url.User != nil {
+		username = url.User.Username()
+		password, _ = url.User.Password()
+	}
+	url.User = nil
+
+	// Connect to server
+	host := url.Host
+	if url.Port() == "" {
+		host = net.JoinHostPort(url.Hostname(), defaultPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:           conn,
+		reader:         bufio.NewReader(conn),
+		url:            url,
+		username:       username,
+		password:       password,
+		transport:      transport,
+		readTimeout:    readTimeout,
+		sessionTimeout: defaultSessionTimeout,
+	}
+
+	err = c.start()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Codec returns codec of the video track.
+func (c *Client) Codec() remux.CodecType {
+	return c.track.Codec
+}
+
+// ReadPacket returns the next access unit of the video track.
+// PTS and DTS are in 90 kHz clock, following the RTP clock.
+func (c *Client) ReadPacket() (*remux.Packet, error) {
+	for len(c.queue) == 0 {
+		err := c.keepAlive()
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := c.readRTP()
+		if err != nil {
+			return nil, err
+		}
+
+		packet, err := parseRTP(data)
+		if err != nil || packet.PayloadType != c.track.PayloadType {
+			continue
+		}
+
+		c.queue = append(c.queue, c.depacketizer.push(packet)...)
+	}
+
+	packet := c.queue[0]
+	c.queue = c.queue[1:]
+	return packet, nil
+}
+
+// Close stops the session and closes the connection.
+func (c *Client) Close() error {
+	if c.session != "" {
+		c.conn.SetDeadline(time.Now().Add(time.Second))
+		c.writeRequest("TEARDOWN", c.url.String(), nil)
+	}
+
+	if c.rtpConn != nil {
+		c.rtpConn.Close()
+	}
+
+	if c.rtcpConn != nil {
+		c.rtcpConn.Close()
+	}
+
+	return c.conn.Close()
+}
+
+// start sends DESCRIBE, SETUP and PLAY request for the first video track.
+func (c *Client) start() error {
+	// Describe the stream
+	resp, err := c.request("DESCRIBE", c.url.String(), map[string]string{
+		"Accept": "application/sdp",
+	})
+	if err != nil {
+		return err
+	}
+
+	baseURL := c.url
+	for _, key := range []string{"Content-Base", "Content-Location"} {
+		if value := resp.Header.Get(key); value != "" {
+			if parsed, err := nurl.Parse(value); err == nil {
+				parsed.User = nil
+				baseURL = parsed
+				break
+			}
+		}
+	}
+
+	// Find the video track
+	sdp := parseSDP(resp.Body)
+	found := false
+	for _, t := range sdp.Tracks {
+		if t.Media == "video" && t.Codec != 0 {
+			c.track = t
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("stream doesn't have H.264 or H.265 video track")
+	}
+
+	if c.track.ClockRate != 0 && c.track.ClockRate != 90000 {
+		return fmt.Errorf("video clock rate %d is not supported", c.track.ClockRate)
+	}
+
+	c.depacketizer = newDepacketizer(c.track)
+
+	// Setup the track
+	transportHeader := "RTP/AVP/TCP;unicast;interleaved=0-1"
+	if c.transport == UDP {
+		err = c.listenUDP()
+		if err != nil {
+			return err
+		}
+
+		rtpPort := c.rtpConn.LocalAddr().(*net.UDPAddr).Port
+		transportHeader = fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d", rtpPort, rtpPort+1)
+	}
+
+	resp, err = c.request("SETUP", controlURL(baseURL, c.track.Control), map[string]string{
+		"Transport": transportHeader,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Save the session, which may contains its timeout
+	sessionParts := strings.Split(resp.Header.Get("Session"), ";")
+	c.session = strings.TrimSpace(sessionParts[0])
+	for _, part := range sessionParts[1:] {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "timeout=") {
+			if timeout, err := strconv.Atoi(strings.TrimPrefix(part, "timeout=")); err == nil && timeout > 0 {
+				c.sessionTimeout = time.Duration(timeout) * time.Second
+			}
+		}
+	}
+
+	// Start playing
+	_, err = c.request("PLAY", controlURL(baseURL, sdp.Control), map[string]string{
+		"Range": "npt=0.000-",
+	})
+	if err != nil {
+		return err
+	}
+
+	c.lastKeepAlive = time.Now()
+	return nil
+}
+
+// listenUDP opens a pair of UDP ports for RTP and RTCP.
+// Following the convention, port for RTP must be even.
+func (c *Client) listenUDP() error {
+	for i := 0; i < 10; i++ {
+		rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{})
+		if err != nil {
+			return err
+		}
+
+		rtpPort := rtpConn.LocalAddr().(*net.UDPAddr).Port
+		if rtpPort%2 != 0 {
+			rtpConn.Close()
+			continue
+		}
+
+		rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: rtpPort + 1})
+		if err != nil {
+			rtpConn.Close()
+			continue
+		}
+
+		c.rtpConn = rtpConn
+		c.rtcpConn = rtcpConn
+		return nil
+	}
+
+	return fmt.Errorf("failed to find free UDP port")
+}
+
+// keepAlive sends OPTIONS request periodically, so the session is not expired.
+func (c *Client) keepAlive() error {
+	if time.Since(c.lastKeepAlive) < c.sessionTimeout/2 {
+		return nil
+	}
+
+	c.lastKeepAlive = time.Now()
+
+	// In TCP, the response will be skipped when reading RTP packets
+	if c.transport == TCP {
+		c.conn.SetWriteDeadline(time.Now().Add(c.readTimeout))
+		return c.writeRequest("OPTIONS", c.url.String(), nil)
+	}
+
+	_, err := c.request("OPTIONS", c.url.String(), nil)
+	return err
+}
+
+// readRTP reads the next RTP packet of the video track.
+func (c *Client) readRTP() ([]byte, error) {
+	if c.transport == UDP {
+		buffer := make([]byte, maxUDPPacketSize)
+		c.rtpConn.SetReadDeadline(time.Now().Add(c.readTimeout))
+		n, _, err := c.rtpConn.ReadFromUDP(buffer)
+		if err != nil {
+			return nil, err
+		}
+		return buffer[:n], nil
+	}
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+		firstByte, err := c.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip response of RTSP request, e.g. for keep alive
+		if firstByte[0] != '$' {
+			if _, err = c.readResponse(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Interleaved frame: $, channel, 16-bit length, then the data
+		header := make([]byte, 4)
+		if _, err = io.ReadFull(c.reader, header); err != nil {
+			return nil, err
+		}
+
+		length := int(header[2])<<8 | int(header[3])
+		data := make([]byte, length)
+		if _, err = io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+
+		// Channel 0 is for RTP, while channel 1 is for RTCP
+		if header[1] == 0 {
+			return data, nil
+		}
+	}
+}
+
+// request sends RTSP request and reads its response. If server asks for
+// authentication, the request is resent with the credential.
+func (c *Client) request(method, uri string, headers map[string]string) (*response, error) {
+	for {
+		c.conn.SetDeadline(time.Now().Add(c.readTimeout))
+		err := c.writeRequest(method, uri, headers)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == 401 && c.auth == nil && c.username != "" {
+			c.auth, err = newAuthenticator(resp.Header["Www-Authenticate"], c.username, c.password)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode != 200 {
+			return nil, &StatusError{
+				Method:     method,
+				StatusCode: resp.StatusCode,
+				Status:     resp.Status,
+			}
+		}
+
+		return resp, nil
+	}
+}
+
+func (c *Client) writeRequest(method, uri string, headers map[string]string) error {
+	c.cseq++
+
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s %s RTSP/1.0\r\n", method, uri))
+	sb.WriteString(fmt.Sprintf("CSeq: %d\r\n", c.cseq))
+	sb.WriteString("User-Agent: cygnus-nvr\r\n")
+
+	if c.auth != nil {
+		sb.WriteString(fmt.Sprintf("Authorization: %s\r\n", c.auth.header(method, uri)))
+	}
+
+	if c.session != "" {
+		sb.WriteString(fmt.Sprintf("Session: %s\r\n", c.session))
+	}
+
+	for key, value := range headers {
+		sb.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+	}
+
+	sb.WriteString("\r\n")
+
+	_, err := c.conn.Write([]byte(sb.String()))
+	return err
+}
+
+func (c *Client) readResponse() (*response, error) {
+	reader := textproto.NewReader(c.reader)
+
+	// Parse status line, e.g. RTSP/1.0 200 OK
+	statusLine, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(statusLine, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "RTSP/") {
+		return nil, fmt.Errorf("invalid response: %s", statusLine)
+	}
+
+	statusCode, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %s", statusLine)
+	}
+
+	// Parse header and body
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if length, _ := strconv.Atoi(header.Get("Content-Length")); length > 0 {
+		body = make([]byte, length)
+		if _, err = io.ReadFull(c.reader, body); err != nil {
+			return nil, err
+		}
+	}
+
+	return &response{
+		StatusCode: statusCode,
+		Status:     strings.Join(parts[1:], " "),
+		Header:     header,
+		Body:       body,
+	}, nil
+}
+
+// controlURL resolves control attribute of SDP against the base URL.
+func controlURL(baseURL *nurl.URL, control string) string {
+	base := baseURL.String()
+	switch {
+	case control == "" || control == "*":
+		return base
+	case strings.HasPrefix(control, "rtsp://"):
+		return control
+	case strings.HasSuffix(base, "/"):
+		return base + control
+	default:
+		return base + "/" + control
+	}
+}
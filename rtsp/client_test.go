@@ -0,0 +1,341 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+)
+
+const (
+	testRealm      = "camera"
+	testNonce      = "0a4f113b"
+	testUsername   = "admin"
+	testPassword   = "secret"
+	testFrameCount = 150
+	testGOPSize    = 30
+)
+
+var (
+	testSPS = []byte{0x67, 0x42, 0xC0, 0x1E, 0xDA, 0x02, 0x80, 0xBF, 0xE5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xF0, 0x3C, 0x58, 0xBA, 0x80}
+	testPPS = []byte{0x68, 0xCE, 0x3C, 0x80}
+)
+
+// testServer is in-process RTSP server that requires digest authentication.
+// It serves SDP with an audio track before the H.264 video track, then streams
+// the video as FU-A fragmented keyframes and STAP-A aggregated frames. Both
+// sequence number and timestamp of RTP are wrapped in the middle of stream.
+type testServer struct {
+	t        *testing.T
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &testServer{t: t, listener: listener}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	return s
+}
+
+func (s *testServer) URL(username, password string) string {
+	return fmt.Sprintf("rtsp://%s:%s@%s/stream", username, password, s.listener.Addr())
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Requests and interleaved packets are written from different goroutines
+	var writeLock sync.Mutex
+	write := func(data []byte) {
+		writeLock.Lock()
+		conn.Write(data)
+		writeLock.Unlock()
+	}
+
+	reply := func(cseq string, headers string, body string) {
+		response := fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\n%s", cseq, headers)
+		if body != "" {
+			response += fmt.Sprintf("Content-Length: %d\r\n", len(body))
+		}
+		write([]byte(response + "\r\n" + body))
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	streamDone := make(chan struct{})
+	defer func() { <-streamDone }()
+	streamStarted := false
+
+	var clientPort int
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			break
+		}
+
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			break
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			s.t.Errorf("request line is not valid: %q", line)
+			break
+		}
+
+		method, uri, cseq := fields[0], fields[1], header.Get("CSeq")
+
+		// Check credential in digest authentication
+		expected := md5Hex(md5Hex(testUsername+":"+testRealm+":"+testPassword) + ":" + testNonce + ":" + md5Hex(method+":"+uri))
+		if !strings.Contains(header.Get("Authorization"), `response="`+expected+`"`) {
+			write([]byte(fmt.Sprintf("RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\n"+
+				"WWW-Authenticate: Basic realm=\"%s\"\r\n"+
+				"WWW-Authenticate: Digest realm=\"%s\", nonce=\"%s\"\r\n\r\n", cseq, testRealm, testRealm, testNonce)))
+			continue
+		}
+
+		switch method {
+		case "OPTIONS":
+			reply(cseq, "", "")
+
+		case "DESCRIBE":
+			sdp := "v=0\r\ns=Camera\r\na=control:*\r\n" +
+				"m=audio 0 RTP/AVP 97\r\na=rtpmap:97 MPEG4-GENERIC/16000\r\na=control:trackID=0\r\n" +
+				"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n" +
+				"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=" +
+				base64.StdEncoding.EncodeToString(testSPS) + "," + base64.StdEncoding.EncodeToString(testPPS) + "\r\n" +
+				"a=control:trackID=1\r\n"
+			reply(cseq, "Content-Type: application/sdp\r\nContent-Base: "+uri+"/\r\n", sdp)
+
+		case "SETUP":
+			if !strings.HasSuffix(uri, "/stream/trackID=1") {
+				s.t.Errorf("SETUP is not for video track: %s", uri)
+			}
+
+			transport := header.Get("Transport")
+			if idx := strings.Index(transport, "client_port="); idx >= 0 {
+				fmt.Sscanf(transport[idx+len("client_port="):], "%d", &clientPort)
+			}
+			reply(cseq, "Session: 12345678;timeout=60\r\nTransport: "+transport+"\r\n", "")
+
+		case "PLAY":
+			reply(cseq, "Session: 12345678\r\n", "")
+
+			// Send RTP packets over UDP if client asked for it,
+			// otherwise interleave them in RTSP connection.
+			send := func(packet []byte) {
+				frame := []byte{'$', 0, byte(len(packet) >> 8), byte(len(packet))}
+				write(append(frame, packet...))
+			}
+
+			var udpConn net.Conn
+			if clientPort > 0 {
+				udpConn, err = net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", clientPort))
+				if err != nil {
+					s.t.Errorf("failed to connect to client port: %v", err)
+					break
+				}
+
+				send = func(packet []byte) {
+					udpConn.Write(packet)
+				}
+			}
+
+			streamStarted = true
+			go func() {
+				defer close(streamDone)
+				streamTestVideo(send)
+				if udpConn != nil {
+					udpConn.Close()
+				}
+			}()
+
+		case "TEARDOWN":
+			reply(cseq, "Session: 12345678\r\n", "")
+		}
+	}
+
+	if !streamStarted {
+		close(streamDone)
+	}
+}
+
+// streamTestVideo sends test video at 30 FPS as RTP packets.
+func streamTestVideo(send func([]byte)) {
+	sequence := uint16(65530)
+	timestamp := uint32(1<<32 - 10*3000)
+
+	for i := 0; i < testFrameCount; i++ {
+		if i%testGOPSize == 0 {
+			// IDR that split into FU-A fragments
+			body := bytes.Repeat([]byte{0xAA}, 3000)
+			for offset := 0; offset < len(body); offset += 1000 {
+				header := byte(5)
+				if offset == 0 {
+					header |= 0x80
+				}
+
+				end := offset + 1000
+				if end >= len(body) {
+					end = len(body)
+					header |= 0x40
+				}
+
+				payload := append([]byte{0x7C, header}, body[offset:end]...)
+				send(testRTPPacket(sequence, timestamp, end == len(body), payload))
+				sequence++
+			}
+		} else {
+			// Two slices that aggregated in STAP-A
+			payload := []byte{24, 0, 4, 0x41, 1, 2, 3, 0, 3, 0x41, 4, 5}
+			send(testRTPPacket(sequence, timestamp, true, payload))
+			sequence++
+		}
+
+		timestamp += 3000
+	}
+}
+
+func testRTPPacket(sequence uint16, timestamp uint32, marker bool, payload []byte) []byte {
+	header := make([]byte, 12)
+	header[0] = 0x80
+	header[1] = 96
+	if marker {
+		header[1] |= 0x80
+	}
+
+	binary.BigEndian.PutUint16(header[2:], sequence)
+	binary.BigEndian.PutUint32(header[4:], timestamp)
+	binary.BigEndian.PutUint32(header[8:], 0x1234)
+	return append(header, payload...)
+}
+
+func testClient(t *testing.T, transport Transport) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	client, err := Dial(server.URL(testUsername, testPassword), "", "", transport)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	if codec := client.Codec(); codec != remux.H264 {
+		t.Fatalf("got codec %d, want H.264", codec)
+	}
+
+	buffer := &bytes.Buffer{}
+	writer := remux.NewTSWriter(buffer, client.Codec())
+
+	keyframes := 0
+	var lastPTS int64
+	for i := 0; i < testFrameCount; i++ {
+		packet, err := client.ReadPacket()
+		if err != nil {
+			t.Fatalf("failed to read packet %d: %v", i, err)
+		}
+
+		// Timestamp must keep increasing even after RTP timestamp is wrapped
+		if i > 0 && packet.PTS-lastPTS != 3000 {
+			t.Fatalf("packet %d: got PTS %d after %d, want increment of 3000", i, packet.PTS, lastPTS)
+		}
+		lastPTS = packet.PTS
+
+		isKeyframe := i%testGOPSize == 0
+		if packet.Keyframe != isKeyframe {
+			t.Fatalf("packet %d: got keyframe %t, want %t", i, packet.Keyframe, isKeyframe)
+		}
+
+		if isKeyframe {
+			keyframes++
+			if !bytes.Contains(packet.Data, testSPS) || !bytes.Contains(packet.Data, testPPS) {
+				t.Fatalf("packet %d: keyframe doesn't have SPS and PPS", i)
+			}
+
+			idr := append([]byte{0, 0, 0, 1, 0x65}, bytes.Repeat([]byte{0xAA}, 3000)...)
+			if !bytes.Contains(packet.Data, idr) {
+				t.Fatalf("packet %d: FU-A fragments are not reassembled", i)
+			}
+		} else if !bytes.Contains(packet.Data, []byte{0, 0, 0, 1, 0x41, 1, 2, 3, 0, 0, 0, 1, 0x41, 4, 5}) {
+			t.Fatalf("packet %d: STAP-A is not unpacked: % x", i, packet.Data)
+		}
+
+		err = writer.WritePacket(packet)
+		if err != nil {
+			t.Fatalf("failed to write packet %d: %v", i, err)
+		}
+	}
+
+	if want := testFrameCount / testGOPSize; keyframes != want {
+		t.Errorf("got %d keyframes, want %d", keyframes, want)
+	}
+
+	// The packets must be muxed into segment that can be played in browser
+	mp4 := &bytes.Buffer{}
+	err = remux.Remux(mp4, bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to remux: %v", err)
+	}
+
+	if mp4.Len() == 0 {
+		t.Error("remuxed segment is empty")
+	}
+}
+
+func TestClientTCP(t *testing.T) {
+	testClient(t, TCP)
+}
+
+func TestClientUDP(t *testing.T) {
+	testClient(t, UDP)
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	client, err := Dial(server.URL(testUsername, "wrong"), "", "", TCP)
+	if err == nil {
+		client.Close()
+		t.Fatal("dial succeed with wrong password")
+	}
+
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.StatusCode != 401 {
+		t.Fatalf("got error %v, want 401 status error", err)
+	}
+}
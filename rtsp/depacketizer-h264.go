@@ -0,0 +1,89 @@
+package rtsp
+
+import "encoding/binary"
+
+// Type of NAL units in H.264
+const (
+	h264NALIDR   = 5
+	h264NALSPS   = 7
+	h264NALPPS   = 8
+	h264NALAUD   = 9
+	h264NALSTAPA = 24
+	h264NALFUA   = 28
+)
+
+// h264Codec depacketizes H.264 following RFC 6184.
+type h264Codec struct{}
+
+func (h264Codec) unpack(payload []byte, fragment *[]byte) [][]byte {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	switch int(payload[0] & 0x1F) {
+	case h264NALSTAPA:
+		// Aggregation packet, contains several NAL units prefixed by their size
+		var nalus [][]byte
+		payload = payload[1:]
+		for len(payload) >= 2 {
+			size := int(binary.BigEndian.Uint16(payload))
+			if len(payload) < 2+size {
+				break
+			}
+
+			if size > 0 {
+				nalus = append(nalus, payload[2:2+size])
+			}
+			payload = payload[2+size:]
+		}
+		return nalus
+
+	case h264NALFUA:
+		// Fragmentation unit, the original NAL header is rebuilt
+		// from the FU indicator and FU header.
+		if len(payload) < 2 {
+			return nil
+		}
+
+		indicator, header := payload[0], payload[1]
+		start := header&0x80 != 0
+		end := header&0x40 != 0
+
+		if start {
+			*fragment = []byte{indicator&0xE0 | header&0x1F}
+		} else if *fragment == nil {
+			return nil
+		}
+
+		*fragment = append(*fragment, payload[2:]...)
+		if !end {
+			return nil
+		}
+
+		nalu := *fragment
+		*fragment = nil
+		return [][]byte{nalu}
+
+	default:
+		return [][]byte{payload}
+	}
+}
+
+func (h264Codec) nalType(nalu []byte) int {
+	if len(nalu) == 0 {
+		return -1
+	}
+	return int(nalu[0] & 0x1F)
+}
+
+func (h264Codec) parameterTypes() []int {
+	return []int{h264NALSPS, h264NALPPS}
+}
+
+func (h264Codec) isKeyframe(nalType int) bool {
+	return nalType == h264NALIDR
+}
+
+func (h264Codec) accessUnitDelimiter() []byte {
+	return []byte{h264NALAUD, 0xF0}
+}
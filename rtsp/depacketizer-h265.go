@@ -0,0 +1,91 @@
+package rtsp
+
+import "encoding/binary"
+
+// Type of NAL units in H.265
+const (
+	h265NALBLAWLP = 16
+	h265NALCRA    = 21
+	h265NALVPS    = 32
+	h265NALSPS    = 33
+	h265NALPPS    = 34
+	h265NALAUD    = 35
+	h265NALAP     = 48
+	h265NALFU     = 49
+)
+
+// h265Codec depacketizes H.265 following RFC 7798.
+type h265Codec struct{}
+
+func (c h265Codec) unpack(payload []byte, fragment *[]byte) [][]byte {
+	if len(payload) < 2 {
+		return nil
+	}
+
+	switch c.nalType(payload) {
+	case h265NALAP:
+		// Aggregation packet, contains several NAL units prefixed by their size
+		var nalus [][]byte
+		payload = payload[2:]
+		for len(payload) >= 2 {
+			size := int(binary.BigEndian.Uint16(payload))
+			if len(payload) < 2+size {
+				break
+			}
+
+			if size > 0 {
+				nalus = append(nalus, payload[2:2+size])
+			}
+			payload = payload[2+size:]
+		}
+		return nalus
+
+	case h265NALFU:
+		// Fragmentation unit, the original NAL header is rebuilt
+		// from the payload header and FU header.
+		if len(payload) < 3 {
+			return nil
+		}
+
+		header := payload[2]
+		start := header&0x80 != 0
+		end := header&0x40 != 0
+
+		if start {
+			*fragment = []byte{payload[0]&0x81 | (header&0x3F)<<1, payload[1]}
+		} else if *fragment == nil {
+			return nil
+		}
+
+		*fragment = append(*fragment, payload[3:]...)
+		if !end {
+			return nil
+		}
+
+		nalu := *fragment
+		*fragment = nil
+		return [][]byte{nalu}
+
+	default:
+		return [][]byte{payload}
+	}
+}
+
+func (h265Codec) nalType(nalu []byte) int {
+	if len(nalu) == 0 {
+		return -1
+	}
+	return int(nalu[0]>>1) & 0x3F
+}
+
+func (h265Codec) parameterTypes() []int {
+	return []int{h265NALVPS, h265NALSPS, h265NALPPS}
+}
+
+func (h265Codec) isKeyframe(nalType int) bool {
+	return nalType >= h265NALBLAWLP && nalType <= h265NALCRA
+}
+
+func (h265Codec) accessUnitDelimiter() []byte {
+	return []byte{h265NALAUD << 1, 0x01, 0x50}
+}
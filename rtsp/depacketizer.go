@@ -0,0 +1,163 @@
+package rtsp
+
+import (
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+)
+
+var annexBStartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// videoCodec is the codec specific part of depacketizer.
+type videoCodec interface {
+	// unpack extracts NAL units from RTP payload. Fragmented NAL unit
+	// is collected in fragment, and only returned once it's complete.
+	unpack(payload []byte, fragment *[]byte) [][]byte
+
+	// nalType returns type of NAL unit.
+	nalType(nalu []byte) int
+
+	// parameterTypes returns type of NAL units that contain parameter sets.
+	parameterTypes() []int
+
+	// isKeyframe checks if NAL unit type is a random access point.
+	isKeyframe(nalType int) bool
+
+	// accessUnitDelimiter returns NAL unit for marking start of access unit.
+	accessUnitDelimiter() []byte
+}
+
+// depacketizer assembles RTP packets of a video track into access units.
+type depacketizer struct {
+	codec     remux.CodecType
+	impl      videoCodec
+	params    map[int][]byte
+	nalus     [][]byte
+	fragment  []byte
+	timestamp uint32
+	sequence  uint16
+	started   bool
+
+	// Extended RTP timestamp, so it won't wrap around
+	extendedTimestamp int64
+}
+
+func newDepacketizer(t track) *depacketizer {
+	d := &depacketizer{
+		codec:  t.Codec,
+		params: make(map[int][]byte),
+	}
+
+	switch t.Codec {
+	case remux.H265:
+		d.impl = h265Codec{}
+	default:
+		d.impl = h264Codec{}
+	}
+
+	// Parameter sets from SDP are used until the stream sends its own
+	for _, param := range t.Parameters {
+		d.params[d.impl.nalType(param)] = param
+	}
+
+	return d
+}
+
+// push adds RTP packet into depacketizer, then returns the completed access units.
+func (d *depacketizer) push(packet rtpPacket) []*remux.Packet {
+	var packets []*remux.Packet
+
+	// If some packets are lost, the fragmented NAL unit can't be used
+	if d.started && packet.Sequence != d.sequence+1 {
+		d.fragment = nil
+	}
+
+	// New timestamp means new access unit, even when marker is missing
+	if d.started && packet.Timestamp != d.timestamp && len(d.nalus) > 0 {
+		if p := d.flush(); p != nil {
+			packets = append(packets, p)
+		}
+	}
+
+	if !d.started {
+		d.extendedTimestamp = int64(packet.Timestamp)
+	} else {
+		d.extendedTimestamp += int64(int32(packet.Timestamp - d.timestamp))
+	}
+
+	d.started = true
+	d.sequence = packet.Sequence
+	d.timestamp = packet.Timestamp
+	d.nalus = append(d.nalus, d.impl.unpack(packet.Payload, &d.fragment)...)
+
+	// Marker is set in the last packet of access unit
+	if packet.Marker {
+		if p := d.flush(); p != nil {
+			packets = append(packets, p)
+		}
+	}
+
+	return packets
+}
+
+// flush creates packet from the collected NAL units in Annex B format. For
+// keyframe, the parameter sets are inserted when they are not in the stream.
+func (d *depacketizer) flush() *remux.Packet {
+	nalus := d.nalus
+	d.nalus = nil
+
+	if len(nalus) == 0 {
+		return nil
+	}
+
+	keyframe := false
+	existingTypes := make(map[int]struct{})
+	for _, nalu := range nalus {
+		nalType := d.impl.nalType(nalu)
+		existingTypes[nalType] = struct{}{}
+
+		if d.impl.isKeyframe(nalType) {
+			keyframe = true
+		}
+	}
+
+	// Save the parameter sets from stream
+	for _, nalType := range d.impl.parameterTypes() {
+		if _, exist := existingTypes[nalType]; exist {
+			for _, nalu := range nalus {
+				if d.impl.nalType(nalu) == nalType {
+					d.params[nalType] = nalu
+				}
+			}
+		}
+	}
+
+	// Create access unit
+	aud := d.impl.accessUnitDelimiter()
+	data := append(append([]byte{}, annexBStartCode...), aud...)
+
+	if keyframe {
+		for _, nalType := range d.impl.parameterTypes() {
+			_, exist := existingTypes[nalType]
+			if param, cached := d.params[nalType]; cached && !exist {
+				data = append(data, annexBStartCode...)
+				data = append(data, param...)
+			}
+		}
+	}
+
+	for _, nalu := range nalus {
+		if d.impl.nalType(nalu) == d.impl.nalType(aud) {
+			continue
+		}
+
+		data = append(data, annexBStartCode...)
+		data = append(data, nalu...)
+	}
+
+	return &remux.Packet{
+		Codec:    d.codec,
+		PTS:      d.extendedTimestamp,
+		DTS:      d.extendedTimestamp,
+		Data:     data,
+		Keyframe: keyframe,
+	}
+}
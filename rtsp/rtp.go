@@ -0,0 +1,50 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// rtpPacket is the parsed RTP packet.
+type rtpPacket struct {
+	Marker      bool
+	PayloadType int
+	Sequence    uint16
+	Timestamp   uint32
+	Payload     []byte
+}
+
+func parseRTP(data []byte) (rtpPacket, error) {
+	if len(data) < 12 || data[0]>>6 != 2 {
+		return rtpPacket{}, fmt.Errorf("invalid RTP packet")
+	}
+
+	packet := rtpPacket{
+		Marker:      data[1]&0x80 != 0,
+		PayloadType: int(data[1] & 0x7F),
+		Sequence:    binary.BigEndian.Uint16(data[2:4]),
+		Timestamp:   binary.BigEndian.Uint32(data[4:8]),
+	}
+
+	// Skip CSRC and header extension
+	offset := 12 + int(data[0]&0x0F)*4
+	if data[0]&0x10 != 0 {
+		if len(data) < offset+4 {
+			return rtpPacket{}, fmt.Errorf("invalid RTP packet")
+		}
+		offset += 4 + int(binary.BigEndian.Uint16(data[offset+2:offset+4]))*4
+	}
+
+	// Remove padding
+	end := len(data)
+	if data[0]&0x20 != 0 && end > 0 {
+		end -= int(data[end-1])
+	}
+
+	if offset > end {
+		return rtpPacket{}, fmt.Errorf("invalid RTP packet")
+	}
+
+	packet.Payload = data[offset:end]
+	return packet, nil
+}
@@ -0,0 +1,123 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/RadhiFadlillah/cygnus-nvr/remux"
+)
+
+// track is a media stream that described in SDP.
+type track struct {
+	Media       string
+	PayloadType int
+	Codec       remux.CodecType
+	ClockRate   int
+	Control     string
+	Parameters  [][]byte
+}
+
+// sessionDescription is the parsed content of SDP.
+type sessionDescription struct {
+	Control string
+	Tracks  []track
+}
+
+func parseSDP(content []byte) sessionDescription {
+	sdp := sessionDescription{}
+	var current *track
+
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+
+		key, value := line[0], line[2:]
+		switch {
+		case key == 'm':
+			// m=<media> <port> <proto> <payload type>
+			fields := strings.Fields(value)
+			if len(fields) < 4 {
+				current = nil
+				continue
+			}
+
+			payloadType, _ := strconv.Atoi(fields[3])
+			sdp.Tracks = append(sdp.Tracks, track{
+				Media:       fields[0],
+				PayloadType: payloadType,
+			})
+			current = &sdp.Tracks[len(sdp.Tracks)-1]
+
+		case key == 'a' && strings.HasPrefix(value, "control:"):
+			control := strings.TrimPrefix(value, "control:")
+			if current == nil {
+				sdp.Control = control
+			} else {
+				current.Control = control
+			}
+
+		case key == 'a' && strings.HasPrefix(value, "rtpmap:") && current != nil:
+			// a=rtpmap:<payload type> <encoding>/<clock rate>
+			fields := strings.Fields(strings.TrimPrefix(value, "rtpmap:"))
+			if len(fields) < 2 {
+				continue
+			}
+
+			if payloadType, _ := strconv.Atoi(fields[0]); payloadType != current.PayloadType {
+				continue
+			}
+
+			encoding := strings.Split(fields[1], "/")
+			switch strings.ToUpper(encoding[0]) {
+			case "H264":
+				current.Codec = remux.H264
+			case "H265", "HEVC":
+				current.Codec = remux.H265
+			}
+
+			if len(encoding) > 1 {
+				current.ClockRate, _ = strconv.Atoi(encoding[1])
+			}
+
+		case key == 'a' && strings.HasPrefix(value, "fmtp:") && current != nil:
+			// a=fmtp:<payload type> <key>=<value>;...
+			fields := strings.SplitN(strings.TrimPrefix(value, "fmtp:"), " ", 2)
+			if len(fields) < 2 {
+				continue
+			}
+
+			params := make(map[string]string)
+			for _, param := range strings.Split(fields[1], ";") {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 {
+					params[strings.ToLower(kv[0])] = kv[1]
+				}
+			}
+
+			// Parameter sets, so decoder can be started without waiting them in stream
+			var encodedParams []string
+			if value := params["sprop-parameter-sets"]; value != "" {
+				encodedParams = strings.Split(value, ",")
+			}
+
+			for _, key := range []string{"sprop-vps", "sprop-sps", "sprop-pps"} {
+				if value := params[key]; value != "" {
+					encodedParams = append(encodedParams, value)
+				}
+			}
+
+			for _, encoded := range encodedParams {
+				param, err := base64.StdEncoding.DecodeString(encoded)
+				if err == nil && len(param) > 0 {
+					current.Parameters = append(current.Parameters, param)
+				}
+			}
+		}
+	}
+
+	return sdp
+}
@@ -1,6 +1,7 @@
 var template = `
 <div class="cygnus-video-box">
-    <video ref="videoPlayer" class="cygnus-video video-js">
+    <img v-if="mjpeg" class="cygnus-video" :src="imageURL">
+    <video v-else ref="videoPlayer" class="cygnus-video video-js">
         <source :src="url" type="application/x-mpegURL">
         <p class="vjs-no-js">
             To view this video please enable JavaScript, and consider upgrading to a web browser that
@@ -9,6 +10,9 @@ var template = `
     </video>
     <div class="cygnus-video-menu">
         <p>{{name}}</p>
+        <i v-if="status && status.state !== 'online' && status.state !== 'unknown'" 
+            class="fas fa-fw fa-exclamation-triangle cygnus-video-status" 
+            :title="statusText"></i>
         <a href="#" @click="refreshVideo">
             <i class="fas fa-fw fa-redo-alt"></i>
         </a>
@@ -26,6 +30,8 @@ export default {
     props: {
         url: String,
         name: String,
+        mjpeg: Boolean,
+        status: Object,
         options: {
             type: Object,
             default () {
@@ -35,10 +41,14 @@ export default {
     },
     data() {
         return {
-            player: null
+            player: null,
+            imageURL: this.url,
         }
     },
     mounted() {
+        // MJPEG stream is shown as plain image
+        if (this.mjpeg) return;
+
         this.player = videojs(this.$refs.videoPlayer, {
             controls: true,
             preload: "auto",
@@ -59,8 +69,22 @@ export default {
             this.player.dispose()
         }
     },
+    computed: {
+        statusText() {
+            var text = this.status.state === "auth-failed" ? "Camera rejected the credential" : "Camera is offline";
+            if (this.status.lastSeen && !this.status.lastSeen.startsWith("0001-")) {
+                text += `, last seen at ${new Date(this.status.lastSeen).toLocaleString()}`;
+            }
+            return text;
+        }
+    },
     methods: {
         refreshVideo() {
+            if (this.mjpeg) {
+                this.imageURL = `${this.url}?t=${Date.now()}`;
+                return;
+            }
+
             if (this.player) {
                 this.player.reset();
                 this.player.src({
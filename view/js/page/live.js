@@ -5,17 +5,36 @@ var template = `
         <a href="#" title="Refresh storage" @click="loadCameras">
             <i class="fas fa-fw fa-sync-alt"></i>
         </a>
+        <a href="#" title="Discover cameras" @click="showDialogDiscoverCameras">
+            <i class="fas fa-fw fa-search"></i>
+        </a>
         <a href="#" title="Add camera" @click="showDialogInputCamera()">
             <i class="fas fa-fw fa-plus-circle"></i>
         </a>
     </div>
     <div class="video-container">
-        <video-player v-for="(name, id) in cameras" 
+        <ul class="discovered-cameras" v-if="discovered.length > 0">
+            <li v-for="(candidate, index) in discovered" :key="candidate.address">
+                <p>
+                    {{candidate.name}} ({{candidate.address}})
+                    <span v-if="candidate.error" class="error"><br>{{candidate.error}}</span>
+                </p>
+                <a v-if="!candidate.error" href="#" title="Add camera" @click="showDialogInputCamera(undefined, candidate)">
+                    <i class="fas fa-fw fa-plus-circle"></i>
+                </a>
+                <a href="#" title="Dismiss" @click="discovered.splice(index, 1)">
+                    <i class="fas fa-fw fa-times"></i>
+                </a>
+            </li>
+        </ul>
+        <video-player v-for="(camera, id) in cameras" 
             :key="id" 
-            :name="name"
-            @edit="showDialogInputCamera(id, name)"
-            @delete="showDialogDeleteCamera(id, name)"
-            :url="'/cam/'+id+'/live/playlist'" >
+            :name="camera.name"
+            :mjpeg="camera.type === 'mjpeg'"
+            :status="statuses[id]"
+            @edit="showDialogInputCamera(id, camera)"
+            @delete="showDialogDeleteCamera(id, camera.name)"
+            :url="liveURL(id, camera)" >
         </video-player>
     </div>
     <div class="loading-overlay" v-if="loading"><i class="fas fa-fw fa-spin fa-spinner"></i></div>
@@ -36,10 +55,29 @@ export default {
     data() {
         return {
             cameras: {},
+            discovered: [],
+            statuses: {},
+            statusInterval: null,
             loading: false,
         }
     },
     methods: {
+        liveURL(id, camera) {
+            // MJPEG camera is served as it is, the others as HLS
+            if (camera.type === "mjpeg") return `/cam/${id}/live/mjpeg`;
+            return `/cam/${id}/live/playlist`;
+        },
+        loadStatuses() {
+            fetch("/api/camera/status", { credentials: "include" })
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.statuses = json;
+                })
+                .catch(() => {});
+        },
         loadCameras() {
             this.loading = true;
 
@@ -59,32 +97,51 @@ export default {
                     })
                 });
         },
-        showDialogInputCamera(id, name) {
+        showDialogInputCamera(id, camera) {
+            camera = camera || {};
             this.showDialog({
                 title: "Input Camera",
                 content: "Input new camera's data :",
                 fields: [{
                     name: "name",
                     label: "Camera's name",
-                    value: name || "",
+                    value: camera.name || "",
+                }, {
+                    name: "type",
+                    label: "Camera's type",
+                    value: camera.type || "cygnus",
                 }, {
                     name: "url",
-                    label: "Domain URL",
-                    value: "",
+                    label: "Domain URL or stream URL",
+                    value: camera.url || "",
+                }, {
+                    name: "onvifUrl",
+                    label: "ONVIF URL for PTZ (optional)",
+                    value: camera.onvifUrl || "",
+                }, {
+                    name: "connectTimeout",
+                    label: "Connect timeout in seconds (optional)",
+                    type: "number",
+                    value: camera.connectTimeout || "",
+                }, {
+                    name: "readTimeout",
+                    label: "Read timeout in seconds (optional)",
+                    type: "number",
+                    value: camera.readTimeout || "",
                 }, {
                     name: "username",
                     label: "Username",
-                    value: "",
+                    value: camera.username || "",
                 }, {
                     name: "password",
                     label: "Password",
                     type: "password",
-                    value: "",
+                    value: camera.password || "",
                 }, {
                     name: "repeat",
                     label: "Repeat password",
                     type: "password",
-                    value: "",
+                    value: camera.password || "",
                 }],
                 mainText: "OK",
                 secondText: "Cancel",
@@ -99,14 +156,17 @@ export default {
                         return;
                     }
 
-                    if (data.username === "") {
-                        this.showErrorDialog("Username must not empty");
-                        return;
-                    }
+                    // Only Cygnus camera requires login, other camera may not need it
+                    if (data.type === "" || data.type === "cygnus") {
+                        if (data.username === "") {
+                            this.showErrorDialog("Username must not empty");
+                            return;
+                        }
 
-                    if (data.password === "") {
-                        this.showErrorDialog("Password must not empty");
-                        return;
+                        if (data.password === "") {
+                            this.showErrorDialog("Password must not empty");
+                            return;
+                        }
                     }
 
                     if (data.password !== data.repeat) {
@@ -114,7 +174,18 @@ export default {
                         return;
                     }
 
+                    var connectTimeout = Number(data.connectTimeout || 0),
+                        readTimeout = Number(data.readTimeout || 0);
+
+                    if (!Number.isInteger(connectTimeout) || connectTimeout < 0 ||
+                        !Number.isInteger(readTimeout) || readTimeout < 0) {
+                        this.showErrorDialog("Timeout must be a positive number");
+                        return;
+                    }
+
                     data.id = (id || "") + "";
+                    data.connectTimeout = connectTimeout;
+                    data.readTimeout = readTimeout;
 
                     this.dialog.loading = true;
                     fetch("/api/camera", {
@@ -133,7 +204,10 @@ export default {
                             this.dialog.loading = false;
                             this.dialog.visible = false;
                             body.text().then(id => {
-                                Vue.set(this.cameras, id, data.name);
+                                Vue.set(this.cameras, id, {
+                                    name: data.name,
+                                    type: data.type || "cygnus",
+                                });
                             })
                         })
                         .catch(err => {
@@ -145,6 +219,54 @@ export default {
                 }
             });
         },
+        showDialogDiscoverCameras() {
+            this.showDialog({
+                title: "Discover Cameras",
+                content: "Find ONVIF cameras in local network. Input the cameras' credential, if any :",
+                fields: [{
+                    name: "username",
+                    label: "Username",
+                    value: "",
+                }, {
+                    name: "password",
+                    label: "Password",
+                    type: "password",
+                    value: "",
+                }],
+                mainText: "Discover",
+                secondText: "Cancel",
+                mainClick: (data) => {
+                    this.dialog.loading = true;
+                    fetch("/api/camera/discover", {
+                            method: "post",
+                            body: JSON.stringify(data),
+                            credentials: "include",
+                            headers: {
+                                "Content-Type": "application/json",
+                            },
+                        })
+                        .then(response => {
+                            if (!response.ok) throw response;
+                            return response.json();
+                        })
+                        .then(json => {
+                            this.dialog.loading = false;
+                            this.dialog.visible = false;
+                            this.discovered = json;
+
+                            if (json.length === 0) {
+                                this.showErrorDialog("No camera found in local network");
+                            }
+                        })
+                        .catch(err => {
+                            this.dialog.loading = false;
+                            err.text().then(msg => {
+                                this.showErrorDialog(`${msg} (${err.status})`);
+                            })
+                        });
+                }
+            });
+        },
         showDialogDeleteCamera(id, name) {
             this.showDialog({
                 title: "Delete Camera",
@@ -175,5 +297,10 @@ export default {
     },
     mounted() {
         this.loadCameras();
+        this.loadStatuses();
+        this.statusInterval = setInterval(this.loadStatuses, 30000);
+    },
+    beforeDestroy() {
+        clearInterval(this.statusInterval);
     }
 }
\ No newline at end of file
@@ -11,7 +11,9 @@ var template = `
             <summary>Users</summary>
             <ul>
                 <li v-if="users.length === 0">No user registered</li>
-                <li v-for="(user, idx) in users">{{user}} <a title="Delete user" @click="showDialogDeleteUser(user, idx)">
+                <li v-for="(user, idx) in users">{{user.username}} ({{userAccess(user)}}) <a title="Edit user" @click="showDialogEditUser(user, idx)">
+                    <i class="fa fas fa-fw fa-pencil-alt"></i>
+                </a> <a title="Delete user" @click="showDialogDeleteUser(user.username, idx)">
                     <i class="fa fas fa-fw fa-trash-alt"></i>
                 </a></li>
             </ul>
@@ -40,6 +42,24 @@ export default {
         }
     },
     methods: {
+        userAccess(user) {
+            if (user.role === "admin") return "admin";
+            var cameras = (user.cameras || []).join(", ") || "no camera";
+            return `${user.role} of ${cameras}`;
+        },
+        parseUserAccess(data) {
+            var role = data.role.trim().toLowerCase() || "viewer";
+            if (["admin", "operator", "viewer"].indexOf(role) === -1) {
+                this.showErrorDialog("Role must be admin, operator or viewer");
+                return null;
+            }
+
+            var cameras = data.cameras.split(",")
+                .map(camID => camID.trim())
+                .filter(camID => camID !== "");
+
+            return { role: role, cameras: cameras };
+        },
         loadSetting() {
             this.loading = true;
 
@@ -77,6 +97,14 @@ export default {
                     label: "Repeat password",
                     type: "password",
                     value: "",
+                }, {
+                    name: "role",
+                    label: "Role (admin, operator or viewer)",
+                    value: "viewer",
+                }, {
+                    name: "cameras",
+                    label: "Allowed camera IDs, separated by comma",
+                    value: "",
                 }],
                 mainText: "OK",
                 secondText: "Cancel",
@@ -96,10 +124,90 @@ export default {
                         return;
                     }
 
+                    var access = this.parseUserAccess(data);
+                    if (access == null) return;
+
+                    var user = {
+                        username: data.username,
+                        password: data.password,
+                        role: access.role,
+                        cameras: access.cameras,
+                    };
+
                     this.dialog.loading = true;
                     fetch("/api/user", {
                             method: "post",
-                            body: JSON.stringify(data),
+                            body: JSON.stringify(user),
+                            credentials: "include",
+                            headers: {
+                                "Content-Type": "application/json",
+                            },
+                        })
+                        .then(response => {
+                            if (!response.ok) throw response;
+                            return response;
+                        })
+                        .then(() => {
+                            this.dialog.loading = false;
+                            this.dialog.visible = false;
+                            delete user.password;
+                            this.users.push(user);
+                            this.users.sort((a, b) => a.username.localeCompare(b.username));
+                        })
+                        .catch(err => {
+                            this.dialog.loading = false;
+                            err.text().then(msg => {
+                                this.showErrorDialog(`${msg} (${err.status})`);
+                            })
+                        });
+                }
+            });
+        },
+        showDialogEditUser(user, idx) {
+            this.showDialog({
+                title: "Edit User",
+                content: `Edit access of user "${user.username}" :`,
+                fields: [{
+                    name: "role",
+                    label: "Role (admin, operator or viewer)",
+                    value: user.role,
+                }, {
+                    name: "cameras",
+                    label: "Allowed camera IDs, separated by comma",
+                    value: (user.cameras || []).join(", "),
+                }, {
+                    name: "password",
+                    label: "New password (optional)",
+                    type: "password",
+                    value: "",
+                }, {
+                    name: "repeat",
+                    label: "Repeat new password",
+                    type: "password",
+                    value: "",
+                }],
+                mainText: "OK",
+                secondText: "Cancel",
+                mainClick: (data) => {
+                    if (data.password !== data.repeat) {
+                        this.showErrorDialog("Password does not match");
+                        return;
+                    }
+
+                    var access = this.parseUserAccess(data);
+                    if (access == null) return;
+
+                    var newUser = {
+                        username: user.username,
+                        password: data.password,
+                        role: access.role,
+                        cameras: access.cameras,
+                    };
+
+                    this.dialog.loading = true;
+                    fetch(`/api/user/${user.username}`, {
+                            method: "put",
+                            body: JSON.stringify(newUser),
                             credentials: "include",
                             headers: {
                                 "Content-Type": "application/json",
@@ -112,8 +220,8 @@ export default {
                         .then(() => {
                             this.dialog.loading = false;
                             this.dialog.visible = false;
-                            this.users.push(data.username);
-                            this.users.sort();
+                            delete newUser.password;
+                            this.users.splice(idx, 1, newUser);
                         })
                         .catch(err => {
                             this.dialog.loading = false;